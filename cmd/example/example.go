@@ -3,7 +3,6 @@ package main
 import (
 	"bytes"
 	"fmt"
-	"strconv"
 	"time"
 
 	"go.n16f.net/ejson"
@@ -85,25 +84,24 @@ func (e *Example) hAPIPingGET(h *shttp.Handler) {
 	h.ReplyText(200, "pong")
 }
 
-func (e *Example) hAPIHelloNameGET(h *shttp.Handler) {
-	n := 1
+type HelloParameters struct {
+	Name string `path:"name"`
+	N    int    `query:"n"`
+}
 
-	if h.HasQueryParameter("n") {
-		i64, err := strconv.ParseInt(h.QueryParameter("n"), 10, 64)
-		if err != nil || i64 < 1 || i64 > 10 {
-			msg := fmt.Sprintf("invalid value for query parameter %q\n", "n")
-			h.ReplyText(400, msg)
-			return
-		}
+func (p *HelloParameters) ValidateJSON(v *ejson.Validator) {
+	v.CheckIntMinMax("n", p.N, 1, 10)
+}
 
-		n = int(i64)
+func (e *Example) hAPIHelloNameGET(h *shttp.Handler) {
+	params := HelloParameters{N: 1}
+	if err := h.RequestParameters(&params); err != nil {
+		return
 	}
 
-	name := h.PathVariable("name")
-
 	var response bytes.Buffer
-	for i := 0; i < n; i++ {
-		fmt.Fprintf(&response, "Hello %s!\n", name)
+	for i := 0; i < params.N; i++ {
+		fmt.Fprintf(&response, "Hello %s!\n", params.Name)
 	}
 
 	h.ReplyText(200, response.String())
@@ -11,6 +11,7 @@ require (
 	go.n16f.net/program v0.0.0-20260409112752-a081c4918366
 	go.n16f.net/uuid v0.0.0-20251120121934-372c52119b7f
 	golang.org/x/exp v0.0.0-20260611194520-c48552f49976
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -25,5 +26,4 @@ require (
 	golang.org/x/sys v0.46.0 // indirect
 	golang.org/x/term v0.44.0 // indirect
 	golang.org/x/text v0.38.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
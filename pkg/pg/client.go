@@ -12,6 +12,7 @@ import (
 	"go.n16f.net/ejson"
 	"go.n16f.net/log"
 	"go.n16f.net/service/pkg/influx"
+	"go.n16f.net/service/pkg/tracing"
 )
 
 const (
@@ -24,9 +25,10 @@ var (
 )
 
 type ClientCfg struct {
-	Log          *log.Logger    `json:"-"`
-	InfluxClient *influx.Client `json:"-"`
-	Name         string         `json:"-"`
+	Log          *log.Logger     `json:"-"`
+	InfluxClient *influx.Client  `json:"-"`
+	Tracer       *tracing.Tracer `json:"-"`
+	Name         string          `json:"-"`
 
 	URI             string `json:"uri"`
 	ApplicationName string `json:"application_name,omitempty"`
@@ -35,6 +37,10 @@ type ClientCfg struct {
 
 	ConnectionAcquisitionTimeout int `json:"connection_acquisition_timeout,omitempty"` // milliseconds
 
+	// Log all queries with their execution time and the id of the request
+	// they are executed for, if there is one (see WithConnContext).
+	LogQueries bool `json:"log_queries,omitempty"`
+
 	SchemaDirectory string   `json:"schema_directory"`
 	SchemaNames     []string `json:"schema_names"`
 }
@@ -102,6 +108,15 @@ func NewClient(cfg ClientCfg) (*Client, error) {
 	poolCfg.MaxConnIdleTime = 10 * time.Minute
 	poolCfg.MaxConnLifetimeJitter = time.Second
 
+	if cfg.LogQueries || cfg.Tracer != nil {
+		poolCfg.ConnConfig.Tracer = &queryTracer{
+			Log:        cfg.Log.Child("query", nil),
+			LogQueries: cfg.LogQueries,
+			Tracer:     cfg.Tracer,
+			Database:   poolCfg.ConnConfig.Database,
+		}
+	}
+
 	cfg.Log.Info("connecting to database %q at %s:%d as %q",
 		poolCfg.ConnConfig.Database,
 		poolCfg.ConnConfig.Host,
@@ -169,19 +184,38 @@ func (c *Client) Close() {
 }
 
 func (c *Client) WithConn(fn func(Conn) error) error {
-	return c.withConn(fn)
+	return c.withConn(context.Background(), fn)
+}
+
+// WithConnContext is similar to WithConn, but queries executed with the
+// connection, either directly or with functions such as Exec or QueryObject,
+// use the context passed as argument. The context is used for cancellation
+// and to associate queries with the request they are executed for.
+func (c *Client) WithConnContext(ctx context.Context, fn func(Conn) error) error {
+	return c.withConn(ctx, fn)
 }
 
 func (c *Client) WithTx(fn func(Conn) error) error {
-	return c.withConn(func(conn Conn) error {
-		ctx := context.Background()
+	return c.withTx(context.Background(), fn)
+}
 
+// WithTxContext is the WithTx equivalent of WithConnContext.
+func (c *Client) WithTxContext(ctx context.Context, fn func(Conn) error) error {
+	return c.withTx(ctx, fn)
+}
+
+func (c *Client) withTx(ctx context.Context, fn func(Conn) error) error {
+	return c.withConn(ctx, func(conn Conn) error {
 		if _, err := conn.Exec(ctx, "BEGIN"); err != nil {
 			return fmt.Errorf("cannot begin transaction: %w", err)
 		}
 
 		if err := fn(conn); err != nil {
-			if _, rbErr := conn.Exec(ctx, "ROLLBACK"); rbErr != nil {
+			// The context may have been canceled, but we still need to
+			// rollback the transaction.
+			rbCtx := context.WithoutCancel(ctx)
+
+			if _, rbErr := conn.Exec(rbCtx, "ROLLBACK"); rbErr != nil {
 				// There is nothing we can do here, and we do want to return the
 				// function error, so we simply log the rollback error.
 				//
@@ -202,12 +236,12 @@ func (c *Client) WithTx(fn func(Conn) error) error {
 	})
 }
 
-func (c *Client) withConn(fn func(Conn) error) error {
-	ctx, cancel := context.WithTimeout(context.Background(),
+func (c *Client) withConn(ctx context.Context, fn func(Conn) error) error {
+	acquisitionCtx, cancel := context.WithTimeout(ctx,
 		c.connectionAcquisitionTimeout)
 	defer cancel()
 
-	conn, err := c.Pool.Acquire(ctx)
+	conn, err := c.Pool.Acquire(acquisitionCtx)
 	if err != nil {
 		// We would like to detect connection errors to return them clearly
 		// identified, but pgx is yet another one of those libraries hiding
@@ -224,7 +258,7 @@ func (c *Client) withConn(fn func(Conn) error) error {
 	}
 	defer conn.Release()
 
-	return fn(conn)
+	return fn(&contextConn{Conn: conn, ctx: ctx})
 }
 
 func TakeAdvisoryTxLock(conn Conn, id1, id2 uint32) error {
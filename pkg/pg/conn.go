@@ -14,6 +14,22 @@ type Conn interface {
 	QueryRow(context.Context, string, ...interface{}) pgx.Row
 }
 
+// contextConn is a connection associated with a context used by query
+// functions instead of the background context.
+type contextConn struct {
+	Conn
+
+	ctx context.Context
+}
+
+func connContext(conn Conn) context.Context {
+	if c, ok := conn.(*contextConn); ok {
+		return c.ctx
+	}
+
+	return context.Background()
+}
+
 type Object interface {
 	FromRow(pgx.Row) error
 }
@@ -23,13 +39,13 @@ type Objects interface {
 }
 
 func Exec(conn Conn, query string, args ...interface{}) (err error) {
-	ctx := context.Background()
+	ctx := connContext(conn)
 	_, err = conn.Exec(ctx, query, args...)
 	return
 }
 
 func Exec2(conn Conn, query string, args ...interface{}) (int64, error) {
-	ctx := context.Background()
+	ctx := connContext(conn)
 
 	tag, err := conn.Exec(ctx, query, args...)
 	if err != nil {
@@ -40,23 +56,23 @@ func Exec2(conn Conn, query string, args ...interface{}) (int64, error) {
 }
 
 func Query(conn Conn, query string, args ...interface{}) (pgx.Rows, error) {
-	ctx := context.Background()
+	ctx := connContext(conn)
 	return conn.Query(ctx, query, args...)
 }
 
 func QueryRow(conn Conn, query string, args ...interface{}) pgx.Row {
-	ctx := context.Background()
+	ctx := connContext(conn)
 	return conn.QueryRow(ctx, query, args...)
 }
 
 func QueryObject(conn Conn, obj Object, query string, args ...interface{}) error {
-	ctx := context.Background()
+	ctx := connContext(conn)
 	row := conn.QueryRow(ctx, query, args...)
 	return obj.FromRow(row)
 }
 
 func QueryObjects(conn Conn, objs Objects, query string, args ...interface{}) error {
-	ctx := context.Background()
+	ctx := connContext(conn)
 
 	rows, err := conn.Query(ctx, query, args...)
 	if err != nil {
@@ -150,6 +150,8 @@ loop:
 					default:
 						// Do not block all subscribers is one is particularly
 						// slow.
+						l.Log.Error("dropping notification: subscription " +
+							"buffer full")
 					}
 				}
 			}()
@@ -185,13 +187,21 @@ func (c *Client) ensureListener(channel string) (*Listener, error) {
 }
 
 func (c *Client) Listen(channel string) (*NotificationSubscription, error) {
+	return c.ListenWithBufferSize(channel, 1)
+}
+
+// ListenWithBufferSize is the equivalent of Listen with a custom size for the
+// channel of the subscription. Notifications are dropped if the channel is
+// full, so subscribers which cannot afford to lose notifications during
+// bursts should use a large buffer.
+func (c *Client) ListenWithBufferSize(channel string, bufferSize int) (*NotificationSubscription, error) {
 	listener, err := c.ensureListener(channel)
 	if err != nil {
 		return nil, err
 	}
 
 	sub := NotificationSubscription{
-		C: make(chan string, 1),
+		C: make(chan string, max(bufferSize, 1)),
 
 		listener: listener,
 	}
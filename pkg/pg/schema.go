@@ -3,6 +3,7 @@ package pg
 import (
 	"context"
 	"fmt"
+	"slices"
 )
 
 const AdvisoryLockId1 uint32 = 0x0100
@@ -24,6 +25,16 @@ func (c *Client) UpdateSchema(schema, dirPath string) error {
 		return nil
 	}
 
+	return c.ApplyMigrations(schema, migrations)
+}
+
+// ApplyMigrations applies migrations which have not been applied yet for a
+// schema. It is used by UpdateSchema and can be called directly for
+// migrations which are not stored in a directory, e.g. migrations provided
+// by a library.
+func (c *Client) ApplyMigrations(schema string, migrations Migrations) error {
+	migrations = slices.Clone(migrations)
+
 	err := c.WithTx(func(conn Conn) error {
 		// Take a lock to make sure only one application tries to update the
 		// schema at the same time.
@@ -0,0 +1,104 @@
+package pg
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.n16f.net/log"
+	"go.n16f.net/service/pkg/tracing"
+	"go.n16f.net/service/pkg/utils"
+)
+
+type queryTraceContextKey struct{}
+
+type queryTrace struct {
+	start time.Time
+	sql   string
+	span  *tracing.Span
+}
+
+// queryTracer logs queries and creates tracing spans for them.
+type queryTracer struct {
+	Log        *log.Logger
+	LogQueries bool
+	Tracer     *tracing.Tracer
+	Database   string
+}
+
+func (t *queryTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	trace := queryTrace{
+		start: time.Now(),
+		sql:   data.SQL,
+	}
+
+	// We only create spans for queries executed as part of a trace; a span
+	// for each query executed in the background would only be noise.
+	if _, ok := tracing.SpanContextFromContext(ctx); ok {
+		ctx, trace.span = t.Tracer.StartSpan(ctx, queryOperationName(data.SQL),
+			tracing.SpanKindClient)
+
+		trace.span.SetAttribute("db.system.name", "postgresql")
+		trace.span.SetAttribute("db.namespace", t.Database)
+		trace.span.SetAttribute("db.query.text", data.SQL)
+	}
+
+	return context.WithValue(ctx, queryTraceContextKey{}, &trace)
+}
+
+func (t *queryTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	trace, ok := ctx.Value(queryTraceContextKey{}).(*queryTrace)
+	if !ok {
+		return
+	}
+
+	if trace.span != nil {
+		trace.span.SetError(data.Err)
+		trace.span.End()
+	}
+
+	if t.LogQueries {
+		t.logQuery(ctx, trace, data.Err)
+	}
+}
+
+func (t *queryTracer) logQuery(ctx context.Context, trace *queryTrace, err error) {
+	queryTime := time.Since(trace.start)
+
+	logData := log.Data{
+		"event": "pg.query",
+		"time":  queryTime.Microseconds(),
+	}
+
+	if id := utils.RequestIdFromContext(ctx); id != "" {
+		logData["request_id"] = id
+	}
+
+	if sc, ok := tracing.SpanContextFromContext(ctx); ok {
+		logData["trace_id"] = sc.TraceId.String()
+	}
+
+	// Queries are usually formatted on multiple lines, which is not very
+	// practical in logs.
+	sql := strings.Join(strings.Fields(trace.sql), " ")
+
+	if err != nil {
+		t.Log.ErrorData(logData, "%s %s: %v", sql,
+			utils.FormatSeconds(queryTime.Seconds(), 1), err)
+	} else {
+		t.Log.InfoData(logData, "%s %s", sql,
+			utils.FormatSeconds(queryTime.Seconds(), 1))
+	}
+}
+
+// queryOperationName returns the first keyword of a query (e.g. "SELECT")
+// to be used as span name.
+func queryOperationName(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "QUERY"
+	}
+
+	return strings.ToUpper(fields[0])
+}
@@ -136,3 +136,58 @@ func DecryptAES256(inputData []byte, key AES256Key) ([]byte, error) {
 
 	return outputData, nil
 }
+
+func EncryptAES256GCM(inputData []byte, key AES256Key, additionalData []byte) ([]byte, error) {
+	aead, err := newAES256GCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := aead.NonceSize()
+
+	outputData := make([]byte, nonceSize, nonceSize+len(inputData)+aead.Overhead())
+
+	nonce := outputData[:nonceSize]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("cannot generate nonce: %w", err)
+	}
+
+	return aead.Seal(outputData, nonce, inputData, additionalData), nil
+}
+
+func DecryptAES256GCM(inputData []byte, key AES256Key, additionalData []byte) ([]byte, error) {
+	aead, err := newAES256GCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := aead.NonceSize()
+
+	if len(inputData) < nonceSize+aead.Overhead() {
+		return nil, fmt.Errorf("truncated data")
+	}
+
+	nonce := inputData[:nonceSize]
+	encryptedData := inputData[nonceSize:]
+
+	outputData, err := aead.Open(nil, nonce, encryptedData, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt data: %w", err)
+	}
+
+	return outputData, nil
+}
+
+func newAES256GCM(key AES256Key) (cipher.AEAD, error) {
+	blockCipher, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, fmt.Errorf("cannot create cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(blockCipher)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create GCM cipher: %w", err)
+	}
+
+	return aead, nil
+}
@@ -53,3 +53,36 @@ func TestAES256InvalidData(t *testing.T) {
 	_, err = DecryptAES256(append(iv, []byte("foo")...), key)
 	assert.Error(err)
 }
+
+func TestAES256GCM(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	keyHex := "28278b7c0a25f01d3cab639633b9487f9ea1e9a2176dc9595a3f01323aa44284"
+	var key AES256Key
+	require.NoError(key.FromHex(keyHex))
+
+	data := []byte("Hello world!")
+	encryptedData, err := EncryptAES256GCM(data, key, []byte("foo"))
+	require.NoError(err)
+
+	decryptedData, err := DecryptAES256GCM(encryptedData, key, []byte("foo"))
+	require.NoError(err)
+	require.Equal(data, decryptedData)
+
+	_, err = DecryptAES256GCM(encryptedData, key, []byte("bar"))
+	assert.Error(err)
+
+	var otherKey AES256Key
+	copy(otherKey[:], RandomBytes(32))
+
+	_, err = DecryptAES256GCM(encryptedData, otherKey, []byte("foo"))
+	assert.Error(err)
+
+	encryptedData[len(encryptedData)-1] ^= 0xff
+	_, err = DecryptAES256GCM(encryptedData, key, []byte("foo"))
+	assert.Error(err)
+
+	_, err = DecryptAES256GCM([]byte("foo"), key, nil)
+	assert.Error(err)
+}
@@ -18,6 +18,7 @@ import (
 	"go.n16f.net/service/pkg/influx"
 	"go.n16f.net/service/pkg/pg"
 	"go.n16f.net/service/pkg/shttp"
+	"go.n16f.net/service/pkg/tracing"
 )
 
 type ServiceImplementation interface {
@@ -45,6 +46,8 @@ type ServiceCfg struct {
 
 	Influx *influx.ClientCfg `json:"influx"`
 
+	Tracing *tracing.TracerCfg `json:"tracing"`
+
 	PgClients map[string]*pg.ClientCfg `json:"pg_clients"`
 
 	HTTPClients map[string]*shttp.ClientCfg `json:"http_clients"`
@@ -71,6 +74,8 @@ type Service struct {
 
 	Influx *influx.Client
 
+	Tracer *tracing.Tracer
+
 	PgClients map[string]*pg.Client
 
 	HTTPClients map[string]*shttp.Client
@@ -83,6 +88,11 @@ type Service struct {
 	TextTemplate *texttemplate.Template
 	HTMLTemplate *htmltemplate.Template
 
+	// HTML templates cannot be cloned once executed, so we keep a copy of
+	// the HTML template set which is never executed to be able to bind
+	// request-specific functions.
+	htmlTemplateBase *htmltemplate.Template
+
 	stopChan        chan struct{} // used to interrupt wait()
 	errorChan       chan error    // used to signal a fatal error
 	terminationChan chan struct{} // used to wait for termination in Stop()
@@ -95,6 +105,8 @@ func (cfg *ServiceCfg) ValidateJSON(v *ejson.Validator) {
 
 	v.CheckOptionalObject("influx", cfg.Influx)
 
+	v.CheckOptionalObject("tracing", cfg.Tracing)
+
 	v.Push("pg_clients")
 	for name, clientCfg := range cfg.PgClients {
 		v.CheckObject(name, clientCfg)
@@ -146,6 +158,7 @@ func (s *Service) init() error {
 		s.initLogger,
 		s.initTemplates,
 		s.initInflux,
+		s.initTracing,
 		s.initPgClients,
 		s.initHTTPServers,
 		s.initHTTPClients,
@@ -205,8 +218,14 @@ func (s *Service) initTemplates() error {
 		return fmt.Errorf("cannot load templates: %w", err)
 	}
 
+	htmlTemplateBase, err := htmlTemplate.Clone()
+	if err != nil {
+		return fmt.Errorf("cannot clone HTML templates: %w", err)
+	}
+
 	s.TextTemplate = textTemplate
 	s.HTMLTemplate = htmlTemplate
+	s.htmlTemplateBase = htmlTemplateBase
 
 	return nil
 }
@@ -241,13 +260,75 @@ func (s *Service) initInflux() error {
 	return nil
 }
 
+func (s *Service) initTracing() error {
+	if s.Cfg.Tracing == nil {
+		return nil
+	}
+
+	// The HTTP client used to export spans must not have a tracer, or each
+	// export would produce new spans.
+	httpClientCfg := shttp.ClientCfg{
+		LogRequests: s.Cfg.Tracing.LogRequests,
+	}
+
+	httpClient, err := shttp.NewClient(httpClientCfg)
+	if err != nil {
+		return fmt.Errorf("cannot create tracing HTTP client: %w", err)
+	}
+
+	cfg := *s.Cfg.Tracing
+
+	cfg.Log = s.Log.Child("tracing", log.Data{})
+	cfg.HTTPClient = httpClient.Client
+	cfg.ServiceName = s.Name
+	cfg.Hostname = s.Hostname
+
+	tracer, err := tracing.NewTracer(cfg)
+	if err != nil {
+		return fmt.Errorf("cannot create tracer: %w", err)
+	}
+
+	s.Tracer = tracer
+
+	return nil
+}
+
 func (s *Service) initHTTPServers() error {
 	for name, serverCfg := range s.Cfg.HTTPServers {
 		serverCfg.Log = s.Log.Child("http_server", log.Data{"server": name})
 		serverCfg.ErrorChan = s.ErrorChan()
 		serverCfg.InfluxClient = s.Influx
+		serverCfg.Tracer = s.Tracer
 		serverCfg.Name = name
 
+		if serverCfg.HTMLTemplates == nil {
+			serverCfg.HTMLTemplates = s.htmlTemplateBase
+		}
+
+		if sessionCfg := serverCfg.Sessions; sessionCfg != nil {
+			if storeCfg := sessionCfg.PgStore; storeCfg != nil {
+				client, found := s.PgClients[storeCfg.Client]
+				if !found {
+					return fmt.Errorf("unknown pg client %q for the session "+
+						"store of HTTP server %q", storeCfg.Client, name)
+				}
+
+				store, err := shttp.NewPgSessionStore(client,
+					storeCfg.TableName)
+				if err != nil {
+					return fmt.Errorf("cannot create session store for "+
+						"HTTP server %q: %w", name, err)
+				}
+
+				if err := store.UpdateSchema(); err != nil {
+					return fmt.Errorf("cannot update session store schema "+
+						"for HTTP server %q: %w", name, err)
+				}
+
+				sessionCfg.Store = store
+			}
+		}
+
 		server, err := shttp.NewServer(*serverCfg)
 		if err != nil {
 			return fmt.Errorf("cannot create HTTP server %q: %w", name, err)
@@ -265,6 +346,9 @@ func (s *Service) initHTTPClients() error {
 		clientCfg.Header.Set("User-Agent", s.Name)
 
 		clientCfg.Log = s.Log.Child("http_client", log.Data{"client": name})
+		clientCfg.InfluxClient = s.Influx
+		clientCfg.Tracer = s.Tracer
+		clientCfg.Name = name
 
 		client, err := shttp.NewClient(*clientCfg)
 		if err != nil {
@@ -299,6 +383,8 @@ func (s *Service) initWorkers() error {
 		}
 
 		workerCfg.Log = s.Log.Child("worker", log.Data{"worker": name})
+		workerCfg.Tracer = s.Tracer
+		workerCfg.Name = name
 
 		worker, err := NewWorker(*workerCfg)
 		if err != nil {
@@ -316,6 +402,7 @@ func (s *Service) initPgClients() error {
 	for name, clientCfg := range s.Cfg.PgClients {
 		clientCfg.Log = s.Log.Child("pg", log.Data{"client": name})
 		clientCfg.InfluxClient = s.Influx
+		clientCfg.Tracer = s.Tracer
 		clientCfg.Name = name
 
 		if clientCfg.SchemaDirectory == "" {
@@ -341,6 +428,10 @@ func (s *Service) start() error {
 		s.Influx.Start()
 	}
 
+	if s.Tracer != nil {
+		s.Tracer.Start()
+	}
+
 	if err := s.Implementation.Start(s); err != nil {
 		return err
 	}
@@ -423,6 +514,10 @@ func (s *Service) stop() error {
 
 	s.stopPgClients()
 
+	if s.Tracer != nil {
+		s.Tracer.Stop()
+	}
+
 	if s.Influx != nil {
 		s.Influx.Stop()
 	}
@@ -667,6 +762,7 @@ func (s *Service) Worker(name string) *Worker {
 func (s *Service) AddTemplateFunctions(functions map[string]interface{}) {
 	s.TextTemplate.Funcs(functions)
 	s.HTMLTemplate.Funcs(functions)
+	s.htmlTemplateBase.Funcs(functions)
 }
 
 func (s *Service) RenderTextTemplate(name string, data interface{}) ([]byte, error) {
@@ -688,3 +784,11 @@ func (s *Service) RenderHTMLTemplate(name string, data interface{}) ([]byte, err
 
 	return buf.Bytes(), nil
 }
+
+// RenderRequestHTMLTemplate renders an HTML template with functions bound to
+// an HTTP request handler (e.g. csrfToken). It is slower than
+// RenderHTMLTemplate since the template set has to be cloned and escaped
+// again for each call.
+func (s *Service) RenderRequestHTMLTemplate(h *shttp.Handler, name string, data interface{}) ([]byte, error) {
+	return h.RenderHTMLTemplate(s.htmlTemplateBase, name, data)
+}
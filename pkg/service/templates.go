@@ -10,6 +10,7 @@ import (
 	"strings"
 	texttemplate "text/template"
 
+	"go.n16f.net/service/pkg/shttp"
 	"go.n16f.net/service/pkg/text"
 	"go.n16f.net/service/pkg/utils"
 )
@@ -19,6 +20,8 @@ var builtinTemplateFunctions = map[string]interface{}{
 
 	"capitalize": text.Capitalize,
 	"toSentence": text.ToSentence,
+
+	"csrfToken": shttp.CSRFTokenTemplateFunction,
 }
 
 func LoadTemplates(dirPath string, templateFunctions map[string]interface{}) (*texttemplate.Template, *htmltemplate.Template, error) {
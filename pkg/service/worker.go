@@ -1,27 +1,33 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"time"
 
 	"go.n16f.net/log"
 	"go.n16f.net/program"
+	"go.n16f.net/service/pkg/tracing"
 )
 
 type WorkerFunc func(*Worker) (time.Duration, error)
 
 type WorkerCfg struct {
-	Log          *log.Logger `json:"-"`
-	WorkerFunc   WorkerFunc  `json:"-"`
-	Disabled     bool        `json:"disabled"`
-	InitialDelay int         `json:"initial_delay"` // seconds
+	Log          *log.Logger     `json:"-"`
+	Tracer       *tracing.Tracer `json:"-"`
+	Name         string          `json:"-"`
+	WorkerFunc   WorkerFunc      `json:"-"`
+	Disabled     bool            `json:"disabled"`
+	InitialDelay int             `json:"initial_delay"` // seconds
 }
 
 type Worker struct {
 	Cfg WorkerCfg
 	Log *log.Logger
 
+	ctx context.Context
+
 	wakeupChan chan struct{}
 	stopChan   chan struct{}
 	wg         sync.WaitGroup
@@ -36,6 +42,8 @@ func NewWorker(cfg WorkerCfg) (*Worker, error) {
 		Cfg: cfg,
 		Log: cfg.Log,
 
+		ctx: context.Background(),
+
 		wakeupChan: make(chan struct{}),
 		stopChan:   make(chan struct{}),
 	}
@@ -69,12 +77,20 @@ func (w *Worker) main() {
 		delay := 5 * time.Second
 
 		func() {
+			ctx, span := w.Cfg.Tracer.StartSpan(context.Background(),
+				"worker "+w.Cfg.Name, tracing.SpanKindInternal)
+			defer span.End()
+
+			w.ctx = ctx
+			defer func() { w.ctx = context.Background() }()
+
 			defer func() {
 				if v := recover(); v != nil {
 					msg := program.RecoverValueString(v)
 					trace := program.StackTrace(0, 20, true)
 
 					w.Log.Error("panic: %s\n%s", msg, trace)
+					span.SetStatus(tracing.SpanStatusError, "panic: "+msg)
 				}
 			}()
 
@@ -82,6 +98,7 @@ func (w *Worker) main() {
 			delay, err = w.Cfg.WorkerFunc(w)
 			if err != nil {
 				w.Log.Error("%v", err)
+				span.SetError(err)
 			}
 		}()
 
@@ -102,6 +119,13 @@ func (w *Worker) main() {
 	}
 }
 
+// Context returns the context of the current execution of the worker
+// function. It contains the tracing span of the execution and should be
+// used for all operations performed by the worker function.
+func (w *Worker) Context() context.Context {
+	return w.ctx
+}
+
 func (w *Worker) WakeUp() {
 	// Note how we do not wait for the worker to read the channel. If it is not
 	// currently sleeping (i.e. it is executing the worker function), there is
@@ -2,12 +2,17 @@ package shttp
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
+
+	"go.n16f.net/ejson"
+	"go.n16f.net/service/pkg/utils"
 )
 
 type APIClientErrorHandler func(*http.Response, []byte) error
@@ -15,7 +20,31 @@ type APIClientErrorHandler func(*http.Response, []byte) error
 type APIClientCfg struct {
 	Client      *http.Client          `json:"-"`
 	HandleError APIClientErrorHandler `json:"-"`
-	BaseURI     string                `json:"base_uri"`
+
+	// Additional request body encoders indexed by media type. Encoders for
+	// JSON, form-urlencoded and multipart bodies are always available.
+	Encoders map[string]APIRequestEncoder `json:"-"`
+
+	// The credential provider is created from the credentials configuration
+	// if it is not set.
+	CredentialProvider APICredentialProvider `json:"-"`
+	Signer             APIRequestSigner      `json:"-"`
+
+	BaseURI     string             `json:"base_uri"`
+	Credentials *APICredentialsCfg `json:"credentials"`
+
+	// The media type used to encode request bodies when the request does
+	// not have a Content-Type header field. The default is
+	// "application/json".
+	ContentType string `json:"content_type"`
+}
+
+func (cfg *APIClientCfg) ValidateJSON(v *ejson.Validator) {
+	if cfg.BaseURI != "" {
+		v.CheckStringURI("base_uri", cfg.BaseURI)
+	}
+
+	v.CheckOptionalObject("credentials", cfg.Credentials)
 }
 
 type APIClient struct {
@@ -30,16 +59,72 @@ type APIClient struct {
 	baseURI *url.URL
 }
 
+// APIError is returned when the server responds with an error status. If the
+// response body contains a JSONError, it is decoded and can be obtained with
+// errors.As.
+type APIError struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+
+	JSONError *JSONError
+}
+
+func (err *APIError) Error() string {
+	var msg string
+	if err.JSONError != nil {
+		msg = err.JSONError.Message
+	} else {
+		msg = string(err.Body)
+	}
+
+	return fmt.Sprintf("request failed with status %d: %s", err.StatusCode,
+		msg)
+}
+
+func (err *APIError) Unwrap() error {
+	if err.JSONError == nil {
+		return nil
+	}
+
+	return err.JSONError
+}
+
 func NewAPIClient(cfg APIClientCfg) (*APIClient, error) {
 	if cfg.HandleError == nil {
 		cfg.HandleError = HandleAPIClientError
 	}
 
+	encoders := map[string]APIRequestEncoder{
+		"application/json":                  EncodeAPIRequestJSON,
+		"application/x-www-form-urlencoded": EncodeAPIRequestForm,
+		"multipart/form-data":               EncodeAPIRequestMultipart,
+	}
+
+	for mediaType, encoder := range cfg.Encoders {
+		encoders[mediaType] = encoder
+	}
+	cfg.Encoders = encoders
+
+	if cfg.ContentType == "" {
+		cfg.ContentType = "application/json"
+	}
+
 	baseURI, err := url.Parse(cfg.BaseURI)
 	if err != nil {
 		return nil, fmt.Errorf("invalid base URI: %w", err)
 	}
 
+	if cfg.CredentialProvider == nil && cfg.Credentials != nil {
+		provider, err := newAPICredentialProvider(cfg.Credentials, cfg.Client)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create credential provider: %w",
+				err)
+		}
+
+		cfg.CredentialProvider = provider
+	}
+
 	c := APIClient{
 		Cfg:     cfg,
 		baseURI: baseURI,
@@ -49,10 +134,141 @@ func NewAPIClient(cfg APIClientCfg) (*APIClient, error) {
 }
 
 func (c *APIClient) SendRequest(method, uriRefString string, reqBody, resBody any) (*http.Response, error) {
-	return c.SendRequestWithHeader(method, uriRefString, nil, reqBody, resBody)
+	ctx := context.Background()
+	return c.SendRequestWithHeaderContext(ctx, method, uriRefString, nil,
+		reqBody, resBody)
+}
+
+func (c *APIClient) SendRequestContext(ctx context.Context, method, uriRefString string, reqBody, resBody any) (*http.Response, error) {
+	return c.SendRequestWithHeaderContext(ctx, method, uriRefString, nil,
+		reqBody, resBody)
 }
 
 func (c *APIClient) SendRequestWithHeader(method, uriRefString string, header http.Header, reqBody, resBody any) (*http.Response, error) {
+	ctx := context.Background()
+	return c.SendRequestWithHeaderContext(ctx, method, uriRefString, header,
+		reqBody, resBody)
+}
+
+// SendRequestWithHeaderContext sends a request and reads the response body.
+// The error handler of the client is called with the response body for all
+// responses. If resBody is a pointer to a byte slice, it is set to the raw
+// response body; otherwise the response body, if there is one, is decoded as
+// JSON.
+func (c *APIClient) SendRequestWithHeaderContext(ctx context.Context, method, uriRefString string, header http.Header, reqBody, resBody any) (*http.Response, error) {
+	res, err := c.sendRequest(ctx, method, uriRefString, header, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	resBodyData, err := io.ReadAll(res.Body)
+	if err != nil {
+		return res, fmt.Errorf("cannot read response body: %w", err)
+	}
+
+	// We want the caller to be able to decode the response body again if
+	// necessary.
+	res.Body = io.NopCloser(bytes.NewBuffer(resBodyData))
+
+	if err := c.Cfg.HandleError(res, resBodyData); err != nil {
+		return res, err
+	}
+
+	switch v := resBody.(type) {
+	case nil:
+	case *[]byte:
+		*v = resBodyData
+	default:
+		if len(resBodyData) == 0 {
+			break
+		}
+
+		if err := json.Unmarshal(resBodyData, resBody); err != nil {
+			return res, fmt.Errorf("cannot decode response body: %w", err)
+		}
+	}
+
+	return res, nil
+}
+
+// SendStreamingRequest sends a request and returns the response without
+// reading its body, for example to process large downloads without
+// buffering them. The caller is responsible for closing the response body.
+//
+// As with SendRequest, the error handler of the client is called for all
+// responses. Since the body of successful (2xx) responses must not be
+// consumed, the error handler is called with a nil body for them. The body of
+// other responses is read and buffered so that the caller can read it again.
+func (c *APIClient) SendStreamingRequest(ctx context.Context, method, uriRefString string, header http.Header, reqBody any) (*http.Response, error) {
+	res, err := c.sendRequest(ctx, method, uriRefString, header, reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	if status := res.StatusCode; status >= 200 && status < 300 {
+		if err := c.Cfg.HandleError(res, nil); err != nil {
+			res.Body.Close()
+			return res, err
+		}
+
+		return res, nil
+	}
+
+	defer res.Body.Close()
+
+	resBodyData, err := io.ReadAll(res.Body)
+	if err != nil {
+		return res, fmt.Errorf("cannot read response body: %w", err)
+	}
+
+	res.Body = io.NopCloser(bytes.NewBuffer(resBodyData))
+
+	if err := c.Cfg.HandleError(res, resBodyData); err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
+// sendRequest sends a request and returns the response without reading its
+// body or calling the error handler.
+func (c *APIClient) sendRequest(ctx context.Context, method, uriRefString string, header http.Header, reqBody any) (*http.Response, error) {
+	req, err := c.NewRequest(ctx, method, uriRefString, header, reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.Cfg.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot send request: %w", err)
+	}
+
+	// Credentials may have been revoked or may have expired early; if we can
+	// obtain new ones, we try again once.
+	invalidator, ok := c.Cfg.CredentialProvider.(APICredentialInvalidator)
+	if res.StatusCode == 401 && ok && reusableRequestBody(reqBody) {
+		discardResponse(res)
+		invalidator.InvalidateCredentials()
+
+		req, err = c.NewRequest(ctx, method, uriRefString, header, reqBody)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err = c.Cfg.Client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("cannot send request: %w", err)
+		}
+	}
+
+	return res, nil
+}
+
+// NewRequest creates a request for the API. If reqBody is not an io.Reader,
+// it is encoded with the encoder associated with the media type of the
+// Content-Type header field, or with the default content type of the client.
+func (c *APIClient) NewRequest(ctx context.Context, method, uriRefString string, header http.Header, reqBody any) (*http.Request, error) {
 	uriRef, err := url.Parse(uriRefString)
 	if err != nil {
 		return nil, fmt.Errorf("invalid URI reference: %w", err)
@@ -61,11 +277,29 @@ func (c *APIClient) SendRequestWithHeader(method, uriRefString string, header ht
 	uri := c.baseURI.ResolveReference(uriRef)
 
 	var reqBodyReader io.Reader
+	var contentType string
+
 	if reqBody != nil {
 		if r, ok := reqBody.(io.Reader); ok {
 			reqBodyReader = r
 		} else {
-			data, err := json.Marshal(reqBody)
+			mediaType := c.Cfg.ContentType
+			if value := header.Get("Content-Type"); value != "" {
+				mediaType, _, err = mime.ParseMediaType(value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid content type %q: %w",
+						value, err)
+				}
+			}
+
+			encoder, found := c.Cfg.Encoders[mediaType]
+			if !found {
+				return nil, fmt.Errorf("no request body encoder available "+
+					"for media type %q", mediaType)
+			}
+
+			var data []byte
+			data, contentType, err = encoder(reqBody)
 			if err != nil {
 				return nil, fmt.Errorf("cannot encode request body: %w", err)
 			}
@@ -74,7 +308,8 @@ func (c *APIClient) SendRequestWithHeader(method, uriRefString string, header ht
 		}
 	}
 
-	req, err := http.NewRequest(method, uri.String(), reqBodyReader)
+	req, err := http.NewRequestWithContext(ctx, method, uri.String(),
+		reqBodyReader)
 	if err != nil {
 		return nil, fmt.Errorf("cannot create request: %w", err)
 	}
@@ -85,61 +320,76 @@ func (c *APIClient) SendRequestWithHeader(method, uriRefString string, header ht
 		}
 	}
 
-	if c.BasicUsername != "" {
-		req.SetBasicAuth(c.BasicUsername, c.BasicPassword)
+	// Encoders can add parameters to the content type, e.g. the boundary
+	// of multipart bodies.
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
 	}
 
-	if c.BearerToken != "" {
-		req.Header.Add("Authorization", "Bearer "+c.BearerToken)
+	if err := c.authenticateRequest(req); err != nil {
+		return nil, err
 	}
 
-	if c.Cookie != nil {
-		req.Header.Add("Cookie", c.Cookie.String())
+	// The round tripper of shttp clients also does it, but the HTTP client
+	// of the API client may be a standard one.
+	if req.Header.Get("X-Request-Id") == "" {
+		if id := utils.RequestIdFromContext(ctx); id != "" {
+			req.Header.Set("X-Request-Id", id)
+		}
 	}
 
-	res, err := c.Cfg.Client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("cannot send request: %w", err)
-	}
-	defer res.Body.Close()
+	return req, nil
+}
 
-	resBodyData, err := io.ReadAll(res.Body)
-	if err != nil {
-		return res, fmt.Errorf("cannot read response body: %w", err)
+func HandleAPIClientError(res *http.Response, body []byte) error {
+	if status := res.StatusCode; status >= 200 && status < 400 {
+		return nil
 	}
 
-	// We want the caller to be able to decode the response body again if
-	// necessary.
-	res.Body = io.NopCloser(bytes.NewBuffer(resBodyData))
-
-	if err := c.Cfg.HandleError(res, resBodyData); err != nil {
-		return res, err
+	apiErr := APIError{
+		StatusCode: res.StatusCode,
+		Header:     res.Header,
+		Body:       body,
 	}
 
-	if resBody != nil {
-		if err := json.Unmarshal(resBodyData, resBody); err != nil {
-			return res, fmt.Errorf("cannot decode response body: %w", err)
-		}
+	var jsonErr JSONError
+	err := json.Unmarshal(body, &jsonErr)
+	if err == nil && jsonErr.Message != "" {
+		apiErr.JSONError = &jsonErr
 	}
 
-	return res, nil
+	return &apiErr
 }
 
-func HandleAPIClientError(res *http.Response, body []byte) error {
-	if status := res.StatusCode; status >= 200 && status < 400 {
-		return nil
-	}
+// IsAPIErrorStatus returns true if err is an APIError with a specific status
+// code.
+func IsAPIErrorStatus(err error, status int) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == status
+}
+
+func Get[Res any](ctx context.Context, c *APIClient, uriRef string) (Res, error) {
+	return sendTypedAPIRequest[Res](ctx, c, "GET", uriRef, nil)
+}
 
-	var baseError error
+func Post[Req, Res any](ctx context.Context, c *APIClient, uriRef string, reqBody Req) (Res, error) {
+	return sendTypedAPIRequest[Res](ctx, c, "POST", uriRef, reqBody)
+}
 
-	var apiError JSONError
-	err := json.Unmarshal(body, &apiError)
-	if err == nil && apiError.Message != "" {
-		baseError = &apiError
-	} else {
-		baseError = errors.New(string(body))
-	}
+func Put[Req, Res any](ctx context.Context, c *APIClient, uriRef string, reqBody Req) (Res, error) {
+	return sendTypedAPIRequest[Res](ctx, c, "PUT", uriRef, reqBody)
+}
+
+func Patch[Req, Res any](ctx context.Context, c *APIClient, uriRef string, reqBody Req) (Res, error) {
+	return sendTypedAPIRequest[Res](ctx, c, "PATCH", uriRef, reqBody)
+}
+
+func Delete[Res any](ctx context.Context, c *APIClient, uriRef string) (Res, error) {
+	return sendTypedAPIRequest[Res](ctx, c, "DELETE", uriRef, nil)
+}
 
-	return fmt.Errorf("request failed with status %d: %w",
-		res.StatusCode, baseError)
+func sendTypedAPIRequest[Res any](ctx context.Context, c *APIClient, method, uriRef string, reqBody any) (Res, error) {
+	var resBody Res
+	_, err := c.SendRequestContext(ctx, method, uriRef, reqBody, &resBody)
+	return resBody, err
 }
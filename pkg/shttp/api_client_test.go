@@ -0,0 +1,219 @@
+package shttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testAPIObject struct {
+	Name  string `json:"name"`
+	Value int    `json:"value"`
+}
+
+func newTestAPIClient(t *testing.T, handler http.HandlerFunc) *APIClient {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client, err := NewAPIClient(APIClientCfg{
+		Client:  server.Client(),
+		BaseURI: server.URL,
+	})
+	require.NoError(t, err)
+
+	return client
+}
+
+func TestAPIClientTypedRequests(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	client := newTestAPIClient(t, func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/objects/foo":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"name": "foo", "value": 42}`))
+
+		case "/objects":
+			var obj testAPIObject
+			json.NewDecoder(req.Body).Decode(&obj)
+			obj.Value++
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(obj)
+
+		case "/empty":
+			w.WriteHeader(204)
+
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(404)
+			w.Write([]byte(`{"code": "not_found", "message": "not found"}`))
+		}
+	})
+
+	ctx := context.Background()
+
+	obj, err := Get[testAPIObject](ctx, client, "/objects/foo")
+	require.NoError(err)
+	assert.Equal(testAPIObject{Name: "foo", Value: 42}, obj)
+
+	obj, err = Post[testAPIObject, testAPIObject](ctx, client, "/objects",
+		testAPIObject{Name: "bar", Value: 1})
+	require.NoError(err)
+	assert.Equal(testAPIObject{Name: "bar", Value: 2}, obj)
+
+	_, err = Delete[struct{}](ctx, client, "/empty")
+	require.NoError(err)
+
+	_, err = Get[testAPIObject](ctx, client, "/unknown")
+	require.Error(err)
+
+	var apiErr *APIError
+	if assert.ErrorAs(err, &apiErr) {
+		assert.Equal(404, apiErr.StatusCode)
+	}
+
+	var jsonErr *JSONError
+	if assert.ErrorAs(err, &jsonErr) {
+		assert.Equal("not_found", jsonErr.Code)
+	}
+
+	assert.True(IsAPIErrorStatus(err, 404))
+}
+
+func TestAPIClientEncoders(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var contentType string
+	var form url.Values
+	var fileContent string
+
+	client := newTestAPIClient(t, func(w http.ResponseWriter, req *http.Request) {
+		contentType = req.Header.Get("Content-Type")
+
+		if strings.HasPrefix(contentType, "multipart/") {
+			if err := req.ParseMultipartForm(1_000_000); err != nil {
+				w.WriteHeader(400)
+				return
+			}
+
+			if file, _, err := req.FormFile("file"); err == nil {
+				data, _ := io.ReadAll(file)
+				fileContent = string(data)
+				file.Close()
+			}
+		} else if err := req.ParseForm(); err != nil {
+			w.WriteHeader(400)
+			return
+		}
+
+		form = req.PostForm
+		w.WriteHeader(204)
+	})
+
+	header := make(http.Header)
+	header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	_, err := client.SendRequestWithHeader("POST", "/", header,
+		map[string]string{"a": "1", "b": "2"}, nil)
+	require.NoError(err)
+
+	assert.Equal("application/x-www-form-urlencoded", contentType)
+	assert.Equal("1", form.Get("a"))
+	assert.Equal("2", form.Get("b"))
+
+	header.Set("Content-Type", "multipart/form-data")
+
+	_, err = client.SendRequestWithHeader("POST", "/", header,
+		&MultipartForm{
+			Fields: url.Values{"a": {"1"}},
+			Files: []MultipartFile{{
+				FieldName: "file",
+				FileName:  "hello.txt",
+				Content:   strings.NewReader("Hello world!"),
+			}},
+		}, nil)
+	require.NoError(err)
+
+	assert.True(strings.HasPrefix(contentType, "multipart/form-data; "))
+	assert.Equal("1", form.Get("a"))
+	assert.Equal("Hello world!", fileContent)
+
+	header.Set("Content-Type", "text/plain")
+
+	_, err = client.SendRequestWithHeader("POST", "/", header, 42, nil)
+	assert.Error(err)
+}
+
+func TestAPIClientStreaming(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	client := newTestAPIClient(t, func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/error" {
+			w.WriteHeader(500)
+			w.Write([]byte("internal error"))
+			return
+		}
+
+		w.Write([]byte(strings.Repeat("x", 100_000)))
+	})
+
+	ctx := context.Background()
+
+	res, err := client.SendStreamingRequest(ctx, "GET", "/", nil, nil)
+	require.NoError(err)
+	defer res.Body.Close()
+
+	n, err := io.Copy(io.Discard, res.Body)
+	require.NoError(err)
+	assert.Equal(int64(100_000), n)
+
+	res, err = client.SendStreamingRequest(ctx, "GET", "/error", nil, nil)
+	require.Error(err)
+	assert.Equal(500, res.StatusCode)
+
+	var jsonErr *JSONError
+	assert.False(errors.As(err, &jsonErr))
+
+	data, err := io.ReadAll(res.Body)
+	require.NoError(err)
+	assert.Equal("internal error", string(data))
+
+	// The error handler is also called for successful responses
+	var handledBody []byte
+	handlerCalled := false
+
+	client.Cfg.HandleError = func(res *http.Response, body []byte) error {
+		handledBody = body
+		handlerCalled = true
+		return errors.New("rejected")
+	}
+
+	_, err = client.SendStreamingRequest(ctx, "GET", "/", nil, nil)
+	assert.EqualError(err, "rejected")
+	assert.True(handlerCalled)
+	assert.Nil(handledBody)
+
+	// Buffered requests pass the body of successful responses to the error
+	// handler
+	handlerCalled = false
+
+	_, err = client.SendRequestContext(ctx, "GET", "/", nil, nil)
+	assert.EqualError(err, "rejected")
+	assert.True(handlerCalled)
+	assert.Equal(strings.Repeat("x", 100_000), string(handledBody))
+}
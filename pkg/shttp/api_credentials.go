@@ -0,0 +1,420 @@
+package shttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.n16f.net/ejson"
+)
+
+const (
+	DefaultOAuth2TokenRefreshMargin = 60 // seconds
+)
+
+var errUnreplayableRequestBody = errors.New("request body cannot be read " +
+	"without being consumed")
+
+// APICredentialProvider adds credentials to requests sent by an API client.
+type APICredentialProvider interface {
+	Authenticate(*http.Request) error
+}
+
+// APICredentialInvalidator is implemented by credential providers whose
+// credentials can expire before we expect them to. When a request fails with
+// status 401, the client invalidates the credentials and sends the request
+// again once.
+type APICredentialInvalidator interface {
+	InvalidateCredentials()
+}
+
+// APIRequestSigner is called after credentials have been added, with the
+// encoded request body, so that requests can be signed, for example with an
+// HMAC of their content. Requests whose body is an io.Reader which cannot be
+// read again (see http.Request.GetBody) cannot be signed and are rejected.
+type APIRequestSigner func(req *http.Request, body []byte) error
+
+type APICredentialsCfg struct {
+	Basic       *BasicCredentialsCfg        `json:"basic"`
+	BearerToken string                      `json:"bearer_token"`
+	OAuth2      *OAuth2ClientCredentialsCfg `json:"oauth2"`
+}
+
+type BasicCredentialsCfg struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func (cfg *APICredentialsCfg) ValidateJSON(v *ejson.Validator) {
+	var names []string
+
+	if cfg.Basic != nil {
+		names = append(names, "basic")
+	}
+
+	if cfg.BearerToken != "" {
+		names = append(names, "bearer_token")
+	}
+
+	if cfg.OAuth2 != nil {
+		names = append(names, "oauth2")
+	}
+
+	if len(names) > 1 {
+		v.AddError(names[1], "incompatible_credentials",
+			"%s credentials cannot be used with %s credentials",
+			names[1], names[0])
+	}
+
+	v.CheckOptionalObject("basic", cfg.Basic)
+	v.CheckOptionalObject("oauth2", cfg.OAuth2)
+}
+
+func (cfg *BasicCredentialsCfg) ValidateJSON(v *ejson.Validator) {
+	v.CheckStringNotEmpty("username", cfg.Username)
+}
+
+func newAPICredentialProvider(cfg *APICredentialsCfg, client *http.Client) (APICredentialProvider, error) {
+	switch {
+	case cfg.Basic != nil:
+		return &BasicCredentialProvider{
+			Username: cfg.Basic.Username,
+			Password: cfg.Basic.Password,
+		}, nil
+
+	case cfg.BearerToken != "":
+		return &BearerCredentialProvider{Token: cfg.BearerToken}, nil
+
+	case cfg.OAuth2 != nil:
+		return NewOAuth2ClientCredentialProvider(*cfg.OAuth2, client)
+
+	default:
+		return nil, nil
+	}
+}
+
+type BasicCredentialProvider struct {
+	Username string
+	Password string
+}
+
+func (p *BasicCredentialProvider) Authenticate(req *http.Request) error {
+	req.SetBasicAuth(p.Username, p.Password)
+	return nil
+}
+
+type BearerCredentialProvider struct {
+	Token string
+}
+
+func (p *BearerCredentialProvider) Authenticate(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+p.Token)
+	return nil
+}
+
+// OAuth2 client credentials grant (RFC 6749 4.4).
+
+type OAuth2ClientAuthentication string
+
+const (
+	// Client credentials are sent with HTTP basic authentication.
+	OAuth2ClientAuthenticationBasic OAuth2ClientAuthentication = "basic"
+
+	// Client credentials are sent in the request body.
+	OAuth2ClientAuthenticationPost OAuth2ClientAuthentication = "post"
+)
+
+var OAuth2ClientAuthenticationValues = []OAuth2ClientAuthentication{
+	OAuth2ClientAuthenticationBasic,
+	OAuth2ClientAuthenticationPost,
+}
+
+type OAuth2ClientCredentialsCfg struct {
+	TokenURI     string   `json:"token_uri"`
+	ClientId     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	Scopes       []string `json:"scopes"`
+
+	// Additional parameters sent in token requests, for example "audience".
+	Parameters map[string]string `json:"parameters"`
+
+	ClientAuthentication OAuth2ClientAuthentication `json:"client_authentication"`
+
+	// Tokens are refreshed when they are about to expire in less than the
+	// refresh margin.
+	RefreshMargin *int `json:"refresh_margin"` // seconds
+}
+
+func (cfg *OAuth2ClientCredentialsCfg) ValidateJSON(v *ejson.Validator) {
+	v.CheckStringURI("token_uri", cfg.TokenURI)
+	v.CheckStringNotEmpty("client_id", cfg.ClientId)
+
+	if cfg.ClientAuthentication != "" {
+		v.CheckStringValue("client_authentication", cfg.ClientAuthentication,
+			OAuth2ClientAuthenticationValues)
+	}
+
+	if cfg.RefreshMargin != nil {
+		v.CheckIntMin("refresh_margin", *cfg.RefreshMargin, 0)
+	}
+}
+
+type OAuth2ClientCredentialProvider struct {
+	Cfg    OAuth2ClientCredentialsCfg
+	Client *http.Client
+
+	token       string
+	tokenType   string
+	expiration  time.Time
+	refreshTime time.Time
+	tokenLock   sync.Mutex
+}
+
+type oauth2TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+type oauth2ErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func NewOAuth2ClientCredentialProvider(cfg OAuth2ClientCredentialsCfg, client *http.Client) (*OAuth2ClientCredentialProvider, error) {
+	if cfg.TokenURI == "" {
+		return nil, fmt.Errorf("missing or empty token URI")
+	}
+
+	if cfg.ClientAuthentication == "" {
+		cfg.ClientAuthentication = OAuth2ClientAuthenticationBasic
+	}
+
+	if cfg.RefreshMargin == nil {
+		margin := DefaultOAuth2TokenRefreshMargin
+		cfg.RefreshMargin = &margin
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	p := OAuth2ClientCredentialProvider{
+		Cfg:    cfg,
+		Client: client,
+	}
+
+	return &p, nil
+}
+
+func (p *OAuth2ClientCredentialProvider) Authenticate(req *http.Request) error {
+	token, tokenType, err := p.Token(req.Context())
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", tokenType+" "+token)
+	return nil
+}
+
+func (p *OAuth2ClientCredentialProvider) InvalidateCredentials() {
+	p.tokenLock.Lock()
+	defer p.tokenLock.Unlock()
+
+	p.token = ""
+}
+
+// Token returns the current access token and its type, fetching a new one
+// if necessary. The lock is held during the token request so that concurrent
+// requests wait for the same token instead of fetching their own.
+func (p *OAuth2ClientCredentialProvider) Token(ctx context.Context) (string, string, error) {
+	p.tokenLock.Lock()
+	defer p.tokenLock.Unlock()
+
+	now := time.Now()
+
+	if p.token != "" && now.Before(p.refreshTime) {
+		return p.token, p.tokenType, nil
+	}
+
+	tokenRes, err := p.fetchToken(ctx)
+	if err != nil {
+		// If the current token is still valid, we can keep using it and try
+		// again on the next request.
+		if p.token != "" && now.Before(p.expiration) {
+			return p.token, p.tokenType, nil
+		}
+
+		return "", "", err
+	}
+
+	p.token = tokenRes.AccessToken
+
+	// Token types are case-insensitive, but some servers only accept the
+	// canonical "Bearer" form.
+	p.tokenType = tokenRes.TokenType
+	if p.tokenType == "" || strings.EqualFold(p.tokenType, "bearer") {
+		p.tokenType = "Bearer"
+	}
+
+	if tokenRes.ExpiresIn > 0 {
+		lifetime := time.Duration(tokenRes.ExpiresIn) * time.Second
+		margin := time.Duration(*p.Cfg.RefreshMargin) * time.Second
+
+		p.expiration = now.Add(lifetime)
+		p.refreshTime = now.Add(max(lifetime-margin, lifetime/2))
+	} else {
+		// No expiration: the token is used until the server rejects it
+		p.expiration = time.Time{}
+		p.refreshTime = now.Add(100 * 365 * 24 * time.Hour)
+	}
+
+	return p.token, p.tokenType, nil
+}
+
+func (p *OAuth2ClientCredentialProvider) fetchToken(ctx context.Context) (*oauth2TokenResponse, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+
+	if len(p.Cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(p.Cfg.Scopes, " "))
+	}
+
+	for name, value := range p.Cfg.Parameters {
+		form.Set(name, value)
+	}
+
+	if p.Cfg.ClientAuthentication == OAuth2ClientAuthenticationPost {
+		form.Set("client_id", p.Cfg.ClientId)
+		form.Set("client_secret", p.Cfg.ClientSecret)
+	}
+
+	body := strings.NewReader(form.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.Cfg.TokenURI, body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create token request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	if p.Cfg.ClientAuthentication == OAuth2ClientAuthenticationBasic {
+		req.SetBasicAuth(url.QueryEscape(p.Cfg.ClientId),
+			url.QueryEscape(p.Cfg.ClientSecret))
+	}
+
+	res, err := p.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot send token request: %w", err)
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read token response: %w", err)
+	}
+
+	if res.StatusCode != 200 {
+		var errRes oauth2ErrorResponse
+		if err := json.Unmarshal(data, &errRes); err == nil &&
+			errRes.Error != "" {
+			if errRes.ErrorDescription != "" {
+				return nil, fmt.Errorf("token request failed with status "+
+					"%d: %s: %s", res.StatusCode, errRes.Error,
+					errRes.ErrorDescription)
+			}
+
+			return nil, fmt.Errorf("token request failed with status %d: %s",
+				res.StatusCode, errRes.Error)
+		}
+
+		return nil, fmt.Errorf("token request failed with status %d",
+			res.StatusCode)
+	}
+
+	var tokenRes oauth2TokenResponse
+	if err := json.Unmarshal(data, &tokenRes); err != nil {
+		return nil, fmt.Errorf("cannot decode token response: %w", err)
+	}
+
+	if tokenRes.AccessToken == "" {
+		return nil, fmt.Errorf("missing or empty access token in token " +
+			"response")
+	}
+
+	return &tokenRes, nil
+}
+
+// authenticateRequest runs the authentication pipeline of the client: static
+// credentials, the credential provider and finally the request signer.
+func (c *APIClient) authenticateRequest(req *http.Request) error {
+	if c.BasicUsername != "" {
+		req.SetBasicAuth(c.BasicUsername, c.BasicPassword)
+	}
+
+	if c.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.BearerToken)
+	}
+
+	if c.Cookie != nil {
+		req.Header.Add("Cookie", c.Cookie.String())
+	}
+
+	if provider := c.Cfg.CredentialProvider; provider != nil {
+		if err := provider.Authenticate(req); err != nil {
+			return fmt.Errorf("cannot authenticate request: %w", err)
+		}
+	}
+
+	if signer := c.Cfg.Signer; signer != nil {
+		var body []byte
+
+		// Signing a body we cannot read without consuming it would mean
+		// either sending an empty body or signing the wrong content.
+		if req.GetBody == nil && req.Body != nil && req.Body != http.NoBody {
+			return fmt.Errorf("cannot sign request: %w",
+				errUnreplayableRequestBody)
+		}
+
+		if req.GetBody != nil {
+			r, err := req.GetBody()
+			if err != nil {
+				return fmt.Errorf("cannot read request body: %w", err)
+			}
+
+			body, err = io.ReadAll(r)
+			if err != nil {
+				return fmt.Errorf("cannot read request body: %w", err)
+			}
+		}
+
+		if err := signer(req, body); err != nil {
+			return fmt.Errorf("cannot sign request: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// reusableRequestBody returns true if the same request body can be encoded
+// again to resend a request.
+func reusableRequestBody(reqBody any) bool {
+	switch v := reqBody.(type) {
+	case io.Reader:
+		return false
+	case MultipartForm:
+		return len(v.Files) == 0
+	case *MultipartForm:
+		return len(v.Files) == 0
+	default:
+		return true
+	}
+}
@@ -0,0 +1,172 @@
+package shttp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIClientOAuth2(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Server state is accessed by both the server and the test
+	var nbTokens int
+	var validToken string
+	var mutex sync.Mutex
+
+	getNbTokens := func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return nbTokens
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if req.URL.Path == "/token" {
+				clientId, clientSecret, _ := req.BasicAuth()
+				if clientId != "client" || clientSecret != "secret" ||
+					req.PostFormValue("grant_type") != "client_credentials" {
+					w.WriteHeader(401)
+					w.Write([]byte(`{"error": "invalid_client"}`))
+					return
+				}
+
+				nbTokens++
+				validToken = fmt.Sprintf("token-%d", nbTokens)
+
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(map[string]any{
+					"access_token": validToken,
+					"token_type":   "bearer",
+					"expires_in":   3600,
+				})
+				return
+			}
+
+			if req.Header.Get("Authorization") != "Bearer "+validToken {
+				w.WriteHeader(401)
+				return
+			}
+
+			w.WriteHeader(204)
+		}))
+	defer server.Close()
+
+	client, err := NewAPIClient(APIClientCfg{
+		Client:  server.Client(),
+		BaseURI: server.URL,
+		Credentials: &APICredentialsCfg{
+			OAuth2: &OAuth2ClientCredentialsCfg{
+				TokenURI:     server.URL + "/token",
+				ClientId:     "client",
+				ClientSecret: "secret",
+			},
+		},
+	})
+	require.NoError(err)
+
+	// The token is fetched once and cached
+	for range 3 {
+		res, err := client.SendRequest("GET", "/", nil, nil)
+		require.NoError(err)
+		assert.Equal(204, res.StatusCode)
+	}
+
+	assert.Equal(1, getNbTokens())
+
+	// If the token is revoked, a new one is fetched and the request is sent
+	// again.
+	mutex.Lock()
+	validToken = "revoked"
+	mutex.Unlock()
+
+	res, err := client.SendRequest("POST", "/", map[string]int{"a": 1}, nil)
+	require.NoError(err)
+	assert.Equal(204, res.StatusCode)
+	assert.Equal(2, getNbTokens())
+
+	// Invalid client credentials
+	client, err = NewAPIClient(APIClientCfg{
+		Client:  server.Client(),
+		BaseURI: server.URL,
+		Credentials: &APICredentialsCfg{
+			OAuth2: &OAuth2ClientCredentialsCfg{
+				TokenURI: server.URL + "/token",
+				ClientId: "unknown",
+			},
+		},
+	})
+	require.NoError(err)
+
+	_, err = client.SendRequest("GET", "/", nil, nil)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "invalid_client")
+	}
+}
+
+func TestAPIClientSigner(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	key := []byte("secret")
+
+	sign := func(method, path string, body []byte) string {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(method + "\n" + path + "\n"))
+		mac.Write(body)
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+
+	var signature, expectedSignature string
+	var signatureMutex sync.Mutex
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			signatureMutex.Lock()
+			signature = req.Header.Get("X-Signature")
+			signatureMutex.Unlock()
+
+			w.WriteHeader(204)
+		}))
+	defer server.Close()
+
+	client, err := NewAPIClient(APIClientCfg{
+		Client:  server.Client(),
+		BaseURI: server.URL,
+		Signer: func(req *http.Request, body []byte) error {
+			expectedSignature = sign(req.Method, req.URL.Path, body)
+			req.Header.Set("X-Signature", expectedSignature)
+			return nil
+		},
+	})
+	require.NoError(err)
+
+	_, err = client.SendRequest("POST", "/foo", map[string]int{"a": 1}, nil)
+	require.NoError(err)
+
+	signatureMutex.Lock()
+	assert.Equal(sign("POST", "/foo", []byte(`{"a":1}`)), signature)
+	assert.Equal(expectedSignature, signature)
+	signatureMutex.Unlock()
+
+	// Request bodies which cannot be read again cannot be signed
+	body := io.MultiReader(strings.NewReader(`{"a":1}`))
+
+	_, err = client.SendRequest("POST", "/foo", body, nil)
+	assert.ErrorIs(err, errUnreplayableRequestBody)
+}
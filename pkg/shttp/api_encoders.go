@@ -0,0 +1,140 @@
+package shttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"strings"
+)
+
+// APIRequestEncoder encodes a request body and returns the encoded data and
+// the value of the Content-Type header field.
+type APIRequestEncoder func(value any) ([]byte, string, error)
+
+func EncodeAPIRequestJSON(value any) ([]byte, string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, "application/json", nil
+}
+
+// EncodeAPIRequestForm encodes url.Values, map[string]string or
+// map[string][]string values.
+func EncodeAPIRequestForm(value any) ([]byte, string, error) {
+	values, err := formValues(value)
+	if err != nil {
+		return nil, "", err
+	}
+
+	data := []byte(values.Encode())
+	return data, "application/x-www-form-urlencoded", nil
+}
+
+type MultipartForm struct {
+	Fields url.Values
+	Files  []MultipartFile
+}
+
+type MultipartFile struct {
+	FieldName   string
+	FileName    string
+	ContentType string // default to "application/octet-stream"
+	Content     io.Reader
+}
+
+// EncodeAPIRequestMultipart encodes MultipartForm values, or values accepted
+// by EncodeAPIRequestForm if there are no files. The whole body is buffered so
+// that requests can be retried.
+func EncodeAPIRequestMultipart(value any) ([]byte, string, error) {
+	var form *MultipartForm
+
+	switch v := value.(type) {
+	case MultipartForm:
+		form = &v
+	case *MultipartForm:
+		form = v
+	default:
+		values, err := formValues(value)
+		if err != nil {
+			return nil, "", err
+		}
+
+		form = &MultipartForm{Fields: values}
+	}
+
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+
+	for name, values := range form.Fields {
+		for _, value := range values {
+			if err := w.WriteField(name, value); err != nil {
+				return nil, "", fmt.Errorf("cannot write field %q: %w",
+					name, err)
+			}
+		}
+	}
+
+	for _, file := range form.Files {
+		contentType := file.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition",
+			fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+				escapeQuotes(file.FieldName), escapeQuotes(file.FileName)))
+		header.Set("Content-Type", contentType)
+
+		pw, err := w.CreatePart(header)
+		if err != nil {
+			return nil, "", fmt.Errorf("cannot create part for file %q: %w",
+				file.FileName, err)
+		}
+
+		if _, err := io.Copy(pw, file.Content); err != nil {
+			return nil, "", fmt.Errorf("cannot write file %q: %w",
+				file.FileName, err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), w.FormDataContentType(), nil
+}
+
+func formValues(value any) (url.Values, error) {
+	switch v := value.(type) {
+	case url.Values:
+		return v, nil
+
+	case map[string][]string:
+		return url.Values(v), nil
+
+	case map[string]string:
+		values := make(url.Values, len(v))
+		for name, value := range v {
+			values.Set(name, value)
+		}
+
+		return values, nil
+
+	default:
+		return nil, fmt.Errorf("cannot encode value of type %T as form data",
+			value)
+	}
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
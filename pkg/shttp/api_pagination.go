@@ -0,0 +1,164 @@
+package shttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// APIPageExtractor extracts the items of a page and returns the URI of the
+// next page, or nil if it is the last one.
+type APIPageExtractor[T any] func(uri *url.URL, res *http.Response, body []byte) ([]T, *url.URL, error)
+
+// Paginate returns an iterator on the items of all the pages of a
+// collection, starting with the page identified by uriRef. Pages are only
+// fetched when the previous ones have been consumed. If a request fails or
+// if the context is canceled, the iterator yields the error and stops.
+func Paginate[T any](ctx context.Context, c *APIClient, uriRef string, extractor APIPageExtractor[T]) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+
+		ref, err := url.Parse(uriRef)
+		if err != nil {
+			yield(zero, fmt.Errorf("invalid URI reference: %w", err))
+			return
+		}
+
+		uri := c.baseURI.ResolveReference(ref)
+
+		for uri != nil {
+			if err := ctx.Err(); err != nil {
+				yield(zero, err)
+				return
+			}
+
+			var body []byte
+			res, err := c.SendRequestContext(ctx, "GET", uri.String(), nil,
+				&body)
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+
+			items, next, err := extractor(uri, res, body)
+			if err != nil {
+				yield(zero, fmt.Errorf("cannot extract page: %w", err))
+				return
+			}
+
+			for _, item := range items {
+				if !yield(item, nil) {
+					return
+				}
+			}
+
+			if next != nil {
+				next = uri.ResolveReference(next)
+			}
+
+			uri = next
+		}
+	}
+}
+
+// PageItems can be used as item function for pages which are JSON arrays.
+func PageItems[T any](page *[]T) []T {
+	return *page
+}
+
+// LinkPagination follows the "next" links of the Link header field (RFC
+// 8288).
+func LinkPagination[P, T any](items func(*P) []T) APIPageExtractor[T] {
+	return func(uri *url.URL, res *http.Response, body []byte) ([]T, *url.URL, error) {
+		page, err := decodePage[P](body)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var next *url.URL
+
+		links := ParseLinkHeader(res.Header.Values("Link"))
+		if link := FindLink(links, "next"); link != nil {
+			next, err = url.Parse(link.URI)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid next link URI %q: %w",
+					link.URI, err)
+			}
+		}
+
+		return items(page), next, nil
+	}
+}
+
+// CursorPagination uses a cursor extracted from each page and sent in a
+// query parameter to obtain the next page. An empty cursor indicates the
+// last page.
+func CursorPagination[P, T any](parameter string, cursor func(*P) ([]T, string)) APIPageExtractor[T] {
+	return func(uri *url.URL, res *http.Response, body []byte) ([]T, *url.URL, error) {
+		page, err := decodePage[P](body)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		items, value := cursor(page)
+		if value == "" {
+			return items, nil, nil
+		}
+
+		return items, withQueryParameter(uri, parameter, value), nil
+	}
+}
+
+// OffsetPagination increments an offset query parameter by the number of
+// items in each page. An empty page indicates the end of the collection. The
+// page size, if any, must be set in the initial URI.
+func OffsetPagination[P, T any](parameter string, items func(*P) []T) APIPageExtractor[T] {
+	return func(uri *url.URL, res *http.Response, body []byte) ([]T, *url.URL, error) {
+		page, err := decodePage[P](body)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		pageItems := items(page)
+		if len(pageItems) == 0 {
+			return nil, nil, nil
+		}
+
+		var offset int
+		if value := uri.Query().Get(parameter); value != "" {
+			offset, err = strconv.Atoi(value)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid offset %q: %w", value,
+					err)
+			}
+		}
+
+		offset += len(pageItems)
+
+		next := withQueryParameter(uri, parameter, strconv.Itoa(offset))
+		return pageItems, next, nil
+	}
+}
+
+func decodePage[P any](body []byte) (*P, error) {
+	var page P
+	if err := json.Unmarshal(body, &page); err != nil {
+		return nil, fmt.Errorf("cannot decode page: %w", err)
+	}
+
+	return &page, nil
+}
+
+func withQueryParameter(uri *url.URL, name, value string) *url.URL {
+	uri2 := *uri
+
+	query := uri2.Query()
+	query.Set(name, value)
+	uri2.RawQuery = query.Encode()
+
+	return &uri2
+}
@@ -0,0 +1,151 @@
+package shttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLinkHeader(t *testing.T) {
+	assert := assert.New(t)
+
+	links := ParseLinkHeader([]string{
+		`<https://example.com/items?a=1,2;b=3>; rel="next last"; ` +
+			`title="a, \"b\""`,
+		`invalid; rel=next, </prev>;rel=prev`,
+	})
+
+	if assert.Len(links, 2) {
+		assert.Equal("https://example.com/items?a=1,2;b=3", links[0].URI)
+		assert.Equal([]string{"next", "last"}, links[0].Rel)
+		assert.Equal(`a, "b"`, links[0].Params["title"])
+
+		assert.Equal("/prev", links[1].URI)
+		assert.Equal([]string{"prev"}, links[1].Rel)
+	}
+
+	if link := FindLink(links, "Next"); assert.NotNil(link) {
+		assert.Equal(links[0], link)
+	}
+
+	assert.Nil(FindLink(links, "first"))
+}
+
+func TestPaginate(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	const nbItems = 25
+	const pageSize = 10
+
+	pageRange := func(start int) []int {
+		var items []int
+		for i := start; i < min(start+pageSize, nbItems); i++ {
+			items = append(items, i)
+		}
+
+		return items
+	}
+
+	client := newTestAPIClient(t, func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+
+		switch req.URL.Path {
+		case "/link":
+			start, _ := strconv.Atoi(query.Get("start"))
+			if next := start + pageSize; next < nbItems {
+				w.Header().Set("Link",
+					fmt.Sprintf(`</link?start=%d>; rel="next"`, next))
+			}
+
+			json.NewEncoder(w).Encode(pageRange(start))
+
+		case "/cursor":
+			start, _ := strconv.Atoi(query.Get("cursor"))
+
+			page := map[string]any{"items": pageRange(start)}
+			if next := start + pageSize; next < nbItems {
+				page["next_cursor"] = strconv.Itoa(next)
+			}
+
+			json.NewEncoder(w).Encode(page)
+
+		case "/offset":
+			offset, _ := strconv.Atoi(query.Get("offset"))
+			json.NewEncoder(w).Encode(pageRange(offset))
+
+		default:
+			w.WriteHeader(404)
+		}
+	})
+
+	ctx := context.Background()
+
+	collect := func(extractor APIPageExtractor[int], uriRef string) ([]int, error) {
+		var items []int
+
+		for item, err := range Paginate(ctx, client, uriRef, extractor) {
+			if err != nil {
+				return items, err
+			}
+
+			items = append(items, item)
+		}
+
+		return items, nil
+	}
+
+	var expectedItems []int
+	for i := range nbItems {
+		expectedItems = append(expectedItems, i)
+	}
+
+	items, err := collect(LinkPagination(PageItems[int]), "/link")
+	require.NoError(err)
+	assert.Equal(expectedItems, items)
+
+	type cursorPage struct {
+		Items      []int  `json:"items"`
+		NextCursor string `json:"next_cursor"`
+	}
+
+	items, err = collect(CursorPagination("cursor",
+		func(page *cursorPage) ([]int, string) {
+			return page.Items, page.NextCursor
+		}), "/cursor")
+	require.NoError(err)
+	assert.Equal(expectedItems, items)
+
+	items, err = collect(OffsetPagination("offset", PageItems[int]),
+		"/offset?limit=10")
+	require.NoError(err)
+	assert.Equal(expectedItems, items)
+
+	// Errors stop iteration
+	_, err = collect(LinkPagination(PageItems[int]), "/unknown")
+	assert.True(IsAPIErrorStatus(err, 404))
+
+	// Context cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var nbIteratedItems int
+	for _, err := range Paginate(ctx, client, "/link",
+		LinkPagination(PageItems[int])) {
+		if err != nil {
+			assert.ErrorIs(err, context.Canceled)
+			break
+		}
+
+		nbIteratedItems++
+		cancel()
+	}
+
+	assert.Equal(pageSize, nbIteratedItems)
+}
@@ -0,0 +1,152 @@
+package shttp
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.n16f.net/log"
+)
+
+const (
+	DefaultCertificateReloadInterval = 10 // seconds
+)
+
+// certificateLoader keeps a TLS certificate in memory and reloads it when the
+// certificate or private key files are modified, or when the process receives
+// a SIGHUP signal. If the new files cannot be loaded, for example because
+// only one of them was updated, we keep using the current certificate.
+type certificateLoader struct {
+	Log *log.Logger
+
+	certificatePath string
+	privateKeyPath  string
+	reloadInterval  time.Duration
+
+	certificate     *tls.Certificate
+	modTimes        [2]time.Time
+	certificateLock sync.RWMutex
+
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+func newCertificateLoader(certificatePath, privateKeyPath string, reloadInterval time.Duration, logger *log.Logger) (*certificateLoader, error) {
+	l := certificateLoader{
+		Log: logger,
+
+		certificatePath: certificatePath,
+		privateKeyPath:  privateKeyPath,
+		reloadInterval:  reloadInterval,
+
+		stopChan: make(chan struct{}),
+	}
+
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+
+	return &l, nil
+}
+
+func (l *certificateLoader) start() {
+	l.wg.Add(1)
+	go l.main()
+}
+
+func (l *certificateLoader) stop() {
+	close(l.stopChan)
+	l.wg.Wait()
+}
+
+func (l *certificateLoader) main() {
+	defer l.wg.Done()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
+	defer signal.Stop(sigChan)
+
+	ticker := time.NewTicker(l.reloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stopChan:
+			return
+
+		case <-sigChan:
+			l.reload()
+
+		case <-ticker.C:
+			modTimes, err := l.fileModTimes()
+			if err != nil {
+				l.Log.Error("cannot check TLS certificate files: %v", err)
+				continue
+			}
+
+			l.certificateLock.RLock()
+			changed := modTimes != l.modTimes
+			l.certificateLock.RUnlock()
+
+			if changed {
+				l.reload()
+			}
+		}
+	}
+}
+
+func (l *certificateLoader) reload() {
+	if err := l.load(); err != nil {
+		l.Log.Error("cannot reload TLS certificate: %v", err)
+		return
+	}
+
+	l.Log.Info("TLS certificate reloaded")
+}
+
+func (l *certificateLoader) load() error {
+	modTimes, err := l.fileModTimes()
+	if err != nil {
+		return err
+	}
+
+	certificate, err := tls.LoadX509KeyPair(l.certificatePath,
+		l.privateKeyPath)
+	if err != nil {
+		return fmt.Errorf("cannot load certificate: %w", err)
+	}
+
+	l.certificateLock.Lock()
+	l.certificate = &certificate
+	l.modTimes = modTimes
+	l.certificateLock.Unlock()
+
+	return nil
+}
+
+func (l *certificateLoader) fileModTimes() (modTimes [2]time.Time, err error) {
+	for i, filePath := range []string{l.certificatePath, l.privateKeyPath} {
+		var info os.FileInfo
+
+		info, err = os.Stat(filePath)
+		if err != nil {
+			err = fmt.Errorf("cannot stat %q: %w", filePath, err)
+			return
+		}
+
+		modTimes[i] = info.ModTime()
+	}
+
+	return
+}
+
+func (l *certificateLoader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	l.certificateLock.RLock()
+	defer l.certificateLock.RUnlock()
+
+	return l.certificate, nil
+}
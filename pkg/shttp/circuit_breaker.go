@@ -0,0 +1,310 @@
+package shttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.n16f.net/ejson"
+	"go.n16f.net/log"
+	"go.n16f.net/service/pkg/influx"
+)
+
+// Circuit breakers track the failure rate of requests sent to each host. When
+// the failure rate over the window reaches the threshold, the circuit opens
+// and requests fail immediately with a CircuitOpenError. After the cooldown
+// delay, the circuit is half-open: a limited number of requests are allowed;
+// the circuit closes again if they succeed and opens again if one of them
+// fails.
+//
+// Transport errors and 5xx responses are considered as failures. Requests
+// canceled by the caller are ignored.
+
+const (
+	DefaultCircuitBreakerWindow           = 60 // seconds
+	DefaultCircuitBreakerMinRequests      = 10
+	DefaultCircuitBreakerFailureThreshold = 50 // percents
+	DefaultCircuitBreakerCooldown         = 30 // seconds
+	DefaultCircuitBreakerHalfOpenRequests = 1
+)
+
+const nbCircuitBreakerBuckets = 10
+
+var ErrCircuitOpen = errors.New("circuit open")
+
+type CircuitOpenError struct {
+	Host string
+
+	// The delay after which the circuit will be half-open.
+	RetryDelay time.Duration
+}
+
+func (err *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit open for host %q", err.Host)
+}
+
+func (err *CircuitOpenError) Unwrap() error {
+	return ErrCircuitOpen
+}
+
+type CircuitState string
+
+const (
+	CircuitStateClosed   CircuitState = "closed"
+	CircuitStateOpen     CircuitState = "open"
+	CircuitStateHalfOpen CircuitState = "half_open"
+)
+
+type CircuitBreakerCfg struct {
+	Window      int `json:"window"` // seconds
+	MinRequests int `json:"min_requests"`
+
+	// The failure rate in percents above which the circuit opens.
+	FailureThreshold int `json:"failure_threshold"`
+
+	Cooldown         int `json:"cooldown"` // seconds
+	HalfOpenRequests int `json:"half_open_requests"`
+}
+
+func (cfg *CircuitBreakerCfg) ValidateJSON(v *ejson.Validator) {
+	v.CheckIntMin("window", cfg.Window, 0)
+	v.CheckIntMin("min_requests", cfg.MinRequests, 0)
+	v.CheckIntMinMax("failure_threshold", cfg.FailureThreshold, 0, 100)
+	v.CheckIntMin("cooldown", cfg.Cooldown, 0)
+	v.CheckIntMin("half_open_requests", cfg.HalfOpenRequests, 0)
+}
+
+func (cfg *CircuitBreakerCfg) setDefaults() {
+	if cfg.Window == 0 {
+		cfg.Window = DefaultCircuitBreakerWindow
+	}
+
+	if cfg.MinRequests == 0 {
+		cfg.MinRequests = DefaultCircuitBreakerMinRequests
+	}
+
+	if cfg.FailureThreshold == 0 {
+		cfg.FailureThreshold = DefaultCircuitBreakerFailureThreshold
+	}
+
+	if cfg.Cooldown == 0 {
+		cfg.Cooldown = DefaultCircuitBreakerCooldown
+	}
+
+	if cfg.HalfOpenRequests == 0 {
+		cfg.HalfOpenRequests = DefaultCircuitBreakerHalfOpenRequests
+	}
+}
+
+type circuitBreaker struct {
+	Cfg          *CircuitBreakerCfg
+	Log          *log.Logger
+	InfluxClient *influx.Client
+	ClientName   string
+
+	circuits     map[string]*circuit
+	circuitsLock sync.Mutex
+}
+
+type circuit struct {
+	state CircuitState
+
+	// The failure window is divided in buckets so that old requests expire
+	// progressively.
+	buckets     [nbCircuitBreakerBuckets]circuitBucket
+	bucketIndex int
+	bucketStart time.Time
+
+	openingTime      time.Time
+	halfOpenRequests int
+}
+
+type circuitBucket struct {
+	nbRequests int
+	nbFailures int
+}
+
+func newCircuitBreaker(cfg *ClientCfg) *circuitBreaker {
+	return &circuitBreaker{
+		Cfg:          cfg.CircuitBreaker,
+		Log:          cfg.Log,
+		InfluxClient: cfg.InfluxClient,
+		ClientName:   cfg.Name,
+
+		circuits: make(map[string]*circuit),
+	}
+}
+
+// allow returns an error if the request cannot be sent. If it returns nil,
+// the caller must call done with the outcome of the request.
+func (b *circuitBreaker) allow(host string) error {
+	b.circuitsLock.Lock()
+	defer b.circuitsLock.Unlock()
+
+	c, found := b.circuits[host]
+	if !found {
+		c = &circuit{state: CircuitStateClosed}
+		b.circuits[host] = c
+	}
+
+	now := time.Now()
+
+	switch c.state {
+	case CircuitStateOpen:
+		cooldown := time.Duration(b.Cfg.Cooldown) * time.Second
+		if elapsed := now.Sub(c.openingTime); elapsed < cooldown {
+			return &CircuitOpenError{
+				Host:       host,
+				RetryDelay: cooldown - elapsed,
+			}
+		}
+
+		b.setState(host, c, CircuitStateHalfOpen)
+		c.halfOpenRequests = 0
+		fallthrough
+
+	case CircuitStateHalfOpen:
+		if c.halfOpenRequests >= b.Cfg.HalfOpenRequests {
+			return &CircuitOpenError{Host: host}
+		}
+
+		c.halfOpenRequests++
+	}
+
+	return nil
+}
+
+func (b *circuitBreaker) done(host string, res *http.Response, err error) {
+	if err != nil && errors.Is(err, context.Canceled) {
+		b.circuitsLock.Lock()
+		if c := b.circuits[host]; c != nil && c.state == CircuitStateHalfOpen {
+			c.halfOpenRequests--
+		}
+		b.circuitsLock.Unlock()
+
+		return
+	}
+
+	failure := err != nil || res.StatusCode >= 500
+
+	b.circuitsLock.Lock()
+	defer b.circuitsLock.Unlock()
+
+	c := b.circuits[host]
+	now := time.Now()
+
+	switch c.state {
+	case CircuitStateClosed:
+		bucket := c.currentBucket(now, b.bucketDuration())
+
+		bucket.nbRequests++
+		if failure {
+			bucket.nbFailures++
+		}
+
+		nbRequests, nbFailures := c.counts()
+		if nbRequests >= b.Cfg.MinRequests &&
+			nbFailures*100 >= nbRequests*b.Cfg.FailureThreshold {
+			b.open(host, c, now)
+		}
+
+	case CircuitStateHalfOpen:
+		if failure {
+			b.open(host, c, now)
+		} else {
+			c.reset()
+			b.setState(host, c, CircuitStateClosed)
+		}
+	}
+}
+
+func (b *circuitBreaker) bucketDuration() time.Duration {
+	window := time.Duration(b.Cfg.Window) * time.Second
+	return window / nbCircuitBreakerBuckets
+}
+
+func (b *circuitBreaker) open(host string, c *circuit, now time.Time) {
+	c.reset()
+	c.openingTime = now
+	b.setState(host, c, CircuitStateOpen)
+}
+
+func (b *circuitBreaker) setState(host string, c *circuit, state CircuitState) {
+	previousState := c.state
+	c.state = state
+
+	data := log.Data{
+		"host":           host,
+		"circuit_state":  string(state),
+		"previous_state": string(previousState),
+	}
+
+	if state == CircuitStateOpen {
+		b.Log.ErrorData(data, "circuit for host %q is now %s", host, state)
+	} else {
+		b.Log.InfoData(data, "circuit for host %q is now %s", host, state)
+	}
+
+	if b.InfluxClient != nil {
+		tags := influx.Tags{
+			"host":  host,
+			"state": string(state),
+		}
+
+		if b.ClientName != "" {
+			tags["client"] = b.ClientName
+		}
+
+		fields := influx.Fields{
+			"transitions": 1,
+		}
+
+		point := influx.NewPoint("outgoing_http_circuit_breakers", tags,
+			fields)
+		b.InfluxClient.EnqueuePoint(point)
+	}
+}
+
+func (c *circuit) currentBucket(now time.Time, bucketDuration time.Duration) *circuitBucket {
+	if c.bucketStart.IsZero() {
+		c.bucketStart = now
+	}
+
+	// Move forward and clear the buckets which have expired since the last
+	// request.
+	for i := 0; i < nbCircuitBreakerBuckets; i++ {
+		if now.Sub(c.bucketStart) < bucketDuration {
+			break
+		}
+
+		c.bucketIndex = (c.bucketIndex + 1) % nbCircuitBreakerBuckets
+		c.buckets[c.bucketIndex] = circuitBucket{}
+		c.bucketStart = c.bucketStart.Add(bucketDuration)
+	}
+
+	if now.Sub(c.bucketStart) >= bucketDuration {
+		// All buckets have expired
+		c.bucketStart = now
+	}
+
+	return &c.buckets[c.bucketIndex]
+}
+
+func (c *circuit) counts() (nbRequests, nbFailures int) {
+	for _, bucket := range c.buckets {
+		nbRequests += bucket.nbRequests
+		nbFailures += bucket.nbFailures
+	}
+
+	return
+}
+
+func (c *circuit) reset() {
+	c.buckets = [nbCircuitBreakerBuckets]circuitBucket{}
+	c.bucketIndex = 0
+	c.bucketStart = time.Time{}
+	c.halfOpenRequests = 0
+}
@@ -0,0 +1,124 @@
+package shttp
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.n16f.net/log"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var nbRequests int
+	status := 503
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			nbRequests++
+			w.WriteHeader(status)
+		}))
+	defer server.Close()
+
+	client, err := NewClient(ClientCfg{
+		Log: log.DefaultLogger("test"),
+		CircuitBreaker: &CircuitBreakerCfg{
+			MinRequests:      4,
+			FailureThreshold: 50,
+		},
+	})
+	require.NoError(err)
+
+	rt := client.Client.Transport.(*RoundTripper)
+	host := server.Listener.Addr().String()
+
+	sendRequest := func() (*http.Response, error) {
+		res, err := client.Client.Get(server.URL)
+		if err == nil {
+			res.Body.Close()
+		}
+
+		return res, err
+	}
+
+	// The circuit opens once we reach the minimum number of requests with
+	// enough failures.
+	for range 4 {
+		_, err := sendRequest()
+		require.NoError(err)
+	}
+
+	assert.Equal(4, nbRequests)
+
+	_, err = sendRequest()
+	var circuitErr *CircuitOpenError
+	if assert.ErrorAs(err, &circuitErr) {
+		assert.Equal(host, circuitErr.Host)
+		assert.Greater(circuitErr.RetryDelay, time.Duration(0))
+	}
+	assert.True(errors.Is(err, ErrCircuitOpen))
+	assert.Equal(4, nbRequests)
+
+	// After the cooldown, a failed request opens the circuit again
+	setOpeningTime := func() {
+		b := rt.circuitBreaker
+
+		b.circuitsLock.Lock()
+		b.circuits[host].openingTime = time.Now().Add(-time.Hour)
+		b.circuitsLock.Unlock()
+	}
+
+	setOpeningTime()
+
+	res, err := sendRequest()
+	require.NoError(err)
+	assert.Equal(503, res.StatusCode)
+	assert.Equal(5, nbRequests)
+
+	_, err = sendRequest()
+	assert.ErrorIs(err, ErrCircuitOpen)
+
+	// After the cooldown, a successful request closes the circuit
+	setOpeningTime()
+	status = 200
+
+	for range 3 {
+		res, err := sendRequest()
+		require.NoError(err)
+		assert.Equal(200, res.StatusCode)
+	}
+
+	assert.Equal(8, nbRequests)
+}
+
+func TestCircuitBuckets(t *testing.T) {
+	assert := assert.New(t)
+
+	var c circuit
+
+	now := time.Now()
+	bucketDuration := time.Second
+
+	c.currentBucket(now, bucketDuration).nbFailures++
+	c.currentBucket(now.Add(500*time.Millisecond), bucketDuration).nbFailures++
+
+	_, nbFailures := c.counts()
+	assert.Equal(2, nbFailures)
+
+	c.currentBucket(now.Add(3*time.Second), bucketDuration).nbFailures++
+
+	_, nbFailures = c.counts()
+	assert.Equal(3, nbFailures)
+
+	// Everything has expired after the whole window
+	c.currentBucket(now.Add(time.Minute), bucketDuration)
+
+	_, nbFailures = c.counts()
+	assert.Equal(0, nbFailures)
+}
@@ -12,6 +12,8 @@ import (
 
 	"go.n16f.net/ejson"
 	"go.n16f.net/log"
+	"go.n16f.net/service/pkg/influx"
+	"go.n16f.net/service/pkg/tracing"
 	"go.n16f.net/service/pkg/utils"
 )
 
@@ -21,7 +23,10 @@ const (
 )
 
 type ClientCfg struct {
-	Log *log.Logger `json:"-"`
+	Log          *log.Logger     `json:"-"`
+	InfluxClient *influx.Client  `json:"-"`
+	Tracer       *tracing.Tracer `json:"-"`
+	Name         string          `json:"-"`
 
 	ConnectionTimeout *int `json:"connection_timeout"` // seconds
 	RequestTimeout    *int `json:"request_timeout"`    // seconds
@@ -29,13 +34,31 @@ type ClientCfg struct {
 	LogRequests         bool `json:"log_requests"`
 	DisableRedirections bool `json:"disable_redirections"`
 
-	TLS *TLSClientCfg `json:"tls"`
+	TLS            *TLSClientCfg      `json:"tls"`
+	Retry          *RetryCfg          `json:"retry"`
+	CircuitBreaker *CircuitBreakerCfg `json:"circuit_breaker"`
 
 	Header http.Header `json:"-"`
 }
 
 type TLSClientCfg struct {
 	CACertificates []string `json:"ca_certificates"`
+
+	// Client certificates used for mutual TLS; the first certificate
+	// matching the requirements of the server is used.
+	Certificates []TLSClientCertificateCfg `json:"certificates"`
+
+	ServerName string `json:"server_name"` // overrides the host name
+	MinVersion string `json:"min_version"`
+
+	// Disabling certificate verification makes connections vulnerable to
+	// man-in-the-middle attacks; it must only be used for development.
+	InsecureSkipVerify bool `json:"insecure_skip_verify"`
+}
+
+type TLSClientCertificateCfg struct {
+	Certificate string `json:"certificate"`
+	PrivateKey  string `json:"private_key"`
 }
 
 type Client struct {
@@ -44,13 +67,52 @@ type Client struct {
 
 	Client *http.Client
 
+	dialer *net.Dialer
 	tlsCfg *tls.Config
 }
 
 func (cfg *ClientCfg) ValidateJSON(v *ejson.Validator) {
+	if cfg.ConnectionTimeout != nil {
+		v.CheckIntMin("connection_timeout", *cfg.ConnectionTimeout, 1)
+	}
+
+	if cfg.RequestTimeout != nil {
+		v.CheckIntMin("request_timeout", *cfg.RequestTimeout, 1)
+	}
+
+	v.CheckOptionalObject("tls", cfg.TLS)
+	v.CheckOptionalObject("retry", cfg.Retry)
+	v.CheckOptionalObject("circuit_breaker", cfg.CircuitBreaker)
+}
+
+func (cfg *TLSClientCfg) ValidateJSON(v *ejson.Validator) {
+	v.WithChild("ca_certificates", func() {
+		for i, filePath := range cfg.CACertificates {
+			v.CheckStringNotEmpty(i, filePath)
+		}
+	})
+
+	v.WithChild("certificates", func() {
+		for i := range cfg.Certificates {
+			v.CheckObject(i, &cfg.Certificates[i])
+		}
+	})
+
+	if cfg.MinVersion != "" {
+		v.CheckStringValue("min_version", cfg.MinVersion, TLSVersionValues)
+	}
+}
+
+func (cfg *TLSClientCertificateCfg) ValidateJSON(v *ejson.Validator) {
+	v.CheckStringNotEmpty("certificate", cfg.Certificate)
+	v.CheckStringNotEmpty("private_key", cfg.PrivateKey)
 }
 
 func NewClient(cfg ClientCfg) (*Client, error) {
+	if cfg.Log == nil {
+		cfg.Log = log.DefaultLogger("http_client")
+	}
+
 	if cfg.ConnectionTimeout == nil {
 		cfg.ConnectionTimeout = utils.Ref(DefaultClientConnectionTimeout)
 	}
@@ -59,13 +121,15 @@ func NewClient(cfg ClientCfg) (*Client, error) {
 		cfg.RequestTimeout = utils.Ref(DefaultClientRequestTimeout)
 	}
 
+	dialer := &net.Dialer{
+		Timeout:   time.Duration(*cfg.ConnectionTimeout) * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
 	transport := &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
 
-		DialContext: (&net.Dialer{
-			Timeout:   time.Duration(*cfg.ConnectionTimeout) * time.Second,
-			KeepAlive: 30 * time.Second,
-		}).DialContext,
+		DialContext: dialer.DialContext,
 
 		MaxIdleConns: 100,
 
@@ -76,12 +140,9 @@ func NewClient(cfg ClientCfg) (*Client, error) {
 	tlsCfg := &tls.Config{}
 
 	if cfg.TLS != nil {
-		caCertificatePool, err := LoadCertificates(cfg.TLS.CACertificates)
-		if err != nil {
+		if err := initClientTLSConfig(tlsCfg, cfg.TLS, cfg.Log); err != nil {
 			return nil, err
 		}
-
-		tlsCfg.RootCAs = caCertificatePool
 	}
 
 	client := &http.Client{
@@ -110,6 +171,7 @@ func NewClient(cfg ClientCfg) (*Client, error) {
 
 		Client: client,
 
+		dialer: dialer,
 		tlsCfg: tlsCfg,
 	}
 
@@ -128,11 +190,8 @@ func (c *Client) Do(req *http.Request) (*http.Response, error) {
 
 func (c *Client) DialTLSContext(ctx context.Context, network, address string) (net.Conn, error) {
 	dialer := &tls.Dialer{
-		NetDialer: &net.Dialer{
-			Timeout:   30 * time.Second,
-			KeepAlive: 30 * time.Second,
-		},
-		Config: c.tlsCfg,
+		NetDialer: c.dialer,
+		Config:    c.tlsCfg,
 	}
 
 	conn, err := dialer.DialContext(ctx, network, address)
@@ -143,6 +202,49 @@ func (c *Client) DialTLSContext(ctx context.Context, network, address string) (n
 	return conn, nil
 }
 
+func initClientTLSConfig(tlsCfg *tls.Config, cfg *TLSClientCfg, logger *log.Logger) error {
+	if len(cfg.CACertificates) > 0 {
+		caCertificatePool, err := LoadCertificates(cfg.CACertificates)
+		if err != nil {
+			return err
+		}
+
+		tlsCfg.RootCAs = caCertificatePool
+	}
+
+	for _, certificateCfg := range cfg.Certificates {
+		certificate, err := tls.LoadX509KeyPair(certificateCfg.Certificate,
+			certificateCfg.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("cannot load client certificate %q: %w",
+				certificateCfg.Certificate, err)
+		}
+
+		tlsCfg.Certificates = append(tlsCfg.Certificates, certificate)
+	}
+
+	tlsCfg.ServerName = cfg.ServerName
+
+	if cfg.MinVersion != "" {
+		version, err := parseTLSVersion(cfg.MinVersion)
+		if err != nil {
+			return err
+		}
+
+		tlsCfg.MinVersion = version
+	}
+
+	if cfg.InsecureSkipVerify {
+		logger.Error("WARNING: TLS certificate verification is disabled, " +
+			"connections are vulnerable to man-in-the-middle attacks; " +
+			"this setting must never be used in production")
+
+		tlsCfg.InsecureSkipVerify = true
+	}
+
+	return nil
+}
+
 func LoadCertificates(certificates []string) (*x509.CertPool, error) {
 	pool := x509.NewCertPool()
 
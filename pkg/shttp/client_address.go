@@ -0,0 +1,224 @@
+package shttp
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// The client address of a request is the address of the connection peer
+// unless the peer is a trusted proxy, in which case we use forwarding header
+// fields, by order of preference Forwarded (RFC 7239), X-Forwarded-For and
+// X-Real-IP.
+//
+// Forwarding header fields list addresses from the original client to the
+// last proxy, and each proxy appends the address of its own peer. Only the
+// entries added by trusted proxies can be trusted, so we walk the list from
+// the right and select the first address which is not a trusted proxy.
+//
+// Connections on UNIX sockets can only be established by local processes and
+// are always considered to come from a trusted proxy.
+
+func parseTrustedProxies(ss []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, len(ss))
+
+	for i, s := range ss {
+		prefix, err := parseTrustedProxy(s)
+		if err != nil {
+			return nil, err
+		}
+
+		prefixes[i] = prefix
+	}
+
+	return prefixes, nil
+}
+
+func parseTrustedProxy(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR block %q", s)
+		}
+
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP address %q", s)
+	}
+
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func (s *Server) trustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	for _, prefix := range s.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Server) requestClientAddress(req *http.Request) string {
+	var peerAddr netip.Addr
+	var trustedPeer bool
+
+	if s.Cfg.SocketType == ServerSocketTypeUNIX {
+		trustedPeer = true
+	} else {
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			return ""
+		}
+
+		peerAddr, err = netip.ParseAddr(host)
+		if err != nil {
+			return host
+		}
+
+		peerAddr = peerAddr.Unmap()
+		trustedPeer = s.trustedProxy(peerAddr)
+	}
+
+	if !trustedPeer {
+		return peerAddr.String()
+	}
+
+	var addrs []string
+
+	if values := req.Header.Values("Forwarded"); len(values) > 0 {
+		addrs = parseForwardedHeaderAddresses(values)
+	} else if values := req.Header.Values("X-Forwarded-For"); len(values) > 0 {
+		for _, value := range values {
+			for addr := range strings.SplitSeq(value, ",") {
+				addrs = append(addrs, strings.TrimSpace(addr))
+			}
+		}
+	} else if value := req.Header.Get("X-Real-IP"); value != "" {
+		addrs = []string{strings.TrimSpace(value)}
+	}
+
+	clientAddr := peerAddr
+
+	for i := len(addrs) - 1; i >= 0; i-- {
+		addr, err := parseForwardedAddress(addrs[i])
+		if err != nil {
+			// Unknown or obfuscated identifiers (RFC 7239 6.2 and 6.3), or
+			// invalid addresses: we cannot go further, the closest address we
+			// know is the one of the last trusted proxy.
+			break
+		}
+
+		clientAddr = addr
+		if !s.trustedProxy(addr) {
+			break
+		}
+	}
+
+	if !clientAddr.IsValid() {
+		return ""
+	}
+
+	return clientAddr.String()
+}
+
+func parseForwardedAddress(s string) (netip.Addr, error) {
+	// Addresses can contain a port number, and IPv6 addresses can be enclosed
+	// in square brackets.
+
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return addr.Unmap(), nil
+	}
+
+	if addrPort, err := netip.ParseAddrPort(s); err == nil {
+		return addrPort.Addr().Unmap(), nil
+	}
+
+	if len(s) > 2 && s[0] == '[' && s[len(s)-1] == ']' {
+		if addr, err := netip.ParseAddr(s[1 : len(s)-1]); err == nil {
+			return addr.Unmap(), nil
+		}
+	}
+
+	return netip.Addr{}, fmt.Errorf("invalid address %q", s)
+}
+
+// parseForwardedHeaderAddresses returns the values of the "for" parameter of
+// each element of a Forwarded header field (RFC 7239 4). Elements without a
+// "for" parameter yield an empty string so that they are treated as unknown.
+func parseForwardedHeaderAddresses(values []string) []string {
+	var addrs []string
+
+	for _, value := range values {
+		for element := range splitQuoted(value, ',') {
+			var addr string
+
+			for pair := range splitQuoted(element, ';') {
+				name, value, found := strings.Cut(pair, "=")
+				if !found {
+					continue
+				}
+
+				name = strings.TrimSpace(name)
+				if strings.EqualFold(name, "for") {
+					addr = unquoteForwardedValue(strings.TrimSpace(value))
+				}
+			}
+
+			addrs = append(addrs, addr)
+		}
+	}
+
+	return addrs
+}
+
+// splitQuoted splits a string on a separator character, ignoring separators
+// in quoted strings.
+func splitQuoted(s string, sep byte) func(func(string) bool) {
+	return func(yield func(string) bool) {
+		start := 0
+		quoted := false
+
+		for i := 0; i < len(s); i++ {
+			switch c := s[i]; {
+			case c == '\\' && quoted:
+				i++
+			case c == '"':
+				quoted = !quoted
+			case c == sep && !quoted:
+				if !yield(s[start:i]) {
+					return
+				}
+				start = i + 1
+			}
+		}
+
+		yield(s[start:])
+	}
+}
+
+func unquoteForwardedValue(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+
+	s = s[1 : len(s)-1]
+
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+
+		buf.WriteByte(s[i])
+	}
+
+	return buf.String()
+}
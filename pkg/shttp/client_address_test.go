@@ -0,0 +1,99 @@
+package shttp
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestClientAddress(t *testing.T) {
+	s := newTestServer(t, ServerCfg{
+		TrustedProxies: []string{"10.0.0.0/8", "2001:db8::1"},
+	})
+
+	tests := []struct {
+		remoteAddr string
+		header     map[string]string
+		address    string
+	}{
+		{"192.0.2.1:1234", nil, "192.0.2.1"},
+		{"[2001:db8::2]:1234", nil, "2001:db8::2"},
+
+		// Untrusted peers
+		{"192.0.2.1:1234",
+			map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			"192.0.2.1"},
+		{"192.0.2.1:1234",
+			map[string]string{"X-Real-IP": "198.51.100.1"},
+			"192.0.2.1"},
+		{"192.0.2.1:1234",
+			map[string]string{"Forwarded": "for=198.51.100.1"},
+			"192.0.2.1"},
+
+		// X-Forwarded-For
+		{"10.0.0.1:1234",
+			map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			"198.51.100.1"},
+		{"10.0.0.1:1234",
+			map[string]string{"X-Forwarded-For": "203.0.113.1, 198.51.100.1"},
+			"198.51.100.1"},
+		{"10.0.0.1:1234",
+			map[string]string{"X-Forwarded-For": "203.0.113.1, 198.51.100.1, 10.0.0.2"},
+			"198.51.100.1"},
+		{"[2001:db8::1]:1234",
+			map[string]string{"X-Forwarded-For": "10.1.0.1, 10.0.0.2"},
+			"10.1.0.1"},
+		{"10.0.0.1:1234",
+			map[string]string{"X-Forwarded-For": "198.51.100.1, foo"},
+			"10.0.0.1"},
+
+		// X-Real-IP
+		{"10.0.0.1:1234",
+			map[string]string{"X-Real-IP": "198.51.100.1"},
+			"198.51.100.1"},
+
+		// Forwarded
+		{"10.0.0.1:1234",
+			map[string]string{"Forwarded": "for=198.51.100.1;proto=https"},
+			"198.51.100.1"},
+		{"10.0.0.1:1234",
+			map[string]string{"Forwarded": `for=203.0.113.1, for="[2001:db8::2]:4711"`},
+			"2001:db8::2"},
+		{"10.0.0.1:1234",
+			map[string]string{"Forwarded": `For="198.51.100.1:80", for=10.0.0.2;by=10.0.0.1`},
+			"198.51.100.1"},
+		{"10.0.0.1:1234",
+			map[string]string{"Forwarded": "for=unknown, for=10.0.0.2"},
+			"10.0.0.2"},
+		{"10.0.0.1:1234",
+			map[string]string{
+				"Forwarded":       "for=198.51.100.1",
+				"X-Forwarded-For": "203.0.113.1",
+			},
+			"198.51.100.1"},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = test.remoteAddr
+		for name, value := range test.header {
+			req.Header.Set(name, value)
+		}
+
+		assert.Equal(t, test.address, s.requestClientAddress(req),
+			"remote address %q, header %v", test.remoteAddr, test.header)
+	}
+}
+
+func TestRequestClientAddressUNIX(t *testing.T) {
+	s := newTestServer(t, ServerCfg{
+		SocketType: ServerSocketTypeUNIX,
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "@"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+
+	assert.Equal(t, "198.51.100.1", s.requestClientAddress(req))
+}
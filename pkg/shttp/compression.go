@@ -0,0 +1,316 @@
+package shttp
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.n16f.net/ejson"
+)
+
+const (
+	DefaultCompressionMinSize = 1024
+)
+
+var DefaultCompressionContentTypes = []string{
+	"text/*",
+	"application/javascript",
+	"application/json",
+	"application/problem+json",
+	"application/wasm",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// Content codings by order of preference when the client accepts several
+// ones with the same weight.
+var DefaultCompressionEncodings = []string{"zstd", "br", "gzip"}
+
+// Only gzip is supported out of the box. Other encoders, for example zstd or
+// brotli, can be provided in the compression configuration.
+type CompressionEncoder func(io.Writer) (CompressionWriter, error)
+
+type CompressionWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+type CompressionCfg struct {
+	Encoders map[string]CompressionEncoder `json:"-"`
+
+	// The list of content codings used, by order of preference. Only
+	// content codings for which an encoder is available are used.
+	Encodings []string `json:"encodings"`
+
+	// Responses smaller than the minimum size are not compressed.
+	MinSize int `json:"min_size"`
+
+	// Content types are either full media types or media ranges such as
+	// "text/*".
+	ContentTypes []string `json:"content_types"`
+}
+
+func (cfg *CompressionCfg) ValidateJSON(v *ejson.Validator) {
+	v.WithChild("encodings", func() {
+		for i, encoding := range cfg.Encodings {
+			v.CheckStringNotEmpty(i, encoding)
+		}
+	})
+
+	v.CheckIntMin("min_size", cfg.MinSize, 0)
+
+	v.WithChild("content_types", func() {
+		for i, contentType := range cfg.ContentTypes {
+			v.CheckStringNotEmpty(i, contentType)
+		}
+	})
+}
+
+func NewGzipCompressionWriter(w io.Writer) (CompressionWriter, error) {
+	return gzip.NewWriter(w), nil
+}
+
+type compressionPolicy struct {
+	cfg *CompressionCfg
+
+	encodings    []string
+	contentTypes []*MediaRange
+}
+
+func newCompressionPolicy(cfg *CompressionCfg) (*compressionPolicy, error) {
+	encoders := map[string]CompressionEncoder{
+		"gzip": NewGzipCompressionWriter,
+	}
+
+	for name, encoder := range cfg.Encoders {
+		encoders[strings.ToLower(name)] = encoder
+	}
+	cfg.Encoders = encoders
+
+	if cfg.Encodings == nil {
+		cfg.Encodings = DefaultCompressionEncodings
+	}
+
+	var encodings []string
+	for _, encoding := range cfg.Encodings {
+		encoding = strings.ToLower(encoding)
+		if _, found := encoders[encoding]; found {
+			encodings = append(encodings, encoding)
+		}
+	}
+
+	if cfg.MinSize == 0 {
+		cfg.MinSize = DefaultCompressionMinSize
+	}
+
+	if cfg.ContentTypes == nil {
+		cfg.ContentTypes = DefaultCompressionContentTypes
+	}
+
+	contentTypes := make([]*MediaRange, len(cfg.ContentTypes))
+	for i, contentType := range cfg.ContentTypes {
+		var mr MediaRange
+		if err := mr.Parse(contentType); err != nil {
+			return nil, fmt.Errorf("invalid content type %q: %w",
+				contentType, err)
+		}
+
+		contentTypes[i] = &mr
+	}
+
+	p := compressionPolicy{
+		cfg: cfg,
+
+		encodings:    encodings,
+		contentTypes: contentTypes,
+	}
+
+	return &p, nil
+}
+
+func (p *compressionPolicy) compressibleContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, mr := range p.contentTypes {
+		if mr.MatchesMediaType(mediaType) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// responseCompression handles the compression of a response. Once we know
+// the response can be compressed, the response header is held back and data
+// are buffered until we reach the minimum size, so that we can still send
+// small responses uncompressed. Flushing the response writer forces
+// compression to start so that streaming responses are not delayed.
+type responseCompression struct {
+	policy *compressionPolicy
+	w      http.ResponseWriter
+
+	// The selected content coding, empty if the client does not accept any
+	// content coding we support.
+	encoding string
+
+	status  int
+	pending bool
+	buf     []byte
+
+	encoder CompressionWriter
+}
+
+func newResponseCompression(policy *compressionPolicy, w http.ResponseWriter, req *http.Request) *responseCompression {
+	var encoding string
+
+	if value := req.Header.Get("Accept-Encoding"); value != "" {
+		var ccs ContentCodings
+		ccs.Parse(value)
+
+		encoding = ccs.SelectContentCoding(policy.encodings...)
+	}
+
+	c := responseCompression{
+		policy: policy,
+		w:      w,
+
+		encoding: encoding,
+	}
+
+	return &c
+}
+
+// writeHeader returns true if the response is compressible, in which case
+// sending the header is delayed until we know if we compress it or not.
+func (c *responseCompression) writeHeader(status int) bool {
+	header := c.w.Header()
+
+	// Partial content responses contain ranges of the uncompressed
+	// representation and cannot be compressed.
+	switch {
+	case status < 200, status == 204, status == 206, status == 304:
+		return false
+	case header.Get("Content-Encoding") != "":
+		return false
+	case header.Get("Content-Range") != "":
+		return false
+	case !c.policy.compressibleContentType(header.Get("Content-Type")):
+		return false
+	}
+
+	// The response depends on the Accept-Encoding header field even if we
+	// do not compress it.
+	if !slices.Contains(header.Values("Vary"), "Accept-Encoding") {
+		header.Add("Vary", "Accept-Encoding")
+	}
+
+	if c.encoding == "" {
+		return false
+	}
+
+	if value := header.Get("Content-Length"); value != "" {
+		length, err := strconv.Atoi(value)
+		if err == nil && length < c.policy.cfg.MinSize {
+			return false
+		}
+	}
+
+	c.status = status
+	c.pending = true
+
+	return true
+}
+
+func (c *responseCompression) write(data []byte) (int, error) {
+	if c.pending {
+		c.buf = append(c.buf, data...)
+
+		if len(c.buf) >= c.policy.cfg.MinSize {
+			if err := c.start(); err != nil {
+				return 0, err
+			}
+		}
+
+		return len(data), nil
+	}
+
+	return c.encoder.Write(data)
+}
+
+func (c *responseCompression) start() error {
+	c.pending = false
+
+	header := c.w.Header()
+
+	buf := c.buf
+	c.buf = nil
+
+	encoder, err := c.policy.cfg.Encoders[c.encoding](c.w)
+	if err != nil {
+		// Fall back to an uncompressed response
+		c.encoder = identityCompressionWriter{c.w}
+		c.w.WriteHeader(c.status)
+		c.w.Write(buf)
+
+		return fmt.Errorf("cannot create %s encoder: %w", c.encoding, err)
+	}
+
+	c.encoder = encoder
+
+	header.Set("Content-Encoding", c.encoding)
+	header.Del("Content-Length")
+
+	// The compressed representation is not byte-for-byte identical to the
+	// uncompressed one, so a strong entity tag cannot be used for both.
+	if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		header.Set("ETag", "W/"+etag)
+	}
+
+	c.w.WriteHeader(c.status)
+
+	_, err = c.encoder.Write(buf)
+	return err
+}
+
+func (c *responseCompression) flush() error {
+	if c.pending {
+		if err := c.start(); err != nil {
+			return err
+		}
+	}
+
+	return c.encoder.Flush()
+}
+
+func (c *responseCompression) close() error {
+	if c.pending {
+		// We never reached the minimum size
+		c.pending = false
+		c.w.WriteHeader(c.status)
+
+		_, err := c.w.Write(c.buf)
+		return err
+	}
+
+	return c.encoder.Close()
+}
+
+type identityCompressionWriter struct {
+	io.Writer
+}
+
+func (w identityCompressionWriter) Close() error {
+	return nil
+}
+
+func (w identityCompressionWriter) Flush() error {
+	return nil
+}
@@ -0,0 +1,183 @@
+package shttp
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeGzipBody(t *testing.T, r io.Reader) string {
+	t.Helper()
+
+	gr, err := gzip.NewReader(r)
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(gr)
+	require.NoError(t, err)
+
+	return string(data)
+}
+
+func TestCompression(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newTestServer(t, ServerCfg{
+		Compression: &CompressionCfg{
+			MinSize: 100,
+		},
+	})
+
+	largeText := strings.Repeat("Hello world!\n", 100)
+
+	s.Route("/large", "GET", func(h *Handler) {
+		h.ReplyText(200, largeText)
+	})
+
+	s.Route("/small", "GET", func(h *Handler) {
+		h.ReplyText(200, "Hello world!\n")
+	})
+
+	s.Route("/binary", "GET", func(h *Handler) {
+		h.ResponseWriter.Header().Set("Content-Type", "image/png")
+		h.Reply(200, strings.NewReader(largeText))
+	})
+
+	sendRequest := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w
+	}
+
+	// Compressed response
+	w := sendRequest("/large", "gzip, deflate")
+	assert.Equal(200, w.Code)
+	assert.Equal("gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal("Accept-Encoding", w.Header().Get("Vary"))
+	assert.Equal(largeText, decodeGzipBody(t, w.Body))
+
+	// Client not accepting compression
+	w = sendRequest("/large", "")
+	assert.Equal("", w.Header().Get("Content-Encoding"))
+	assert.Equal("Accept-Encoding", w.Header().Get("Vary"))
+	assert.Equal(largeText, w.Body.String())
+
+	w = sendRequest("/large", "br, gzip;q=0")
+	assert.Equal("", w.Header().Get("Content-Encoding"))
+	assert.Equal(largeText, w.Body.String())
+
+	// Response smaller than the minimum size
+	w = sendRequest("/small", "gzip")
+	assert.Equal("", w.Header().Get("Content-Encoding"))
+	assert.Equal("Accept-Encoding", w.Header().Get("Vary"))
+	assert.Equal("Hello world!\n", w.Body.String())
+
+	// Content type not compressible
+	w = sendRequest("/binary", "gzip")
+	assert.Equal("", w.Header().Get("Content-Encoding"))
+	assert.Equal("", w.Header().Get("Vary"))
+	assert.Equal(largeText, w.Body.String())
+}
+
+func TestCompressionEncoders(t *testing.T) {
+	assert := assert.New(t)
+
+	identityEncoder := func(w io.Writer) (CompressionWriter, error) {
+		return identityCompressionWriter{w}, nil
+	}
+
+	s := newTestServer(t, ServerCfg{
+		Compression: &CompressionCfg{
+			Encoders: map[string]CompressionEncoder{
+				"zstd": identityEncoder,
+			},
+			MinSize: 10,
+		},
+	})
+
+	s.Route("/", "GET", func(h *Handler) {
+		h.ReplyText(200, "Hello world!\n")
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip, zstd")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+
+	assert.Equal("zstd", w.Header().Get("Content-Encoding"))
+	assert.Equal("Hello world!\n", w.Body.String())
+}
+
+func TestCompressionStreaming(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newTestServer(t, ServerCfg{
+		Compression: &CompressionCfg{},
+	})
+
+	s.Route("/", "GET", func(h *Handler) {
+		h.ResponseWriter.Header().Set("Content-Type", "application/json")
+		h.ReplyJSONChunk(map[string]int{"a": 1})
+		h.ReplyJSONChunk(map[string]int{"b": 2})
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+
+	// Data are flushed even if they are smaller than the minimum size
+	assert.True(w.Flushed)
+	assert.Equal("gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal("{\"a\":1}\n{\"b\":2}\n", decodeGzipBody(t, w.Body))
+}
+
+func TestCompressionRanges(t *testing.T) {
+	assert := assert.New(t)
+
+	content := strings.Repeat("0123456789", 1000)
+
+	filePath := path.Join(t.TempDir(), "test.txt")
+	require.NoError(t, os.WriteFile(filePath, []byte(content), 0600))
+
+	s := newTestServer(t, ServerCfg{
+		Compression: &CompressionCfg{},
+	})
+
+	s.Route("/file", "GET", func(h *Handler) {
+		h.ReplyFile(filePath)
+	})
+
+	// Partial content is never compressed
+	req := httptest.NewRequest("GET", "/file", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Range", "bytes=10-19")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+
+	assert.Equal(206, w.Code)
+	assert.Equal("", w.Header().Get("Content-Encoding"))
+	assert.Equal("0123456789", w.Body.String())
+
+	// Full content is
+	req = httptest.NewRequest("GET", "/file", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+
+	assert.Equal(200, w.Code)
+	assert.Equal("gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal("", w.Header().Get("Content-Length"))
+	assert.Equal(content, decodeGzipBody(t, w.Body))
+}
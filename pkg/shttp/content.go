@@ -0,0 +1,339 @@
+package shttp
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The maximum number of ranges we accept to send in a multipart response.
+// Requests with more ranges are served with the full content.
+const maxContentRanges = 32
+
+// ReplyContent sends content with support for conditional requests (RFC 9110
+// 13) and range requests (RFC 9110 14). It is the equivalent of ReplyFile for
+// content which is not stored in a file, e.g. data stored in a database or
+// generated content.
+//
+// The modification time is optional. If the entity tag is empty, a strong
+// entity tag is generated by hashing the content. Conditional and range
+// requests are only handled for responses with status 200.
+func (h *Handler) ReplyContent(status int, contentType string, content io.ReadSeeker, modTime time.Time, etag string) {
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		h.ReplyInternalError(500, "cannot obtain content size: %v", err)
+		return
+	}
+
+	if etag == "" {
+		etag, err = contentETag(content)
+		if err != nil {
+			h.ReplyInternalError(500, "cannot compute entity tag: %v", err)
+			return
+		}
+	} else if !strings.HasSuffix(etag, `"`) {
+		etag = `"` + etag + `"`
+	}
+
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		h.ReplyInternalError(500, "cannot seek content: %v", err)
+		return
+	}
+
+	header := h.ResponseWriter.Header()
+
+	header.Set("ETag", etag)
+	if !modTime.IsZero() {
+		header.Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+
+	if status != 200 {
+		h.replyContentRange(status, contentType, content, 0, size, size)
+		return
+	}
+
+	header.Set("Accept-Ranges", "bytes")
+
+	if status := h.checkContentPreconditions(modTime, etag); status != 0 {
+		if status == 412 {
+			h.ReplyError(412, "precondition_failed", "precondition failed")
+		} else {
+			h.ReplyEmpty(status)
+		}
+
+		return
+	}
+
+	ranges, ok := h.contentRanges(modTime, etag, size)
+	if !ok {
+		header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		h.ReplyError(416, "range_not_satisfiable",
+			"none of the requested ranges can be satisfied")
+		return
+	}
+
+	switch len(ranges) {
+	case 0:
+		h.replyContentRange(200, contentType, content, 0, size, size)
+
+	case 1:
+		r := ranges[0]
+		header.Set("Content-Range", r.contentRange(size))
+		h.replyContentRange(206, contentType, content, r.start, r.length,
+			size)
+
+	default:
+		h.replyContentRanges(contentType, content, ranges, size)
+	}
+}
+
+// checkContentPreconditions evaluates precondition header fields in the
+// order defined in RFC 9110 13.2.2. It returns the status of the response to
+// send if a precondition is not met, or 0.
+func (h *Handler) checkContentPreconditions(modTime time.Time, etag string) int {
+	req := h.Request
+	header := req.Header
+
+	if value := header.Get("If-Match"); value != "" {
+		if !etagListMatches(value, etag, false) {
+			return 412
+		}
+	} else if value := header.Get("If-Unmodified-Since"); value != "" {
+		date, err := http.ParseTime(value)
+		if err == nil && !modTime.IsZero() &&
+			modTime.Truncate(time.Second).After(date) {
+			return 412
+		}
+	}
+
+	isGet := req.Method == "GET" || req.Method == "HEAD"
+
+	if value := header.Get("If-None-Match"); value != "" {
+		if etagListMatches(value, etag, true) {
+			if isGet {
+				return 304
+			}
+
+			return 412
+		}
+	} else if value := header.Get("If-Modified-Since"); value != "" && isGet {
+		date, err := http.ParseTime(value)
+		if err == nil && !modTime.IsZero() &&
+			!modTime.Truncate(time.Second).After(date) {
+			return 304
+		}
+	}
+
+	return 0
+}
+
+type contentRange struct {
+	start  int64
+	length int64
+}
+
+func (r contentRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// contentRanges returns the ranges to send, or no range at all if the full
+// content must be sent. It returns false if none of the ranges requested can
+// be satisfied.
+func (h *Handler) contentRanges(modTime time.Time, etag string, size int64) ([]contentRange, bool) {
+	req := h.Request
+
+	if req.Method != "GET" && req.Method != "HEAD" {
+		return nil, true
+	}
+
+	value := req.Header.Get("Range")
+	if value == "" {
+		return nil, true
+	}
+
+	// If-Range (RFC 9110 13.1.5) requires a strong comparison
+	if ifRange := req.Header.Get("If-Range"); ifRange != "" {
+		if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+			if !etagMatches(ifRange, etag, false) {
+				return nil, true
+			}
+		} else {
+			date, err := http.ParseTime(ifRange)
+			if err != nil || modTime.IsZero() ||
+				!modTime.Truncate(time.Second).Equal(date) {
+				return nil, true
+			}
+		}
+	}
+
+	// Invalid range header fields can be ignored (RFC 9110 14.2)
+	var ranges Ranges
+	if err := ranges.Parse(value); err != nil || len(ranges) == 0 {
+		return nil, true
+	}
+
+	if len(ranges) > maxContentRanges {
+		return nil, true
+	}
+
+	var contentRanges []contentRange
+	var totalLength int64
+
+	for _, r := range ranges {
+		var cr contentRange
+
+		switch {
+		case r.Start == nil && r.End == nil:
+			continue
+
+		case r.Start != nil:
+			if *r.Start >= size {
+				continue
+			}
+
+			end := size - 1
+			if r.End != nil && *r.End < end {
+				end = *r.End
+			}
+
+			cr = contentRange{start: *r.Start, length: end - *r.Start + 1}
+
+		default:
+			suffixLength := *r.End
+			if suffixLength == 0 {
+				continue
+			}
+
+			suffixLength = min(suffixLength, size)
+			cr = contentRange{start: size - suffixLength, length: suffixLength}
+		}
+
+		contentRanges = append(contentRanges, cr)
+		totalLength += cr.length
+	}
+
+	if len(contentRanges) == 0 {
+		return nil, false
+	}
+
+	// There is no point in sending overlapping ranges whose total length is
+	// larger than the content itself.
+	if totalLength > size {
+		return nil, true
+	}
+
+	return contentRanges, true
+}
+
+func (h *Handler) replyContentRange(status int, contentType string, content io.ReadSeeker, start, length, size int64) {
+	header := h.ResponseWriter.Header()
+
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	header.Set("Content-Length", strconv.FormatInt(length, 10))
+
+	if h.Request.Method == "HEAD" {
+		h.ReplyEmpty(status)
+		return
+	}
+
+	if _, err := content.Seek(start, io.SeekStart); err != nil {
+		h.ReplyInternalError(500, "cannot seek content: %v", err)
+		return
+	}
+
+	h.Reply(status, io.LimitReader(content, length))
+}
+
+func (h *Handler) replyContentRanges(contentType string, content io.ReadSeeker, ranges []contentRange, size int64) {
+	header := h.ResponseWriter.Header()
+
+	w := multipart.NewWriter(h.ResponseWriter)
+
+	header.Set("Content-Type", "multipart/byteranges; boundary="+w.Boundary())
+
+	if h.Request.Method == "HEAD" {
+		h.ReplyEmpty(206)
+		return
+	}
+
+	h.ResponseWriter.WriteHeader(206)
+
+	for _, r := range ranges {
+		partHeader := make(textproto.MIMEHeader)
+		if contentType != "" {
+			partHeader.Set("Content-Type", contentType)
+		}
+		partHeader.Set("Content-Range", r.contentRange(size))
+
+		pw, err := w.CreatePart(partHeader)
+		if err != nil {
+			h.Log.Error("cannot write response: %v", err)
+			return
+		}
+
+		if _, err := content.Seek(r.start, io.SeekStart); err != nil {
+			h.Log.Error("cannot seek content: %v", err)
+			return
+		}
+
+		if _, err := io.CopyN(pw, content, r.length); err != nil {
+			h.Log.Error("cannot write response: %v", err)
+			return
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		h.Log.Error("cannot write response: %v", err)
+	}
+}
+
+func contentETag(content io.ReadSeeker) (string, error) {
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return "", err
+	}
+
+	sum := hash.Sum(nil)
+	return `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`, nil
+}
+
+// etagListMatches checks if an entity tag matches a list of entity tags as
+// found in If-Match and If-None-Match header fields.
+func etagListMatches(list, etag string, weak bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+
+	for value := range strings.SplitSeq(list, ",") {
+		if etagMatches(strings.TrimSpace(value), etag, weak) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// etagMatches compares entity tags as described in RFC 9110 8.8.3.2.
+func etagMatches(a, b string, weak bool) bool {
+	aWeak := strings.HasPrefix(a, "W/")
+	bWeak := strings.HasPrefix(b, "W/")
+
+	if !weak && (aWeak || bWeak) {
+		return false
+	}
+
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
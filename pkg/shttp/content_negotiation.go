@@ -35,9 +35,9 @@ func (mr *MediaRange) Parse(s string) error {
 	}
 
 	if q, found := parameters["q"]; found {
-		mr.Weight, err = strconv.ParseFloat(q, 64)
+		mr.Weight, err = parseQValue(q)
 		if err != nil {
-			return fmt.Errorf("invalid \"q\" parameter: %w", err)
+			return err
 		}
 	} else {
 		mr.Weight = 1.0
@@ -46,6 +46,15 @@ func (mr *MediaRange) Parse(s string) error {
 	return nil
 }
 
+func parseQValue(s string) (float64, error) {
+	q, err := strconv.ParseFloat(s, 64)
+	if err != nil || q < 0.0 || q > 1.0 {
+		return 0.0, fmt.Errorf("invalid \"q\" parameter %q", s)
+	}
+
+	return q, nil
+}
+
 func (mr *MediaRange) Parameter(name string) string {
 	return mr.Parameters[strings.ToLower(name)]
 }
@@ -85,22 +94,179 @@ func (mrs *MediaRanges) Parse(s string) {
 	*mrs = mediaRanges
 }
 
-func (mrs MediaRanges) SelectMediaType(mediaTypes ...string) string {
-	// We ignore the idea of types being more or less specific than others on
-	// purpose, rules are complicated and we do not really care about them for
-	// the time being.
+// Specificity returns the precedence of a media range when several ones match
+// a media type (RFC 9110 12.5.1): ranges with more parameters are more
+// specific, and all ranges with a subtype are more specific than wildcards.
+func (mr *MediaRange) Specificity() int {
+	switch {
+	case mr.Type == "*":
+		return 0
+	case mr.Subtype == "*":
+		return 1
+	}
+
+	n := len(mr.Parameters)
+	if _, found := mr.Parameters["q"]; found {
+		n--
+	}
+
+	return 2 + n
+}
+
+// matchesMediaTypeParameters checks that a media type has all the
+// parameters of the media range, as required for a range with parameters to
+// match it.
+func (mr *MediaRange) matchesMediaTypeParameters(parameters map[string]string) bool {
+	for name, value := range mr.Parameters {
+		if name == "q" {
+			continue
+		}
+
+		if !strings.EqualFold(parameters[name], value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Weight returns the weight of the most specific media range matching a
+// media type, or 0 if there is none.
+func (mrs MediaRanges) Weight(mediaType string) float64 {
+	fullType, parameters, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		return 0.0
+	}
 
-	matchingMediaType := ""
 	weight := 0.0
+	specificity := -1
 
 	for _, mr := range mrs {
-		for _, mt := range mediaTypes {
-			if mr.MatchesMediaType(mt) && mr.Weight > weight {
-				matchingMediaType = mt
-				weight = mr.Weight
-			}
+		if !mr.MatchesMediaType(fullType) ||
+			!mr.matchesMediaTypeParameters(parameters) {
+			continue
+		}
+
+		if s := mr.Specificity(); s > specificity {
+			weight = mr.Weight
+			specificity = s
+		}
+	}
+
+	return weight
+}
+
+// SelectMediaType returns the media type with the highest weight, or an empty
+// string if none is acceptable. The weight of each media type is the one of
+// the most specific media range matching it. Media types are passed by order
+// of preference, which is used to select a media type when several ones have
+// the same weight.
+func (mrs MediaRanges) SelectMediaType(mediaTypes ...string) string {
+	matchingMediaType := ""
+	weight := 0.0
+
+	for _, mt := range mediaTypes {
+		if w := mrs.Weight(mt); w > weight {
+			matchingMediaType = mt
+			weight = w
 		}
 	}
 
 	return matchingMediaType
 }
+
+// Content codings are used in the Accept-Encoding header field (RFC 9110
+// 12.5.3).
+type ContentCoding struct {
+	Name   string // lower case
+	Weight float64
+}
+
+type ContentCodings []*ContentCoding
+
+func (cc *ContentCoding) Parse(s string) error {
+	name, parameters, _ := strings.Cut(s, ";")
+
+	cc.Name = strings.ToLower(strings.TrimSpace(name))
+	if cc.Name == "" {
+		return fmt.Errorf("empty content coding")
+	}
+
+	cc.Weight = 1.0
+
+	for parameter := range strings.SplitSeq(parameters, ";") {
+		name, value, _ := strings.Cut(parameter, "=")
+
+		name = strings.TrimSpace(name)
+		if !strings.EqualFold(name, "q") {
+			continue
+		}
+
+		weight, err := parseQValue(strings.TrimSpace(value))
+		if err != nil {
+			return err
+		}
+
+		cc.Weight = weight
+	}
+
+	return nil
+}
+
+func (ccs *ContentCodings) Parse(s string) {
+	var codings ContentCodings
+
+	for s := range strings.SplitSeq(s, ",") {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+
+		var cc ContentCoding
+		if err := cc.Parse(s); err != nil {
+			continue
+		}
+
+		codings = append(codings, &cc)
+	}
+
+	sort.SliceStable(codings, func(i, j int) bool {
+		return codings[i].Weight > codings[j].Weight
+	})
+
+	*ccs = codings
+}
+
+// Weight returns the weight associated with a content coding, either
+// explicitly or through the "*" wildcard. Content codings which are not
+// accepted have a null weight.
+func (ccs ContentCodings) Weight(name string) float64 {
+	wildcardWeight := 0.0
+
+	for _, cc := range ccs {
+		if cc.Name == name {
+			return cc.Weight
+		} else if cc.Name == "*" {
+			wildcardWeight = cc.Weight
+		}
+	}
+
+	return wildcardWeight
+}
+
+// SelectContentCoding returns the accepted content coding with the highest
+// weight, or an empty string if none is accepted. Content codings are
+// passed by order of preference, which is used to select a content coding
+// when several ones have the same weight.
+func (ccs ContentCodings) SelectContentCoding(names ...string) string {
+	selectedName := ""
+	weight := 0.0
+
+	for _, name := range names {
+		if w := ccs.Weight(name); w > weight {
+			selectedName = name
+			weight = w
+		}
+	}
+
+	return selectedName
+}
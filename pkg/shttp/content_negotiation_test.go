@@ -117,6 +117,27 @@ func TestMediaRangesSelectMediaType(t *testing.T) {
 			assert.Equal("text/xml",
 				mrs.SelectMediaType("application/json", "text/xml"))
 		})
+
+	// The most specific media range defines the weight of a media type
+	withTestMediaRanges(t, "text/*;q=0.3, text/plain;q=0.7, "+
+		"text/plain;format=flowed, */*;q=0.5",
+		func(mrs MediaRanges) {
+			assert.Equal(1.0, mrs.Weight("text/plain; format=flowed"))
+			assert.Equal(0.7, mrs.Weight("text/plain"))
+			assert.Equal(0.3, mrs.Weight("text/html"))
+			assert.Equal(0.5, mrs.Weight("image/jpeg"))
+
+			assert.Equal("image/jpeg",
+				mrs.SelectMediaType("text/html", "image/jpeg"))
+			assert.Equal("text/plain",
+				mrs.SelectMediaType("text/html", "text/plain"))
+		})
+
+	withTestMediaRanges(t, "*/*, application/json;q=0",
+		func(mrs MediaRanges) {
+			assert.Equal("application/yaml",
+				mrs.SelectMediaType("application/json", "application/yaml"))
+		})
 }
 
 func withTestMediaRange(t *testing.T, s string, fn func(mr *MediaRange)) {
@@ -138,3 +159,40 @@ func withTestMediaRanges(t *testing.T, s string, fn func(mr MediaRanges)) {
 	mrs.Parse(s)
 	fn(mrs)
 }
+
+func TestContentCodingsParse(t *testing.T) {
+	assert := assert.New(t)
+
+	var ccs ContentCodings
+	ccs.Parse("gzip;q=0.5, BR, *;q=0.1, identity; q=0, zstd;q=2")
+
+	if assert.Len(ccs, 4) {
+		assert.Equal("br", ccs[0].Name)
+		assert.Equal(1.0, ccs[0].Weight)
+		assert.Equal("gzip", ccs[1].Name)
+		assert.Equal(0.5, ccs[1].Weight)
+		assert.Equal("*", ccs[2].Name)
+		assert.Equal(0.1, ccs[2].Weight)
+		assert.Equal("identity", ccs[3].Name)
+		assert.Equal(0.0, ccs[3].Weight)
+	}
+}
+
+func TestContentCodingsSelectContentCoding(t *testing.T) {
+	assert := assert.New(t)
+
+	selectCoding := func(s string, names ...string) string {
+		var ccs ContentCodings
+		ccs.Parse(s)
+		return ccs.SelectContentCoding(names...)
+	}
+
+	assert.Equal("gzip", selectCoding("gzip", "zstd", "gzip"))
+	assert.Equal("zstd", selectCoding("gzip, zstd", "zstd", "gzip"))
+	assert.Equal("gzip", selectCoding("gzip, zstd;q=0.5", "zstd", "gzip"))
+	assert.Equal("zstd", selectCoding("*", "zstd", "gzip"))
+	assert.Equal("gzip", selectCoding("*, zstd;q=0", "zstd", "gzip"))
+	assert.Equal("", selectCoding("gzip;q=0", "gzip"))
+	assert.Equal("", selectCoding("br", "zstd", "gzip"))
+	assert.Equal("", selectCoding("", "gzip"))
+}
@@ -0,0 +1,180 @@
+package shttp
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplyContent(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	s := newTestServer(t, ServerCfg{})
+
+	const content = "0123456789abcdefghij"
+	modTime := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	s.Route("/content", "GET", func(h *Handler) {
+		h.ReplyContent(200, "text/plain", strings.NewReader(content),
+			modTime, "")
+	})
+
+	s.Route("/tagged", "GET", func(h *Handler) {
+		h.ReplyContent(200, "text/plain", strings.NewReader(content),
+			time.Time{}, "v1")
+	})
+
+	sendRequest := func(path string, header map[string]string) (*http.Response, string) {
+		req := httptest.NewRequest("GET", path, nil)
+		for name, value := range header {
+			req.Header.Set(name, value)
+		}
+
+		res := sendTestRequest(s, req)
+
+		body, err := io.ReadAll(res.Body)
+		require.NoError(err)
+
+		return res, string(body)
+	}
+
+	// Full content
+	res, body := sendRequest("/content", nil)
+	require.Equal(200, res.StatusCode)
+	assert.Equal(content, body)
+	assert.Equal("bytes", res.Header.Get("Accept-Ranges"))
+	assert.Equal("Wed, 04 Mar 2026 05:06:07 GMT",
+		res.Header.Get("Last-Modified"))
+
+	etag := res.Header.Get("ETag")
+	assert.Regexp(`^"[A-Za-z0-9_-]+"$`, etag)
+
+	res, _ = sendRequest("/tagged", nil)
+	assert.Equal(`"v1"`, res.Header.Get("ETag"))
+
+	// Conditional requests
+	res, body = sendRequest("/content", map[string]string{
+		"If-None-Match": `"foo", ` + etag,
+	})
+	assert.Equal(304, res.StatusCode)
+	assert.Equal("", body)
+
+	res, _ = sendRequest("/content", map[string]string{
+		"If-None-Match": `"foo"`,
+	})
+	assert.Equal(200, res.StatusCode)
+
+	res, _ = sendRequest("/content", map[string]string{
+		"If-Modified-Since": "Wed, 04 Mar 2026 05:06:07 GMT",
+	})
+	assert.Equal(304, res.StatusCode)
+
+	res, _ = sendRequest("/content", map[string]string{
+		"If-Modified-Since": "Wed, 04 Mar 2026 05:06:06 GMT",
+	})
+	assert.Equal(200, res.StatusCode)
+
+	res, _ = sendRequest("/content", map[string]string{
+		"If-Match": `"foo"`,
+	})
+	assert.Equal(412, res.StatusCode)
+
+	// Single range
+	res, body = sendRequest("/content", map[string]string{
+		"Range": "bytes=2-5",
+	})
+	assert.Equal(206, res.StatusCode)
+	assert.Equal("2345", body)
+	assert.Equal("bytes 2-5/20", res.Header.Get("Content-Range"))
+	assert.Equal("4", res.Header.Get("Content-Length"))
+
+	res, body = sendRequest("/content", map[string]string{
+		"Range": "bytes=-3",
+	})
+	assert.Equal(206, res.StatusCode)
+	assert.Equal("hij", body)
+
+	res, body = sendRequest("/content", map[string]string{
+		"Range": "bytes=15-100",
+	})
+	assert.Equal(206, res.StatusCode)
+	assert.Equal("fghij", body)
+	assert.Equal("bytes 15-19/20", res.Header.Get("Content-Range"))
+
+	// Multiple ranges
+	res, body = sendRequest("/content", map[string]string{
+		"Range": "bytes=0-1, 10-11, 50-60",
+	})
+	require.Equal(206, res.StatusCode)
+
+	mediaType, params, err := mime.ParseMediaType(
+		res.Header.Get("Content-Type"))
+	require.NoError(err)
+	assert.Equal("multipart/byteranges", mediaType)
+
+	r := multipart.NewReader(strings.NewReader(body), params["boundary"])
+
+	var parts []string
+	var contentRanges []string
+
+	for {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(err)
+
+		data, err := io.ReadAll(part)
+		require.NoError(err)
+
+		parts = append(parts, string(data))
+		contentRanges = append(contentRanges, part.Header.Get("Content-Range"))
+		assert.Equal("text/plain", part.Header.Get("Content-Type"))
+	}
+
+	assert.Equal([]string{"01", "ab"}, parts)
+	assert.Equal([]string{"bytes 0-1/20", "bytes 10-11/20"}, contentRanges)
+
+	// Unsatisfiable ranges
+	res, _ = sendRequest("/content", map[string]string{
+		"Range": "bytes=20-30",
+	})
+	assert.Equal(416, res.StatusCode)
+	assert.Equal("bytes */20", res.Header.Get("Content-Range"))
+
+	// If-Range
+	res, body = sendRequest("/content", map[string]string{
+		"Range":    "bytes=0-1",
+		"If-Range": etag,
+	})
+	assert.Equal(206, res.StatusCode)
+	assert.Equal("01", body)
+
+	res, body = sendRequest("/content", map[string]string{
+		"Range":    "bytes=0-1",
+		"If-Range": `"foo"`,
+	})
+	assert.Equal(200, res.StatusCode)
+	assert.Equal(content, body)
+
+	res, _ = sendRequest("/content", map[string]string{
+		"Range":    "bytes=0-1",
+		"If-Range": "Wed, 04 Mar 2026 05:06:07 GMT",
+	})
+	assert.Equal(206, res.StatusCode)
+
+	res, _ = sendRequest("/content", map[string]string{
+		"Range":    "bytes=0-1",
+		"If-Range": "Wed, 04 Mar 2026 05:00:00 GMT",
+	})
+	assert.Equal(200, res.StatusCode)
+}
@@ -0,0 +1,329 @@
+package shttp
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.n16f.net/ejson"
+)
+
+// Reference: https://fetch.spec.whatwg.org/#http-cors-protocol
+
+type CORSCfg struct {
+	// Allowed origins are either "*", full origins (e.g.
+	// "https://example.com") or origins with a wildcard as first label of the
+	// host (e.g. "https://*.example.com").
+	AllowedOrigins []string `json:"allowed_origins"`
+
+	// If no methods are configured, preflight requests allow all methods
+	// registered for the path pattern of the route.
+	//
+	// Note that OPTIONS routes are registered automatically when CORS is
+	// enabled; routes registered with an empty method are not affected. The
+	// application can still register its own OPTIONS routes, before or after
+	// other routes; they replace automatic ones. Preflight requests are
+	// handled before calling them.
+	AllowedMethods []string `json:"allowed_methods"`
+
+	AllowedHeaders   []string `json:"allowed_headers"`
+	ExposedHeaders   []string `json:"exposed_headers"`
+	AllowCredentials bool     `json:"allow_credentials"`
+	MaxAge           int      `json:"max_age"` // seconds
+}
+
+func (cfg *CORSCfg) ValidateJSON(v *ejson.Validator) {
+	v.WithChild("allowed_origins", func() {
+		for i, origin := range cfg.AllowedOrigins {
+			if origin == "*" {
+				continue
+			}
+
+			_, err := url.Parse(strings.Replace(origin, "*.", "", 1))
+			v.Check(i, err == nil, "invalid_origin", "invalid origin")
+		}
+	})
+
+	v.WithChild("allowed_methods", func() {
+		for i, method := range cfg.AllowedMethods {
+			v.CheckStringNotEmpty(i, method)
+		}
+	})
+
+	// Reflecting any origin with credentials would let any website perform
+	// authenticated requests and read the responses.
+	if cfg.AllowCredentials {
+		v.Check("allow_credentials",
+			!slices.Contains(cfg.AllowedOrigins, "*"), "invalid_value",
+			"credentials cannot be allowed for all origins")
+	}
+
+	v.CheckIntMin("max_age", cfg.MaxAge, 0)
+}
+
+type corsPolicy struct {
+	cfg *CORSCfg
+
+	allowedHeaders []string // lower case
+	exposedHeaders string
+
+	mutex sync.Mutex
+	// Methods registered for each path pattern
+	routeMethods map[string][]string
+	// OPTIONS route handlers for each path pattern
+	optionsRoutes map[string]*corsOptionsRoute
+}
+
+type corsOptionsRoute struct {
+	handlerFunc http.HandlerFunc
+	automatic   bool
+}
+
+func newCORSPolicy(cfg *CORSCfg) (*corsPolicy, error) {
+	if cfg.AllowCredentials && slices.Contains(cfg.AllowedOrigins, "*") {
+		return nil, fmt.Errorf("credentials cannot be allowed for all origins")
+	}
+
+	allowedHeaders := make([]string, len(cfg.AllowedHeaders))
+	for i, header := range cfg.AllowedHeaders {
+		allowedHeaders[i] = strings.ToLower(header)
+	}
+
+	return &corsPolicy{
+		cfg: cfg,
+
+		allowedHeaders: allowedHeaders,
+		exposedHeaders: strings.Join(cfg.ExposedHeaders, ", "),
+
+		routeMethods:  make(map[string][]string),
+		optionsRoutes: make(map[string]*corsOptionsRoute),
+	}, nil
+}
+
+// addRoute records the method of a route and returns true if it is the first
+// route registered for this path pattern.
+func (p *corsPolicy) addRoute(pathPattern, method string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	methods, found := p.routeMethods[pathPattern]
+	if method != "OPTIONS" && !slices.Contains(methods, method) {
+		methods = append(methods, method)
+	}
+	p.routeMethods[pathPattern] = methods
+
+	return !found
+}
+
+// setOptionsRoute records the handler of an OPTIONS route and returns true if
+// it must be registered in the muxer, i.e. if it does not replace an OPTIONS
+// route registered automatically.
+func (p *corsPolicy) setOptionsRoute(pathPattern string, handlerFunc http.HandlerFunc, automatic bool) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	route := p.optionsRoutes[pathPattern]
+	if route != nil && route.automatic && !automatic {
+		route.handlerFunc = handlerFunc
+		route.automatic = false
+		return false
+	}
+
+	// If an OPTIONS route was already registered by the application, the
+	// muxer will reject the new one as usual.
+	if route == nil {
+		p.optionsRoutes[pathPattern] = &corsOptionsRoute{
+			handlerFunc: handlerFunc,
+			automatic:   automatic,
+		}
+	}
+
+	return true
+}
+
+func (p *corsPolicy) optionsRoute(pathPattern string) http.HandlerFunc {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.optionsRoutes[pathPattern].handlerFunc
+}
+
+func (p *corsPolicy) allowedMethods(pathPattern string) []string {
+	if len(p.cfg.AllowedMethods) > 0 {
+		return slices.Clone(p.cfg.AllowedMethods)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return slices.Clone(p.routeMethods[pathPattern])
+}
+
+func (p *corsPolicy) originAllowed(origin string) bool {
+	origin = strings.ToLower(origin)
+
+	for _, pattern := range p.cfg.AllowedOrigins {
+		if matchCORSOrigin(strings.ToLower(pattern), origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchCORSOrigin(pattern, origin string) bool {
+	if pattern == "*" || pattern == origin {
+		return true
+	}
+
+	patternScheme, patternHost, found := strings.Cut(pattern, "://")
+	if !found || !strings.HasPrefix(patternHost, "*.") {
+		return false
+	}
+
+	scheme, host, found := strings.Cut(origin, "://")
+	if !found || scheme != patternScheme {
+		return false
+	}
+
+	// "https://*.example.com" matches "https://a.example.com" and
+	// "https://a.b.example.com" but not "https://example.com".
+	suffix := patternHost[1:]
+	return len(host) > len(suffix) && strings.HasSuffix(host, suffix)
+}
+
+func isCORSPreflightRequest(req *http.Request) bool {
+	return req.Method == "OPTIONS" &&
+		req.Header.Get("Origin") != "" &&
+		req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// handleRequest sets CORS headers for a request with an Origin header field.
+// For preflight requests, it sends the response and returns true.
+func (p *corsPolicy) handleRequest(h *Handler) bool {
+	req := h.Request
+	header := h.ResponseWriter.Header()
+
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+
+	preflight := isCORSPreflightRequest(req)
+
+	header.Add("Vary", "Origin")
+	if preflight {
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+	}
+
+	if !p.originAllowed(origin) {
+		if preflight {
+			h.Log.Info("rejecting CORS preflight request from origin %q",
+				origin)
+			h.ReplyError(403, "cors_origin_not_allowed",
+				"origin %q is not allowed", origin)
+			return true
+		}
+
+		// Browsers also send the Origin header field for same-origin
+		// requests with unsafe methods, so we do not reject anything here:
+		// without CORS response header fields, the browser will refuse to
+		// expose the response to a cross-origin caller.
+		if !sameOriginRequest(req, origin) {
+			h.Log.Info("ignoring CORS request from origin %q", origin)
+		}
+
+		return false
+	}
+
+	header.Set("Access-Control-Allow-Origin", origin)
+	if p.cfg.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if !preflight {
+		if p.exposedHeaders != "" {
+			header.Set("Access-Control-Expose-Headers", p.exposedHeaders)
+		}
+
+		return false
+	}
+
+	method := req.Header.Get("Access-Control-Request-Method")
+	allowedMethods := p.allowedMethods(h.PathPattern)
+
+	if !slices.Contains(allowedMethods, method) {
+		h.Log.Info("rejecting CORS preflight request from origin %q: "+
+			"method %q not allowed", origin, method)
+		h.ReplyError(403, "cors_method_not_allowed",
+			"method %q is not allowed", method)
+		return true
+	}
+
+	var requestedHeaders []string
+	for _, value := range req.Header.Values("Access-Control-Request-Headers") {
+		for name := range strings.SplitSeq(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				requestedHeaders = append(requestedHeaders,
+					strings.ToLower(name))
+			}
+		}
+	}
+
+	if !slices.Contains(p.allowedHeaders, "*") {
+		for _, name := range requestedHeaders {
+			if !slices.Contains(p.allowedHeaders, name) {
+				h.Log.Info("rejecting CORS preflight request from origin "+
+					"%q: header field %q not allowed", origin, name)
+				h.ReplyError(403, "cors_header_not_allowed",
+					"header field %q is not allowed", name)
+				return true
+			}
+		}
+	}
+
+	header.Set("Access-Control-Allow-Methods",
+		strings.Join(allowedMethods, ", "))
+
+	// The "*" wildcard is not supported for requests with credentials, so we
+	// always list requested header fields explicitly.
+	if len(requestedHeaders) > 0 {
+		header.Set("Access-Control-Allow-Headers",
+			strings.Join(requestedHeaders, ", "))
+	}
+
+	if p.cfg.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(p.cfg.MaxAge))
+	}
+
+	h.ReplyEmpty(204)
+	return true
+}
+
+func sameOriginRequest(req *http.Request, origin string) bool {
+	uri, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(uri.Host, req.Host)
+}
+
+func (s *Server) hCORSOptions(h *Handler) {
+	// Preflight requests are handled before route functions are called, so
+	// we only get here for plain OPTIONS requests.
+	methods := s.corsPolicy.allowedMethods(h.PathPattern)
+	if !slices.Contains(methods, "OPTIONS") {
+		methods = append(methods, "OPTIONS")
+	}
+
+	header := h.ResponseWriter.Header()
+	header.Set("Allow", strings.Join(methods, ", "))
+
+	h.ReplyEmpty(204)
+}
@@ -0,0 +1,175 @@
+package shttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchCORSOrigin(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(matchCORSOrigin("*", "https://example.com"))
+	assert.True(matchCORSOrigin("https://example.com", "https://example.com"))
+	assert.False(matchCORSOrigin("https://example.com", "http://example.com"))
+	assert.False(matchCORSOrigin("https://example.com",
+		"https://example.com:8080"))
+
+	assert.True(matchCORSOrigin("https://*.example.com",
+		"https://a.example.com"))
+	assert.True(matchCORSOrigin("https://*.example.com",
+		"https://a.b.example.com"))
+	assert.False(matchCORSOrigin("https://*.example.com",
+		"https://example.com"))
+	assert.False(matchCORSOrigin("https://*.example.com",
+		"https://aexample.com"))
+	assert.False(matchCORSOrigin("https://*.example.com",
+		"http://a.example.com"))
+}
+
+func TestCORS(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newTestServer(t, ServerCfg{
+		CORS: &CORSCfg{
+			AllowedOrigins:   []string{"https://*.example.com"},
+			AllowedHeaders:   []string{"Content-Type"},
+			AllowCredentials: true,
+			MaxAge:           600,
+		},
+	})
+
+	s.Route("/foo", "GET", func(h *Handler) {
+		h.ReplyText(200, "foo")
+	})
+
+	s.Route("/foo", "PUT", func(h *Handler) {
+		h.ReplyEmpty(204)
+	})
+
+	sendRequest := func(method, origin string, header http.Header) *http.Response {
+		req := httptest.NewRequest(method, "/foo", nil)
+		req.Header.Set("Origin", origin)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+
+		return sendTestRequest(s, req)
+	}
+
+	// Preflight requests
+	res := sendRequest("OPTIONS", "https://app.example.com", http.Header{
+		"Access-Control-Request-Method":  {"PUT"},
+		"Access-Control-Request-Headers": {"content-type"},
+	})
+	if assert.Equal(204, res.StatusCode) {
+		header := res.Header
+		assert.Equal("https://app.example.com",
+			header.Get("Access-Control-Allow-Origin"))
+		assert.Equal("true", header.Get("Access-Control-Allow-Credentials"))
+		assert.Equal("GET, PUT", header.Get("Access-Control-Allow-Methods"))
+		assert.Equal("content-type", header.Get("Access-Control-Allow-Headers"))
+		assert.Equal("600", header.Get("Access-Control-Max-Age"))
+	}
+
+	res = sendRequest("OPTIONS", "https://example.org", http.Header{
+		"Access-Control-Request-Method": {"PUT"},
+	})
+	assert.Equal(403, res.StatusCode)
+
+	res = sendRequest("OPTIONS", "https://app.example.com", http.Header{
+		"Access-Control-Request-Method": {"DELETE"},
+	})
+	assert.Equal(403, res.StatusCode)
+
+	res = sendRequest("OPTIONS", "https://app.example.com", http.Header{
+		"Access-Control-Request-Method":  {"PUT"},
+		"Access-Control-Request-Headers": {"x-foo"},
+	})
+	assert.Equal(403, res.StatusCode)
+
+	// Actual requests
+	res = sendRequest("GET", "https://app.example.com", nil)
+	if assert.Equal(200, res.StatusCode) {
+		assert.Equal("https://app.example.com",
+			res.Header.Get("Access-Control-Allow-Origin"))
+		assert.Equal("Origin", res.Header.Get("Vary"))
+	}
+
+	res = sendRequest("GET", "https://example.org", nil)
+	if assert.Equal(200, res.StatusCode) {
+		assert.Equal("", res.Header.Get("Access-Control-Allow-Origin"))
+	}
+
+	// Plain OPTIONS requests
+	res = sendTestRequest(s, httptest.NewRequest("OPTIONS", "/foo", nil))
+	if assert.Equal(204, res.StatusCode) {
+		assert.Equal("GET, PUT, OPTIONS", res.Header.Get("Allow"))
+	}
+}
+
+func TestCORSWildcardCredentials(t *testing.T) {
+	_, err := NewServer(ServerCfg{
+		CORS: &CORSCfg{
+			AllowedOrigins:   []string{"*"},
+			AllowCredentials: true,
+		},
+	})
+	assert.Error(t, err)
+}
+
+func TestCORSOptionsRoutes(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newTestServer(t, ServerCfg{
+		CORS: &CORSCfg{
+			AllowedOrigins: []string{"https://example.com"},
+		},
+	})
+
+	hOptions := func(h *Handler) {
+		h.ResponseWriter.Header().Set("Allow", "custom")
+		h.ReplyEmpty(200)
+	}
+
+	// OPTIONS route registered after the automatic one
+	s.Route("/foo", "GET", func(h *Handler) {
+		h.ReplyText(200, "foo")
+	})
+
+	assert.NotPanics(func() {
+		s.Route("/foo", "OPTIONS", hOptions)
+	})
+
+	// OPTIONS route registered before any other route
+	s.Route("/bar", "OPTIONS", hOptions)
+
+	assert.NotPanics(func() {
+		s.Route("/bar", "GET", func(h *Handler) {
+			h.ReplyText(200, "bar")
+		})
+	})
+
+	// Registering an OPTIONS route twice is still an error
+	assert.Panics(func() {
+		s.Route("/bar", "OPTIONS", hOptions)
+	})
+
+	for _, path := range []string{"/foo", "/bar"} {
+		res := sendTestRequest(s, httptest.NewRequest("OPTIONS", path, nil))
+		if assert.Equal(200, res.StatusCode) {
+			assert.Equal("custom", res.Header.Get("Allow"))
+		}
+
+		req := httptest.NewRequest("OPTIONS", path, nil)
+		req.Header.Set("Origin", "https://example.com")
+		req.Header.Set("Access-Control-Request-Method", "GET")
+
+		res = sendTestRequest(s, req)
+		if assert.Equal(204, res.StatusCode) {
+			assert.Equal("GET", res.Header.Get("Access-Control-Allow-Methods"))
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package shttp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+
+	"go.n16f.net/ejson"
+	"go.n16f.net/service/pkg/scrypto"
+)
+
+const (
+	DefaultCSRFFieldName  = "csrf_token"
+	DefaultCSRFHeaderName = "X-CSRF-Token"
+
+	csrfTokenSessionKey = "_csrf_token"
+)
+
+// CSRF protection combines two mechanisms:
+//
+// 1. Cross-origin requests with unsafe methods are rejected based on the
+// Sec-Fetch-Site and Origin headers (see http.CrossOriginProtection).
+//
+// 2. Requests with unsafe methods must contain a synchronizer token, either
+// in a form field or in a header field, matching the token stored in the
+// session. Templates obtain the token with the csrfToken function. The form
+// field is only read for application/x-www-form-urlencoded bodies: reading
+// multipart/form-data bodies would consume them before handlers can process
+// files, so multipart requests must send the token in the header field.
+//
+// Protection applies to all routes of the server unless they are registered
+// with the DisableCSRFProtection route option.
+
+type CSRFCfg struct {
+	TrustedOrigins []string `json:"trusted_origins"`
+	FieldName      string   `json:"field_name"`
+	HeaderName     string   `json:"header_name"`
+}
+
+func (cfg *CSRFCfg) ValidateJSON(v *ejson.Validator) {
+	v.WithChild("trusted_origins", func() {
+		for i, origin := range cfg.TrustedOrigins {
+			v.CheckStringURI(i, origin)
+		}
+	})
+}
+
+type csrfProtection struct {
+	cfg *CSRFCfg
+
+	crossOriginProtection *http.CrossOriginProtection
+}
+
+func newCSRFProtection(cfg *CSRFCfg) (*csrfProtection, error) {
+	if cfg.FieldName == "" {
+		cfg.FieldName = DefaultCSRFFieldName
+	}
+
+	if cfg.HeaderName == "" {
+		cfg.HeaderName = DefaultCSRFHeaderName
+	}
+
+	cop := http.NewCrossOriginProtection()
+
+	for _, origin := range cfg.TrustedOrigins {
+		if err := cop.AddTrustedOrigin(origin); err != nil {
+			return nil, fmt.Errorf("invalid trusted origin %q: %w", origin, err)
+		}
+	}
+
+	p := csrfProtection{
+		cfg: cfg,
+
+		crossOriginProtection: cop,
+	}
+
+	return &p, nil
+}
+
+func (p *csrfProtection) check(h *Handler) error {
+	req := h.Request
+
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return nil
+	}
+
+	if err := p.crossOriginProtection.Check(req); err != nil {
+		return err
+	}
+
+	sessionToken := h.Session().Get(csrfTokenSessionKey)
+	if sessionToken == "" {
+		return errors.New("missing session token")
+	}
+
+	token := req.Header.Get(p.cfg.HeaderName)
+	if token == "" {
+		contentType := req.Header.Get("Content-Type")
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+
+		// The form is stored in req.PostForm and reused by FormValues
+		if mediaType == "application/x-www-form-urlencoded" {
+			token = req.PostFormValue(p.cfg.FieldName)
+		}
+	}
+
+	if token == "" {
+		return errors.New("missing request token")
+	}
+
+	if !scrypto.EqualStrings(token, sessionToken) {
+		return errors.New("invalid request token")
+	}
+
+	return nil
+}
+
+// CSRFToken returns the CSRF token associated with the session of the
+// request, generating it if necessary.
+func (h *Handler) CSRFToken() string {
+	session := h.Session()
+
+	token := session.Get(csrfTokenSessionKey)
+	if token == "" {
+		token = base64.RawURLEncoding.EncodeToString(scrypto.RandomBytes(32))
+		session.Set(csrfTokenSessionKey, token)
+	}
+
+	return token
+}
+
+// CSRFTokenTemplateFunction is a placeholder for the csrfToken template
+// function. Templates must be parsed with it before being executed with the
+// actual function bound to a handler (see Handler.RenderHTMLTemplate).
+func CSRFTokenTemplateFunction() (string, error) {
+	return "", errors.New("csrfToken is only available in templates " +
+		"rendered for an HTTP request")
+}
+
+// RenderHTMLTemplate executes a template of a set of HTML templates with
+// functions bound to the request (csrfToken). HTML templates cannot be cloned
+// once executed, so the template set must never be executed directly. It is
+// slower than executing the template directly since the template set has to
+// be cloned and escaped again for each call.
+func (h *Handler) RenderHTMLTemplate(templates *template.Template, name string, data any) ([]byte, error) {
+	tpl, err := templates.Clone()
+	if err != nil {
+		return nil, fmt.Errorf("cannot clone HTML templates: %w", err)
+	}
+
+	tpl.Funcs(template.FuncMap{
+		"csrfToken": h.CSRFToken,
+	})
+
+	var buf bytes.Buffer
+
+	if err := tpl.ExecuteTemplate(&buf, name, data); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,243 @@
+package shttp
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.n16f.net/service/pkg/scrypto"
+)
+
+func TestCSRFProtection(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var key scrypto.AES256Key
+	copy(key[:], scrypto.RandomBytes(32))
+
+	s := newTestServer(t, ServerCfg{
+		Sessions: &SessionCfg{Keys: []scrypto.AES256Key{key}},
+		CSRF:     &CSRFCfg{},
+	})
+
+	s.Route("/form", "GET", func(h *Handler) {
+		h.ReplyText(200, h.CSRFToken())
+	})
+
+	s.Route("/form", "POST", func(h *Handler) {
+		h.ReplyEmpty(204)
+	})
+
+	s.RouteWithOptions("/webhook", "POST", func(h *Handler) {
+		h.ReplyEmpty(204)
+	}, RouteOptions{DisableCSRFProtection: true})
+
+	res := sendTestRequest(s, httptest.NewRequest("GET", "/form", nil))
+	require.Equal(200, res.StatusCode)
+	tokenData, err := io.ReadAll(res.Body)
+	require.NoError(err)
+	token := string(tokenData)
+	cookies := res.Cookies()
+	require.Len(cookies, 1)
+
+	post := func(form url.Values, header http.Header) *http.Response {
+		body := strings.NewReader(form.Encode())
+		req := httptest.NewRequest("POST", "/form", body)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		req.AddCookie(cookies[0])
+
+		return sendTestRequest(s, req)
+	}
+
+	assertFailure := func(res *http.Response) {
+		t.Helper()
+
+		assert.Equal(403, res.StatusCode)
+		body, _ := io.ReadAll(res.Body)
+		assert.True(strings.HasPrefix(string(body), "csrf_failure:"))
+	}
+
+	assertFailure(post(nil, nil))
+	assertFailure(post(url.Values{"csrf_token": {"foo"}}, nil))
+
+	res = post(url.Values{"csrf_token": {token}}, nil)
+	assert.Equal(204, res.StatusCode)
+
+	res = post(nil, http.Header{"X-Csrf-Token": {token}})
+	assert.Equal(204, res.StatusCode)
+
+	assertFailure(post(url.Values{"csrf_token": {token}},
+		http.Header{"Sec-Fetch-Site": {"cross-site"}}))
+	assertFailure(post(url.Values{"csrf_token": {token}},
+		http.Header{"Origin": {"http://example.org"}}))
+
+	res = sendTestRequest(s, httptest.NewRequest("POST", "/webhook", nil))
+	assert.Equal(204, res.StatusCode)
+}
+
+func TestCSRFProtectionForms(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var key scrypto.AES256Key
+	copy(key[:], scrypto.RandomBytes(32))
+
+	s := newTestServer(t, ServerCfg{
+		Sessions: &SessionCfg{Keys: []scrypto.AES256Key{key}},
+		CSRF:     &CSRFCfg{},
+	})
+
+	s.Route("/form", "GET", func(h *Handler) {
+		h.ReplyText(200, h.CSRFToken())
+	})
+
+	var values url.Values
+	var fileContent string
+
+	s.Route("/form", "POST", func(h *Handler) {
+		var err error
+		values, err = h.FormValues()
+		if err != nil {
+			return
+		}
+
+		h.ReplyEmpty(204)
+	})
+
+	s.Route("/upload", "POST", func(h *Handler) {
+		formData, err := h.MultipartFormData(MultipartCfg{
+			FileHandler: func(file *FormFile, r io.Reader) error {
+				data, err := io.ReadAll(r)
+				fileContent = string(data)
+				return err
+			},
+		})
+		if err != nil {
+			return
+		}
+
+		values = formData.Values
+		h.ReplyEmpty(204)
+	})
+
+	res := sendTestRequest(s, httptest.NewRequest("GET", "/form", nil))
+	require.Equal(200, res.StatusCode)
+	tokenData, err := io.ReadAll(res.Body)
+	require.NoError(err)
+	token := string(tokenData)
+	cookies := res.Cookies()
+	require.Len(cookies, 1)
+
+	// The form field read by the CSRF check is still available to the
+	// handler.
+	form := url.Values{"csrf_token": {token}, "name": {"foo"}}
+	req := httptest.NewRequest("POST", "/form",
+		strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookies[0])
+
+	res = sendTestRequest(s, req)
+	require.Equal(204, res.StatusCode)
+	assert.Equal("foo", values.Get("name"))
+
+	// Multipart bodies are not read by the CSRF check, so the token must be
+	// sent in the header field.
+	sendMultipart := func(header http.Header) *http.Response {
+		body, contentType := newTestMultipartBody(t,
+			map[string]string{"csrf_token": token, "name": "bar"},
+			map[string]string{"file": "hello"})
+
+		req := httptest.NewRequest("POST", "/upload", body)
+		req.Header.Set("Content-Type", contentType)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		req.AddCookie(cookies[0])
+
+		return sendTestRequest(s, req)
+	}
+
+	res = sendMultipart(nil)
+	assert.Equal(403, res.StatusCode)
+
+	res = sendMultipart(http.Header{"X-Csrf-Token": {token}})
+	require.Equal(204, res.StatusCode)
+	assert.Equal("bar", values.Get("name"))
+	assert.Equal("hello", fileContent)
+}
+
+func TestRenderHTMLTemplate(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var key scrypto.AES256Key
+	copy(key[:], scrypto.RandomBytes(32))
+
+	s := newTestServer(t, ServerCfg{
+		Sessions: &SessionCfg{Keys: []scrypto.AES256Key{key}},
+		CSRF:     &CSRFCfg{},
+	})
+
+	templates := template.New("")
+	templates.Funcs(template.FuncMap{"csrfToken": CSRFTokenTemplateFunction})
+	template.Must(templates.New("form").Parse(
+		`{{ .Name }}{{ if .Token }} {{ csrfToken }}{{ end }}`))
+
+	type formData struct {
+		Name  string
+		Token bool
+	}
+
+	var token string
+
+	s.Route("/form", "GET", func(h *Handler) {
+		data := formData{
+			Name:  "foo",
+			Token: h.QueryParameter("token") != "",
+		}
+
+		body, err := h.RenderHTMLTemplate(templates, "form", data)
+		if err != nil {
+			h.ReplyInternalError(500, "%v", err)
+			return
+		}
+
+		if data.Token {
+			token = h.CSRFToken()
+		}
+
+		h.ReplyText(200, string(body))
+	})
+
+	// The token, and therefore the session, is only created if the template
+	// uses it.
+	res := sendTestRequest(s, httptest.NewRequest("GET", "/form", nil))
+	require.Equal(200, res.StatusCode)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(err)
+	assert.Equal("foo", string(body))
+	assert.Empty(res.Cookies())
+
+	res = sendTestRequest(s, httptest.NewRequest("GET", "/form?token=1", nil))
+	require.Equal(200, res.StatusCode)
+	body, err = io.ReadAll(res.Body)
+	require.NoError(err)
+	assert.Equal("foo "+token, string(body))
+	assert.Len(res.Cookies(), 1)
+
+	// The placeholder fails outside of a request
+	var buf bytes.Buffer
+	tpl, err := templates.Clone()
+	require.NoError(err)
+	assert.Error(tpl.ExecuteTemplate(&buf, "form", formData{Token: true}))
+}
@@ -0,0 +1,475 @@
+package shttp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+
+	"go.n16f.net/ejson"
+)
+
+const (
+	DefaultMaxFormFieldsSize = 1_000_000 // bytes
+)
+
+var (
+	errFormFileTooLarge   = errors.New("file too large")
+	errFormFieldsTooLarge = errors.New("form fields too large")
+	errTooManyFormFiles   = errors.New("too many files")
+	errFormFileProcessing = errors.New("cannot process file")
+)
+
+type MultipartCfg struct {
+	// The maximum total size of non-file fields.
+	MaxFieldsSize int64 // bytes
+
+	// The maximum size of each file; 0 means that files are only limited by
+	// the maximum request body size.
+	MaxFileSize int64 // bytes
+
+	// The maximum number of files; 0 means no limit.
+	MaxFiles int
+
+	// The directory files are written to; default to the temporary
+	// directory of the system.
+	FileDirectory string
+
+	// If set, FileHandler is called for each file part with a reader on the
+	// content of the file instead of writing the file to disk. It must read
+	// the content before returning.
+	FileHandler func(file *FormFile, r io.Reader) error
+}
+
+type FormData struct {
+	Values url.Values
+	Files  map[string][]*FormFile
+}
+
+type FormFile struct {
+	FieldName   string
+	FileName    string
+	ContentType string
+	Header      textproto.MIMEHeader
+
+	// The size of the file and its path on disk. The path is empty if the
+	// file was processed by a file handler.
+	Size int64
+	Path string
+}
+
+// File returns the first file of a field, or nil if there is none.
+func (d *FormData) File(name string) *FormFile {
+	if files := d.Files[name]; len(files) > 0 {
+		return files[0]
+	}
+
+	return nil
+}
+
+// RemoveFiles deletes all files written to disk. Handlers must call it once
+// they are done with the files, usually with defer.
+func (d *FormData) RemoveFiles() error {
+	var errs []error
+
+	for _, files := range d.Files {
+		for _, file := range files {
+			if file.Path == "" {
+				continue
+			}
+
+			if err := os.Remove(file.Path); err != nil &&
+				!errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (f *FormFile) Open() (*os.File, error) {
+	if f.Path == "" {
+		return nil, fmt.Errorf("file %q was not written to disk", f.FileName)
+	}
+
+	return os.Open(f.Path)
+}
+
+// FormValues returns the fields of an application/x-www-form-urlencoded or
+// multipart/form-data request body. File parts of multipart bodies are
+// ignored. If the body was already parsed by net/http, e.g. by the CSRF
+// protection, the parsed fields are returned.
+func (h *Handler) FormValues() (url.Values, error) {
+	req := h.Request
+
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		if req.PostForm != nil {
+			return req.PostForm, nil
+		}
+
+		data, err := h.RequestData()
+		if err != nil {
+			return nil, err
+		}
+
+		values, err := url.ParseQuery(string(data))
+		if err != nil {
+			h.ReplyError(400, "invalid_request_body",
+				"invalid request body: %v", err)
+			return nil, fmt.Errorf("invalid form data: %w", err)
+		}
+
+		return values, nil
+
+	case "multipart/form-data":
+		if req.MultipartForm != nil {
+			return req.MultipartForm.Value, nil
+		}
+
+		cfg := MultipartCfg{
+			FileHandler: func(file *FormFile, r io.Reader) error {
+				_, err := io.Copy(io.Discard, r)
+				return err
+			},
+		}
+
+		formData, err := h.MultipartFormData(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		return formData.Values, nil
+
+	default:
+		return nil, h.replyUnsupportedFormMediaType()
+	}
+}
+
+// FormRequestData decodes the fields of a form request body into a struct
+// using the "form" tag of its fields. Decoding errors are reported as
+// validation errors. If the struct implements ejson.Validatable, it is then
+// validated.
+func (h *Handler) FormRequestData(dest any) error {
+	values, err := h.FormValues()
+	if err != nil {
+		return err
+	}
+
+	return h.FormRequestDataExt(values, dest, nil)
+}
+
+// FormRequestDataExt is the equivalent of JSONRequestDataExt for form data. It
+// is also useful to decode the fields of a multipart form obtained with
+// MultipartFormData.
+func (h *Handler) FormRequestDataExt(values url.Values, dest any, fn func(*ejson.Validator) error) error {
+	return h.decodeStruct(dest, fn, "invalid_request_body", "request body",
+		urlValuesSource("form", values))
+}
+
+// MultipartFormData reads a multipart/form-data request body. Files are
+// written to disk unless the configuration contains a file handler. If the
+// body was already parsed by net/http (see http.Request.ParseMultipartForm),
+// fields and files are obtained from the parsed form.
+func (h *Handler) MultipartFormData(cfg MultipartCfg) (*FormData, error) {
+	if cfg.MaxFieldsSize == 0 {
+		cfg.MaxFieldsSize = DefaultMaxFormFieldsSize
+	}
+
+	mediaType, params, _ := mime.ParseMediaType(
+		h.Request.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return nil, h.replyUnsupportedFormMediaType()
+	}
+
+	if form := h.Request.MultipartForm; form != nil {
+		formData, err := parsedMultipartFormData(form, &cfg)
+		if err != nil {
+			h.replyMultipartFormError(err, &cfg)
+			return nil, fmt.Errorf("cannot read multipart form: %w", err)
+		}
+
+		return formData, nil
+	}
+
+	boundary := params["boundary"]
+	if boundary == "" {
+		h.ReplyError(400, "invalid_request_body",
+			"missing multipart boundary")
+		return nil, fmt.Errorf("missing multipart boundary")
+	}
+
+	body, err := h.requestBodyReader()
+	if err != nil {
+		if errors.Is(err, errUnsupportedContentEncoding) {
+			h.ReplyError(415, "unsupported_content_encoding", "%v", err)
+		} else {
+			h.ReplyError(400, "invalid_request_body",
+				"invalid request body: %v", err)
+		}
+
+		return nil, fmt.Errorf("cannot read request body: %w", err)
+	}
+	defer body.Close()
+
+	formData := FormData{
+		Values: make(url.Values),
+		Files:  make(map[string][]*FormFile),
+	}
+
+	if err := readMultipartForm(multipart.NewReader(body, boundary), &cfg,
+		&formData); err != nil {
+		formData.RemoveFiles()
+		h.replyMultipartFormError(err, &cfg)
+		return nil, fmt.Errorf("cannot read multipart form: %w", err)
+	}
+
+	return &formData, nil
+}
+
+func (h *Handler) replyMultipartFormError(err error, cfg *MultipartCfg) {
+	var maxBytesErr *http.MaxBytesError
+
+	switch {
+	case errors.As(err, &maxBytesErr):
+		h.replyRequestBodyTooLarge(maxBytesErr.Limit)
+	case errors.Is(err, errFormFileTooLarge):
+		h.ReplyError(413, "file_too_large",
+			"file too large (maximum size: %d bytes)", cfg.MaxFileSize)
+	case errors.Is(err, errFormFieldsTooLarge):
+		h.ReplyError(413, "form_fields_too_large",
+			"form fields too large (maximum size: %d bytes)",
+			cfg.MaxFieldsSize)
+	case errors.Is(err, errTooManyFormFiles):
+		h.ReplyError(413, "too_many_files",
+			"too many files (maximum: %d)", cfg.MaxFiles)
+	case errors.Is(err, errFormFileProcessing):
+		h.ReplyInternalError(500, "%v", err)
+	default:
+		h.ReplyError(400, "invalid_request_body",
+			"invalid request body: %v", err)
+	}
+}
+
+func readMultipartForm(r *multipart.Reader, cfg *MultipartCfg, formData *FormData) error {
+	var fieldsSize int64
+	var nbFiles int
+
+	for {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		name := part.FormName()
+		if name == "" {
+			part.Close()
+			continue
+		}
+
+		if part.FileName() == "" {
+			maxSize := cfg.MaxFieldsSize - fieldsSize
+
+			data, err := io.ReadAll(io.LimitReader(part, maxSize+1))
+			part.Close()
+			if err != nil {
+				return err
+			}
+
+			fieldsSize += int64(len(data))
+			if fieldsSize > cfg.MaxFieldsSize {
+				return errFormFieldsTooLarge
+			}
+
+			formData.Values.Add(name, string(data))
+			continue
+		}
+
+		nbFiles++
+		if cfg.MaxFiles > 0 && nbFiles > cfg.MaxFiles {
+			part.Close()
+			return errTooManyFormFiles
+		}
+
+		file := FormFile{
+			FieldName:   name,
+			FileName:    part.FileName(),
+			ContentType: part.Header.Get("Content-Type"),
+			Header:      part.Header,
+		}
+
+		if file.ContentType == "" {
+			file.ContentType = "application/octet-stream"
+		}
+
+		err = readMultipartFile(part, cfg, &file)
+		part.Close()
+
+		// Register the file even if it is incomplete so that it can be
+		// removed.
+		formData.Files[name] = append(formData.Files[name], &file)
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func parsedMultipartFormData(form *multipart.Form, cfg *MultipartCfg) (*FormData, error) {
+	formData := FormData{
+		Values: url.Values(form.Value),
+		Files:  make(map[string][]*FormFile),
+	}
+
+	var nbFiles int
+
+	for name, fileHeaders := range form.File {
+		for _, fileHeader := range fileHeaders {
+			nbFiles++
+			if cfg.MaxFiles > 0 && nbFiles > cfg.MaxFiles {
+				formData.RemoveFiles()
+				return nil, errTooManyFormFiles
+			}
+
+			file := FormFile{
+				FieldName:   name,
+				FileName:    fileHeader.Filename,
+				ContentType: fileHeader.Header.Get("Content-Type"),
+				Header:      fileHeader.Header,
+			}
+
+			if file.ContentType == "" {
+				file.ContentType = "application/octet-stream"
+			}
+
+			err := readParsedMultipartFile(fileHeader, cfg, &file)
+			formData.Files[name] = append(formData.Files[name], &file)
+
+			if err != nil {
+				formData.RemoveFiles()
+				return nil, err
+			}
+		}
+	}
+
+	return &formData, nil
+}
+
+func readParsedMultipartFile(fileHeader *multipart.FileHeader, cfg *MultipartCfg, file *FormFile) error {
+	f, err := fileHeader.Open()
+	if err != nil {
+		return fmt.Errorf("%w %q: %w", errFormFileProcessing, file.FileName, err)
+	}
+	defer f.Close()
+
+	return readMultipartFile(f, cfg, file)
+}
+
+func readMultipartFile(part io.Reader, cfg *MultipartCfg, file *FormFile) error {
+	var r io.Reader = part
+	if cfg.MaxFileSize > 0 {
+		r = &formFileReader{r: part, remaining: cfg.MaxFileSize}
+	}
+
+	cr := countingReader{r: r}
+
+	if cfg.FileHandler != nil {
+		if err := cfg.FileHandler(file, &cr); err != nil {
+			file.Size = cr.n
+
+			// Errors caused by the request body are reported as they
+			// are; other errors are caused by the handler.
+			if cr.err != nil && errors.Is(err, cr.err) {
+				return err
+			}
+
+			return fmt.Errorf("%w %q: %w", errFormFileProcessing, file.FileName,
+				err)
+		}
+
+		file.Size = cr.n
+		return nil
+	}
+
+	f, err := os.CreateTemp(cfg.FileDirectory, "upload-*")
+	if err != nil {
+		return fmt.Errorf("%w %q: %w", errFormFileProcessing, file.FileName, err)
+	}
+
+	file.Path = f.Name()
+
+	_, err = io.Copy(f, &cr)
+	file.Size = cr.n
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil && cr.err == nil {
+		err = fmt.Errorf("%w %q: %w", errFormFileProcessing, file.FileName,
+			err)
+	}
+
+	return err
+}
+
+func (h *Handler) replyUnsupportedFormMediaType() error {
+	contentType := h.Request.Header.Get("Content-Type")
+
+	h.ReplyError(415, "unsupported_media_type",
+		"unsupported content type %q", contentType)
+	return fmt.Errorf("unsupported content type %q", contentType)
+}
+
+type formFileReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (r *formFileReader) Read(data []byte) (int, error) {
+	if r.remaining <= 0 {
+		// Only report an error if there is more data
+		var buf [1]byte
+		if n, _ := r.r.Read(buf[:]); n > 0 {
+			return 0, errFormFileTooLarge
+		}
+
+		return 0, io.EOF
+	}
+
+	if int64(len(data)) > r.remaining {
+		data = data[:r.remaining]
+	}
+
+	n, err := r.r.Read(data)
+	r.remaining -= int64(n)
+
+	return n, err
+}
+
+type countingReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (r *countingReader) Read(data []byte) (int, error) {
+	n, err := r.r.Read(data)
+	r.n += int64(n)
+
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+
+	return n, err
+}
@@ -0,0 +1,244 @@
+package shttp
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.n16f.net/uuid"
+)
+
+type testFormData struct {
+	Name    string     `form:"name"`
+	Count   int        `form:"count"`
+	Enabled *bool      `form:"enabled"`
+	Tags    []string   `form:"tag"`
+	Id      uuid.UUID  `form:"id"`
+	Ratios  []float64  `form:"ratio"`
+	Ignored string     `form:"-"`
+	Other   string     // no tag
+	Parent  *uuid.UUID `form:"parent"`
+}
+
+func newTestMultipartBody(t *testing.T, fields map[string]string, files map[string]string) (*bytes.Buffer, string) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+
+	for name, value := range fields {
+		require.NoError(t, w.WriteField(name, value))
+	}
+
+	for name, content := range files {
+		fw, err := w.CreateFormFile(name, name+".txt")
+		require.NoError(t, err)
+
+		_, err = io.WriteString(fw, content)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, w.Close())
+
+	return &buf, w.FormDataContentType()
+}
+
+func TestFormRequestData(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newTestServer(t, ServerCfg{})
+
+	var data testFormData
+
+	s.Route("/", "POST", func(h *Handler) {
+		data = testFormData{}
+		if err := h.FormRequestData(&data); err != nil {
+			return
+		}
+
+		h.ReplyEmpty(204)
+	})
+
+	sendRequest := func(contentType string, body io.Reader) int {
+		req := httptest.NewRequest("POST", "/", body)
+		req.Header.Set("Content-Type", contentType)
+		return sendTestRequest(s, req).StatusCode
+	}
+
+	id := "0193e4a2-7f4b-7c2f-9d5e-2c5b4f1e8a10"
+
+	status := sendRequest("application/x-www-form-urlencoded",
+		strings.NewReader("name=foo&count=42&enabled=true&tag=a&tag=b"+
+			"&id="+id+"&ratio=0.5&Other=x"))
+	if assert.Equal(204, status) {
+		assert.Equal("foo", data.Name)
+		assert.Equal(42, data.Count)
+		if assert.NotNil(data.Enabled) {
+			assert.True(*data.Enabled)
+		}
+		assert.Equal([]string{"a", "b"}, data.Tags)
+		assert.Equal(id, data.Id.String())
+		assert.Equal([]float64{0.5}, data.Ratios)
+		assert.Equal("", data.Other)
+		assert.Nil(data.Parent)
+	}
+
+	body, contentType := newTestMultipartBody(t,
+		map[string]string{"name": "bar", "count": "1"},
+		map[string]string{"file": "ignored"})
+	if assert.Equal(204, sendRequest(contentType, body)) {
+		assert.Equal("bar", data.Name)
+		assert.Equal(1, data.Count)
+	}
+
+	assert.Equal(415, sendRequest("application/json",
+		strings.NewReader(`{}`)))
+}
+
+func TestMultipartFormData(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	s := newTestServer(t, ServerCfg{})
+
+	var formData *FormData
+	var fileContents map[string]string
+
+	s.Route("/disk", "POST", func(h *Handler) {
+		var err error
+		formData, err = h.MultipartFormData(MultipartCfg{
+			MaxFileSize:   10,
+			MaxFiles:      2,
+			FileDirectory: t.TempDir(),
+		})
+		if err != nil {
+			return
+		}
+
+		fileContents = make(map[string]string)
+		for name := range formData.Files {
+			data, err := os.ReadFile(formData.File(name).Path)
+			if err != nil {
+				h.ReplyInternalError(500, "%v", err)
+				return
+			}
+
+			fileContents[name] = string(data)
+		}
+
+		h.ReplyEmpty(204)
+	})
+
+	s.Route("/callback", "POST", func(h *Handler) {
+		fileContents = make(map[string]string)
+
+		var err error
+		formData, err = h.MultipartFormData(MultipartCfg{
+			FileHandler: func(file *FormFile, r io.Reader) error {
+				data, err := io.ReadAll(r)
+				fileContents[file.FieldName] = string(data)
+				return err
+			},
+		})
+		if err != nil {
+			return
+		}
+
+		h.ReplyEmpty(204)
+	})
+
+	sendRequest := func(path string, fields, files map[string]string) int {
+		body, contentType := newTestMultipartBody(t, fields, files)
+
+		req := httptest.NewRequest("POST", path, body)
+		req.Header.Set("Content-Type", contentType)
+		return sendTestRequest(s, req).StatusCode
+	}
+
+	fields := map[string]string{"a": "1"}
+	files := map[string]string{"f1": "hello", "f2": "world"}
+
+	require.Equal(204, sendRequest("/disk", fields, files))
+	assert.Equal("1", formData.Values.Get("a"))
+	assert.Equal(files, fileContents)
+
+	file := formData.File("f1")
+	if assert.NotNil(file) {
+		assert.Equal("f1.txt", file.FileName)
+		assert.Equal(int64(5), file.Size)
+	}
+
+	require.NoError(formData.RemoveFiles())
+	_, err := os.Stat(file.Path)
+	assert.ErrorIs(err, os.ErrNotExist)
+
+	assert.Equal(413, sendRequest("/disk", nil,
+		map[string]string{"f": "0123456789a"}))
+	assert.Equal(413, sendRequest("/disk", nil,
+		map[string]string{"f1": "a", "f2": "b", "f3": "c"}))
+
+	require.Equal(204, sendRequest("/callback", fields, files))
+	assert.Equal(files, fileContents)
+	assert.Equal("", formData.File("f2").Path)
+	assert.Equal(int64(5), formData.File("f2").Size)
+}
+
+func TestMultipartFormDataParsed(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	s := newTestServer(t, ServerCfg{})
+
+	var formData *FormData
+
+	s.Route("/", "POST", func(h *Handler) {
+		if err := h.Request.ParseMultipartForm(1_000_000); err != nil {
+			h.ReplyInternalError(500, "%v", err)
+			return
+		}
+
+		var err error
+		formData, err = h.MultipartFormData(MultipartCfg{
+			MaxFiles:      1,
+			FileDirectory: t.TempDir(),
+		})
+		if err != nil {
+			return
+		}
+
+		h.ReplyEmpty(204)
+	})
+
+	sendRequest := func(fields, files map[string]string) int {
+		body, contentType := newTestMultipartBody(t, fields, files)
+
+		req := httptest.NewRequest("POST", "/", body)
+		req.Header.Set("Content-Type", contentType)
+		return sendTestRequest(s, req).StatusCode
+	}
+
+	require.Equal(204, sendRequest(map[string]string{"a": "1"},
+		map[string]string{"f": "hello"}))
+	assert.Equal("1", formData.Values.Get("a"))
+
+	file := formData.File("f")
+	if assert.NotNil(file) {
+		assert.Equal("f.txt", file.FileName)
+		assert.Equal(int64(5), file.Size)
+
+		data, err := os.ReadFile(file.Path)
+		require.NoError(err)
+		assert.Equal("hello", string(data))
+	}
+
+	require.NoError(formData.RemoveFiles())
+
+	assert.Equal(413, sendRequest(nil,
+		map[string]string{"f1": "a", "f2": "b"}))
+}
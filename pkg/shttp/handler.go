@@ -2,12 +2,14 @@ package shttp
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
@@ -20,6 +22,7 @@ import (
 	"go.n16f.net/log"
 	"go.n16f.net/program"
 	"go.n16f.net/service/pkg/influx"
+	"go.n16f.net/service/pkg/tracing"
 	"go.n16f.net/service/pkg/utils"
 	"go.n16f.net/uuid"
 )
@@ -41,10 +44,42 @@ type Handler struct {
 	ResponseWriter http.ResponseWriter
 
 	ClientAddress string // optional
-	RequestId     string // optional
+	RequestId     string
 
-	start     time.Time
-	errorCode string
+	// The verified certificate of the client when TLS client
+	// authentication is enabled.
+	PeerCertificate *x509.Certificate // optional
+
+	start       time.Time
+	errorCode   string
+	rateLimited bool
+
+	session *Session
+	span    *tracing.Span
+}
+
+// Context returns the context of the request. It contains the request id
+// and should be used for all operations performed for the request.
+func (h *Handler) Context() context.Context {
+	return h.Request.Context()
+}
+
+// SetWriteDeadline sets the deadline for writing the response, overriding the
+// write timeout of the server or route. A zero value means no deadline.
+func (h *Handler) SetWriteDeadline(deadline time.Time) error {
+	rc := http.NewResponseController(h.ResponseWriter)
+	return rc.SetWriteDeadline(deadline)
+}
+
+func (h *Handler) setWriteTimeout(timeout time.Duration) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	if err := h.SetWriteDeadline(deadline); err != nil {
+		h.Log.Error("cannot set write deadline: %v", err)
+	}
 }
 
 func (h *Handler) PathVariable(name string) string {
@@ -95,10 +130,63 @@ func (h *Handler) UUIDQueryParameter(name string) (uuid.UUID, error) {
 	return id, nil
 }
 
+// RequestParameters decodes path variables and query parameters into a struct
+// using the "path" and "query" tags of its fields, e.g. `query:"limit"`. If
+// the struct implements ejson.Validatable, it is then validated. All decoding
+// and validation errors are reported in a single 400 response.
+func (h *Handler) RequestParameters(dest any) error {
+	return h.RequestParametersExt(dest, nil)
+}
+
+// RequestParametersExt is the equivalent of RequestParameters with an
+// additional validation function.
+func (h *Handler) RequestParametersExt(dest any, fn func(*ejson.Validator) error) error {
+	pathSource := structValueSource{
+		tag: "path",
+		lookup: func(name string) []string {
+			if value := h.Request.PathValue(name); value != "" {
+				return []string{value}
+			}
+
+			return nil
+		},
+	}
+
+	return h.decodeStruct(dest, fn, "invalid_query_parameter",
+		"query parameters", pathSource, urlValuesSource("query", h.Query))
+}
+
+// RequestData reads and returns the request body, decoding it if it was sent
+// with a supported content coding.
 func (h *Handler) RequestData() ([]byte, error) {
-	data, err := ioutil.ReadAll(h.Request.Body)
+	body, err := h.requestBodyReader()
+	if err != nil {
+		if errors.Is(err, errUnsupportedContentEncoding) {
+			h.ReplyError(415, "unsupported_content_encoding", "%v", err)
+		} else {
+			h.ReplyError(400, "invalid_request_body",
+				"invalid request body: %v", err)
+		}
+
+		return nil, fmt.Errorf("cannot read request body: %w", err)
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
 	if err != nil {
-		h.ReplyInternalError(500, "cannot read request body: %v", err)
+		var maxBytesErr *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &maxBytesErr):
+			h.replyRequestBodyTooLarge(maxBytesErr.Limit)
+		case errors.Is(err, gzip.ErrChecksum), errors.Is(err, gzip.ErrHeader),
+			errors.Is(err, io.ErrUnexpectedEOF):
+			h.ReplyError(400, "invalid_request_body",
+				"invalid request body: %v", err)
+		default:
+			h.ReplyInternalError(500, "cannot read request body: %v", err)
+		}
+
 		return nil, fmt.Errorf("cannot read request body: %w", err)
 	}
 
@@ -159,6 +247,18 @@ func (h *Handler) AcceptedMediaRanges() MediaRanges {
 	return mrs
 }
 
+func (h *Handler) AcceptedContentCodings() ContentCodings {
+	acceptEncoding := h.Request.Header.Get("Accept-Encoding")
+	if acceptEncoding == "" {
+		return nil
+	}
+
+	var ccs ContentCodings
+	ccs.Parse(acceptEncoding)
+
+	return ccs
+}
+
 func (h *Handler) AddCookie(cookie *http.Cookie) {
 	header := h.ResponseWriter.Header()
 	header.Add("Set-Cookie", cookie.String())
@@ -256,12 +356,16 @@ func (h *Handler) ReplyErrorData(status int, code string, data ErrorData, format
 }
 
 func (h *Handler) ReplyValidationErrors(err ejson.ValidationErrors) {
+	h.replyValidationErrors(err, "invalid_request_body", "request body")
+}
+
+func (h *Handler) replyValidationErrors(err ejson.ValidationErrors, errorCode, errorLabel string) {
 	data := ValidationJSONErrorData{
 		ValidationErrors: err,
 	}
 
-	h.ReplyErrorData(400, "invalid_request_body", data,
-		"invalid request body:\n%v", err)
+	h.ReplyErrorData(400, errorCode, data, "invalid %s:\n%v", errorLabel,
+		err)
 }
 
 func (h *Handler) ReplyInternalError(status int, format string, args ...any) {
@@ -364,9 +468,13 @@ func (h *Handler) WriteSSE(id, event, data string) error {
 	}
 
 	if data != "" {
-		buf.WriteString("data: ")
-		buf.WriteString(data)
-		buf.WriteByte('\n')
+		// Each line must be sent in its own data field; readers join them
+		// with newline characters.
+		for line := range strings.SplitSeq(data, "\n") {
+			buf.WriteString("data: ")
+			buf.WriteString(line)
+			buf.WriteByte('\n')
+		}
 	}
 
 	buf.WriteByte('\n')
@@ -473,6 +581,30 @@ func (h *Handler) logRequest() {
 		utils.FormatSeconds(reqTime.Seconds(), 1))
 }
 
+func (h *Handler) endSpan() {
+	if h.span == nil {
+		return
+	}
+
+	w := h.ResponseWriter.(*ResponseWriter)
+
+	if w.Status != 0 {
+		h.span.SetAttribute("http.response.status_code", w.Status)
+	}
+
+	if w.Status >= 500 {
+		errorType := h.errorCode
+		if errorType == "" {
+			errorType = strconv.Itoa(w.Status)
+		}
+
+		h.span.SetAttribute("error.type", errorType)
+		h.span.SetStatus(tracing.SpanStatusError, "")
+	}
+
+	h.span.End()
+}
+
 func (h *Handler) sendInfluxPoints() {
 	if h.Server.Cfg.InfluxClient == nil {
 		return
@@ -491,17 +623,7 @@ func (h *Handler) sendInfluxPoints() {
 		tags["route"] = h.RouteId
 	}
 
-	var status string
-	if w.Status >= 200 && w.Status < 300 {
-		status = "2xx"
-	} else if w.Status >= 300 && w.Status < 400 {
-		status = "3xx"
-	} else if w.Status >= 400 && w.Status < 500 {
-		status = "4xx"
-	} else if w.Status >= 500 && w.Status < 600 {
-		status = "5xx"
-	}
-	if status != "" {
+	if status := statusClass(w.Status); status != "" {
 		tags["status"] = status
 	}
 
@@ -514,8 +636,27 @@ func (h *Handler) sendInfluxPoints() {
 		fields["status_code"] = w.Status
 	}
 
+	if h.rateLimited {
+		fields["rate_limited"] = 1
+	}
+
 	point := influx.NewPointWithTimestamp("incoming_http_requests",
 		tags, fields, now)
 
 	h.Server.Cfg.InfluxClient.EnqueuePoint(point)
 }
+
+func statusClass(status int) string {
+	switch {
+	case status >= 200 && status < 300:
+		return "2xx"
+	case status >= 300 && status < 400:
+		return "3xx"
+	case status >= 400 && status < 500:
+		return "4xx"
+	case status >= 500 && status < 600:
+		return "5xx"
+	default:
+		return ""
+	}
+}
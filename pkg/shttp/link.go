@@ -0,0 +1,165 @@
+package shttp
+
+import (
+	"slices"
+	"strings"
+)
+
+// Link is a web link as defined in RFC 8288.
+type Link struct {
+	URI string
+
+	// Relation types are lowercase.
+	Rel []string
+
+	// Target attributes; names are lowercase.
+	Params map[string]string
+}
+
+func (l *Link) HasRel(rel string) bool {
+	return slices.Contains(l.Rel, strings.ToLower(rel))
+}
+
+// ParseLinkHeader parses the values of Link header fields. Invalid links are
+// ignored.
+func ParseLinkHeader(values []string) []*Link {
+	var links []*Link
+
+	for _, value := range values {
+		for len(value) > 0 {
+			var link *Link
+			link, value = parseLink(value)
+			if link != nil {
+				links = append(links, link)
+			}
+		}
+	}
+
+	return links
+}
+
+// FindLink returns the first link with a specific relation type or nil if
+// there is none.
+func FindLink(links []*Link, rel string) *Link {
+	for _, link := range links {
+		if link.HasRel(rel) {
+			return link
+		}
+	}
+
+	return nil
+}
+
+func parseLink(s string) (*Link, string) {
+	s = strings.TrimLeft(s, " \t,")
+	if s == "" {
+		return nil, ""
+	}
+
+	// Link URIs can contain commas and semicolons so we have to handle
+	// them before splitting parameters.
+	if s[0] != '<' {
+		return nil, skipLink(s)
+	}
+
+	end := strings.IndexByte(s, '>')
+	if end == -1 {
+		return nil, ""
+	}
+
+	link := Link{
+		URI:    s[1:end],
+		Params: make(map[string]string),
+	}
+
+	s = s[end+1:]
+
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" || s[0] == ',' {
+			break
+		}
+
+		if s[0] != ';' {
+			return nil, skipLink(s)
+		}
+
+		s = strings.TrimLeft(s[1:], " \t")
+
+		var name, value string
+		name, value, s = parseLinkParam(s)
+		if name == "" {
+			continue
+		}
+
+		// Only the first occurrence of a parameter is used
+		if _, found := link.Params[name]; !found {
+			link.Params[name] = value
+		}
+	}
+
+	if rel := link.Params["rel"]; rel != "" {
+		link.Rel = strings.Fields(strings.ToLower(rel))
+	}
+
+	return &link, s
+}
+
+func parseLinkParam(s string) (string, string, string) {
+	end := strings.IndexAny(s, "=;,")
+	if end == -1 {
+		return strings.ToLower(strings.TrimSpace(s)), "", ""
+	}
+
+	name := strings.ToLower(strings.TrimSpace(s[:end]))
+	if s[end] != '=' {
+		return name, "", s[end:]
+	}
+
+	s = strings.TrimLeft(s[end+1:], " \t")
+
+	if len(s) > 0 && s[0] == '"' {
+		var buf strings.Builder
+
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				if i+1 < len(s) {
+					i++
+					buf.WriteByte(s[i])
+				}
+			case '"':
+				return name, buf.String(), s[i+1:]
+			default:
+				buf.WriteByte(s[i])
+			}
+		}
+
+		return name, buf.String(), ""
+	}
+
+	end = strings.IndexAny(s, ";,")
+	if end == -1 {
+		return name, strings.TrimSpace(s), ""
+	}
+
+	return name, strings.TrimSpace(s[:end]), s[end:]
+}
+
+// skipLink returns the part of the header field after the next link.
+func skipLink(s string) string {
+	quoted := false
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			return s[i:]
+		}
+	}
+
+	return ""
+}
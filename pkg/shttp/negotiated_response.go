@@ -0,0 +1,227 @@
+package shttp
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	MediaTypeJSON = "application/json"
+	MediaTypeYAML = "application/yaml"
+	MediaTypeText = "text/plain"
+	MediaTypeCSV  = "text/csv"
+	MediaTypeHTML = "text/html"
+)
+
+// CSVMarshaler is implemented by values which can be sent as CSV data by
+// ReplyNegotiated. The first record is usually a header.
+type CSVMarshaler interface {
+	MarshalCSV() ([][]string, error)
+}
+
+// ReplyNegotiated sends a value using the representation which best matches
+// the Accept header field of the request. Values can always be sent as JSON
+// or YAML; strings, byte slices, errors and fmt.Stringer values can be sent
+// as text; [][]string and CSVMarshaler values can be sent as CSV. JSON is used
+// if the request does not contain any Accept header field. If no
+// representation is acceptable, a 406 error is sent.
+func (h *Handler) ReplyNegotiated(status int, value any) {
+	h.ReplyNegotiatedWithTemplate(status, value, "")
+}
+
+// ReplyNegotiatedWithTemplate is the equivalent of ReplyNegotiated with an
+// additional HTML representation obtained by executing a template of the
+// server with the value as data.
+func (h *Handler) ReplyNegotiatedWithTemplate(status int, value any, templateName string) {
+	mediaTypes := []string{MediaTypeJSON, MediaTypeYAML}
+
+	if _, ok := textRepresentation(value); ok {
+		mediaTypes = append(mediaTypes, MediaTypeText)
+	}
+
+	switch value.(type) {
+	case [][]string, CSVMarshaler:
+		mediaTypes = append(mediaTypes, MediaTypeCSV)
+	}
+
+	if templateName != "" {
+		mediaTypes = append(mediaTypes, MediaTypeHTML)
+	}
+
+	switch h.NegotiateMediaType(mediaTypes...) {
+	case MediaTypeJSON:
+		h.ReplyJSON(status, value)
+
+	case MediaTypeYAML:
+		h.ReplyYAML(status, value)
+
+	case MediaTypeText:
+		text, _ := textRepresentation(value)
+		h.ReplyText(status, text)
+
+	case MediaTypeCSV:
+		records, ok := value.([][]string)
+		if !ok {
+			var err error
+			records, err = value.(CSVMarshaler).MarshalCSV()
+			if err != nil {
+				h.ReplyInternalError(500, "cannot encode CSV data: %v", err)
+				return
+			}
+		}
+
+		h.ReplyCSV(status, records)
+
+	case MediaTypeHTML:
+		h.ReplyHTMLTemplate(status, templateName, value)
+
+	default:
+		h.ReplyError(406, "not_acceptable", "no acceptable representation "+
+			"available (supported media types: %s)",
+			strings.Join(mediaTypes, ", "))
+	}
+}
+
+// NegotiateMediaType returns the media type which best matches the Accept
+// header field of the request, or an empty string if none of them is
+// acceptable. Media types are passed by order of preference; the first one is
+// returned if the request does not contain any Accept header field.
+func (h *Handler) NegotiateMediaType(mediaTypes ...string) string {
+	// The function can be called several times for the same response, for
+	// example when a negotiated response is replaced by an error.
+	header := h.ResponseWriter.Header()
+	if !slices.Contains(header.Values("Vary"), "Accept") {
+		header.Add("Vary", "Accept")
+	}
+
+	mediaRanges := h.AcceptedMediaRanges()
+	if mediaRanges == nil {
+		if len(mediaTypes) == 0 {
+			return ""
+		}
+
+		return mediaTypes[0]
+	}
+
+	return mediaRanges.SelectMediaType(mediaTypes...)
+}
+
+// ReplyYAML sends a value as a YAML document. The value is encoded the same
+// way as with ReplyJSON, so that JSON struct tags and custom JSON encoding
+// methods are used.
+func (h *Handler) ReplyYAML(status int, value any) {
+	data, err := encodeYAML(value)
+	if err != nil {
+		h.Log.Error("cannot encode YAML response: %v", err)
+		h.ResponseWriter.WriteHeader(500)
+		return
+	}
+
+	header := h.ResponseWriter.Header()
+	header.Set("Content-Type", MediaTypeYAML)
+
+	h.Reply(status, bytes.NewReader(data))
+}
+
+func (h *Handler) ReplyCSV(status int, records [][]string) {
+	var buf bytes.Buffer
+
+	w := csv.NewWriter(&buf)
+	w.UseCRLF = true // RFC 4180
+
+	if err := w.WriteAll(records); err != nil {
+		h.Log.Error("cannot encode CSV response: %v", err)
+		h.ResponseWriter.WriteHeader(500)
+		return
+	}
+
+	header := h.ResponseWriter.Header()
+	header.Set("Content-Type", "text/csv; charset=UTF-8")
+
+	h.Reply(status, &buf)
+}
+
+// ReplyHTMLTemplate executes a template of the set of HTML templates of the
+// server with functions bound to the request (see Handler.RenderHTMLTemplate)
+// and sends the result.
+func (h *Handler) ReplyHTMLTemplate(status int, name string, data any) {
+	templates := h.Server.Cfg.HTMLTemplates
+	if templates == nil {
+		h.ReplyInternalError(500, "no HTML templates configured")
+		return
+	}
+
+	body, err := h.RenderHTMLTemplate(templates, name, data)
+	if err != nil {
+		h.ReplyInternalError(500, "cannot execute template %q: %v", name, err)
+		return
+	}
+
+	header := h.ResponseWriter.Header()
+	header.Set("Content-Type", "text/html; charset=UTF-8")
+
+	h.Reply(status, bytes.NewReader(body))
+}
+
+func textRepresentation(value any) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case []byte:
+		return string(v), true
+	case error:
+		return v.Error(), true
+	case fmt.Stringer:
+		return v.String(), true
+	default:
+		return "", false
+	}
+}
+
+// encodeYAML encodes a value as a YAML document. The value is first encoded
+// to JSON so that JSON struct tags and custom JSON encoding methods are used.
+// Since JSON documents are YAML documents, the JSON document is then decoded
+// to a YAML node, which preserves the order of object members, and written
+// in block style.
+func encodeYAML(value any) ([]byte, error) {
+	jsonData, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var node yaml.Node
+	if err := yaml.Unmarshal(jsonData, &node); err != nil {
+		return nil, err
+	}
+
+	resetYAMLNodeStyle(&node)
+
+	var buf bytes.Buffer
+
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+
+	if err := encoder.Encode(&node); err != nil {
+		return nil, err
+	}
+
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func resetYAMLNodeStyle(node *yaml.Node) {
+	node.Style = 0
+
+	for _, child := range node.Content {
+		resetYAMLNodeStyle(child)
+	}
+}
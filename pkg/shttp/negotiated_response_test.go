@@ -0,0 +1,190 @@
+package shttp
+
+import (
+	"html/template"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.n16f.net/service/pkg/scrypto"
+)
+
+type testNegotiatedValue struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+	Id    string `json:"id"`
+	Form  bool   `json:"-"`
+}
+
+func (v *testNegotiatedValue) MarshalCSV() ([][]string, error) {
+	records := [][]string{
+		{"name", "count"},
+		{v.Name, "42"},
+	}
+
+	return records, nil
+}
+
+func TestReplyNegotiated(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	templates := template.New("")
+	templates.Funcs(template.FuncMap{"csrfToken": CSRFTokenTemplateFunction})
+	template.Must(templates.New("value").Parse(
+		`<p>{{.Name}}</p>{{ if .Form }}<input value="{{ csrfToken }}">{{ end }}`))
+
+	var key scrypto.AES256Key
+	copy(key[:], scrypto.RandomBytes(32))
+
+	s := newTestServer(t, ServerCfg{
+		HTMLTemplates: templates,
+		ErrorHandler:  AdaptativeErrorHandler,
+		Sessions:      &SessionCfg{Keys: []scrypto.AES256Key{key}},
+	})
+
+	value := testNegotiatedValue{Name: "foo", Count: 42, Id: "123"}
+
+	s.Route("/value", "GET", func(h *Handler) {
+		h.ReplyNegotiatedWithTemplate(200, &value, "value")
+	})
+
+	var token string
+
+	s.Route("/form", "GET", func(h *Handler) {
+		h.ReplyNegotiatedWithTemplate(200, &testNegotiatedValue{
+			Name: "foo",
+			Form: true,
+		}, "value")
+
+		token = h.CSRFToken()
+	})
+
+	s.Route("/text", "GET", func(h *Handler) {
+		h.ReplyNegotiated(200, "hello")
+	})
+
+	sendRequest := func(path, accept string) (int, string, string) {
+		req := httptest.NewRequest("GET", path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+
+		res := sendTestRequest(s, req)
+
+		body, err := io.ReadAll(res.Body)
+		require.NoError(err)
+
+		return res.StatusCode, res.Header.Get("Content-Type"), string(body)
+	}
+
+	status, contentType, body := sendRequest("/value", "")
+	assert.Equal(200, status)
+	assert.Equal("application/json", contentType)
+	assert.JSONEq(`{"name": "foo", "count": 42, "id": "123"}`, body)
+
+	status, contentType, body = sendRequest("/value",
+		"application/json;q=0.5, application/yaml")
+	assert.Equal(200, status)
+	assert.Equal("application/yaml", contentType)
+	assert.Equal("name: foo\ncount: 42\nid: \"123\"\n", body)
+
+	status, contentType, body = sendRequest("/value", "text/csv")
+	assert.Equal(200, status)
+	assert.Equal("text/csv; charset=UTF-8", contentType)
+	assert.Equal("name,count\r\nfoo,42\r\n", body)
+
+	status, contentType, body = sendRequest("/value",
+		"text/html, application/xhtml+xml, */*;q=0.8")
+	assert.Equal(200, status)
+	assert.Equal("text/html; charset=UTF-8", contentType)
+	assert.Equal("<p>foo</p>", body)
+
+	// HTML templates are rendered with functions bound to the request
+	status, _, body = sendRequest("/form", "text/html")
+	assert.Equal(200, status)
+	assert.Equal(`<p>foo</p><input value="`+token+`">`, body)
+
+	status, contentType, body = sendRequest("/text", "text/*")
+	assert.Equal(200, status)
+	assert.Equal("text/plain; charset=UTF-8", contentType)
+	assert.Equal("hello", body)
+
+	// Errors are negotiated too
+	status, contentType, _ = sendRequest("/text", "image/png")
+	assert.Equal(406, status)
+	assert.Equal("application/json", contentType)
+
+	req := httptest.NewRequest("GET", "/text", nil)
+	req.Header.Set("Accept", "image/png")
+	res := sendTestRequest(s, req)
+	assert.Equal([]string{"Accept"}, res.Header.Values("Vary"))
+
+	status, contentType, _ = sendRequest("/text",
+		"image/png, text/plain;q=0")
+	assert.Equal(406, status)
+	assert.Equal("application/json", contentType)
+
+	status, contentType, _ = sendRequest("/value",
+		"application/yaml, text/html;q=0")
+	assert.Equal(200, status)
+	assert.Equal("application/yaml", contentType)
+
+	status, contentType, body = sendRequest("/unknown", "application/yaml")
+	assert.Equal(404, status)
+	assert.Equal("application/yaml", contentType)
+	assert.Contains(body, "code: not_found")
+}
+
+func TestEncodeYAML(t *testing.T) {
+	assert := assert.New(t)
+
+	encode := func(value any) string {
+		data, err := encodeYAML(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(data)
+	}
+
+	assert.Equal("null\n", encode(nil))
+	assert.Equal("42\n", encode(42))
+	assert.Equal("\"true\"\n", encode("true"))
+	assert.Equal("{}\n", encode(map[string]int{}))
+	assert.Equal("[]\n", encode([]int{}))
+
+	assert.Equal(`a: foo bar
+b: "1.5"
+c: 'a: b'
+d: |-
+  line 1
+  line 2
+e: ""
+f:
+  - 1
+  - x: 2
+    y: null
+  - []
+  - - true
+g:
+  h: {}
+`, encode(struct {
+		A string `json:"a"`
+		B string `json:"b"`
+		C string `json:"c"`
+		D string `json:"d"`
+		E string `json:"e"`
+		F []any  `json:"f"`
+		G any    `json:"g"`
+	}{
+		A: "foo bar",
+		B: "1.5",
+		C: "a: b",
+		D: "line 1\nline 2",
+		F: []any{1, map[string]any{"x": 2, "y": nil}, []int{}, []bool{true}},
+		G: map[string]any{"h": map[string]int{}},
+	}))
+}
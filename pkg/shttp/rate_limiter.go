@@ -0,0 +1,209 @@
+package shttp
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Rate limiters use the token bucket algorithm: each key has a bucket
+// containing up to Limit tokens, refilled at a rate of Limit tokens per
+// Period. Each request consumes one token and is rejected if the bucket is
+// empty.
+
+type RateLimiterCfg struct {
+	// The name of the limiter is used to namespace keys, so that multiple
+	// limiters can share the same backend.
+	Name string
+
+	Limit  int
+	Period time.Duration
+
+	// The key function identifies the client a request is associated with,
+	// for example a user id. It defaults to the client address. Requests
+	// for which the key function returns an empty string are not limited.
+	KeyFunc func(*Handler) string
+
+	// The default backend is a MemoryRateLimiterBackend.
+	Backend RateLimiterBackend
+}
+
+type RateLimiterBackend interface {
+	Take(key string, limit int, period time.Duration) (*RateLimitResult, error)
+}
+
+type RateLimitResult struct {
+	Allowed bool
+
+	// The number of requests which can still be performed right now.
+	Remaining int
+
+	// The delay after which the bucket will be full again.
+	ResetDelay time.Duration
+
+	// The delay after which a request will be accepted again; only set when
+	// the request was rejected.
+	RetryDelay time.Duration
+}
+
+type RateLimiter struct {
+	Cfg RateLimiterCfg
+}
+
+func NewRateLimiter(cfg RateLimiterCfg) (*RateLimiter, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("missing or empty name")
+	}
+
+	if cfg.Limit < 1 {
+		return nil, fmt.Errorf("invalid limit %d", cfg.Limit)
+	}
+
+	if cfg.Period <= 0 {
+		return nil, fmt.Errorf("invalid period %v", cfg.Period)
+	}
+
+	if cfg.KeyFunc == nil {
+		cfg.KeyFunc = func(h *Handler) string {
+			return h.ClientAddress
+		}
+	}
+
+	if cfg.Backend == nil {
+		cfg.Backend = NewMemoryRateLimiterBackend()
+	}
+
+	l := RateLimiter{
+		Cfg: cfg,
+	}
+
+	return &l, nil
+}
+
+// check applies the rate limiter to a request, replying with a 429 status if
+// the request is rejected. Backend errors are logged and do not cause
+// requests to be rejected.
+func (l *RateLimiter) check(h *Handler) bool {
+	key := l.Cfg.KeyFunc(h)
+	if key == "" {
+		return true
+	}
+
+	key = l.Cfg.Name + ":" + key
+
+	result, err := l.Cfg.Backend.Take(key, l.Cfg.Limit, l.Cfg.Period)
+	if err != nil {
+		h.Log.Error("cannot apply rate limiter %q: %v", l.Cfg.Name, err)
+		return true
+	}
+
+	header := h.ResponseWriter.Header()
+
+	header.Set("RateLimit-Limit", strconv.Itoa(l.Cfg.Limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("RateLimit-Reset", formatDelaySeconds(result.ResetDelay))
+
+	if !result.Allowed {
+		header.Set("Retry-After", formatDelaySeconds(result.RetryDelay))
+
+		h.rateLimited = true
+		h.ReplyError(429, "rate_limit_exceeded", "too many requests")
+		return false
+	}
+
+	return true
+}
+
+func formatDelaySeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+func tokenBucketResult(allowed bool, tokens float64, limit int, period time.Duration) *RateLimitResult {
+	tokenDelay := float64(period) / float64(limit)
+
+	result := RateLimitResult{
+		Allowed:    allowed,
+		Remaining:  int(math.Floor(tokens)),
+		ResetDelay: time.Duration((float64(limit) - tokens) * tokenDelay),
+	}
+
+	if !allowed {
+		result.RetryDelay = time.Duration((1.0 - tokens) * tokenDelay)
+	}
+
+	return &result
+}
+
+type MemoryRateLimiterBackend struct {
+	buckets map[string]*memoryRateLimiterBucket
+	mutex   sync.Mutex
+
+	lastCleanup time.Time
+
+	now func() time.Time
+}
+
+type memoryRateLimiterBucket struct {
+	tokens     float64
+	updateTime time.Time
+	fullTime   time.Time
+}
+
+func NewMemoryRateLimiterBackend() *MemoryRateLimiterBackend {
+	return &MemoryRateLimiterBackend{
+		buckets: make(map[string]*memoryRateLimiterBucket),
+
+		now: time.Now,
+	}
+}
+
+func (b *MemoryRateLimiterBackend) Take(key string, limit int, period time.Duration) (*RateLimitResult, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := b.now()
+
+	rate := float64(limit) / period.Seconds()
+
+	bucket, found := b.buckets[key]
+	if found {
+		elapsed := now.Sub(bucket.updateTime).Seconds()
+		bucket.tokens = min(float64(limit), bucket.tokens+elapsed*rate)
+	} else {
+		bucket = &memoryRateLimiterBucket{tokens: float64(limit)}
+		b.buckets[key] = bucket
+	}
+
+	bucket.updateTime = now
+
+	allowed := bucket.tokens >= 1.0
+	if allowed {
+		bucket.tokens -= 1.0
+	}
+
+	missingTokens := float64(limit) - bucket.tokens
+	bucket.fullTime = now.Add(time.Duration(missingTokens / rate * 1e9))
+
+	b.cleanup(now)
+
+	return tokenBucketResult(allowed, bucket.tokens, limit, period), nil
+}
+
+func (b *MemoryRateLimiterBackend) cleanup(now time.Time) {
+	// Full buckets are equivalent to missing buckets, so we can regularly
+	// delete them to keep memory usage under control.
+
+	if now.Sub(b.lastCleanup) < time.Minute {
+		return
+	}
+
+	for key, bucket := range b.buckets {
+		if !now.Before(bucket.fullTime) {
+			delete(b.buckets, key)
+		}
+	}
+
+	b.lastCleanup = now
+}
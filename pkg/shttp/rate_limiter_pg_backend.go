@@ -0,0 +1,123 @@
+package shttp
+
+import (
+	"fmt"
+	"time"
+
+	"go.n16f.net/service/pkg/pg"
+)
+
+const DefaultPgRateLimiterBackendTableName = "shttp_rate_limits"
+
+// PgRateLimiterBackend stores token buckets in a PostgreSQL table so that rate
+// limits are shared by all instances of a service.
+type PgRateLimiterBackend struct {
+	Client    *pg.Client
+	TableName string
+}
+
+// NewPgRateLimiterBackend creates a rate limiter backend using a PostgreSQL
+// table. The table is not created by the constructor: it is created by the
+// migrations returned by Migrations, usually applied with UpdateSchema when
+// the application starts.
+func NewPgRateLimiterBackend(client *pg.Client, tableName string) (*PgRateLimiterBackend, error) {
+	if tableName == "" {
+		tableName = DefaultPgRateLimiterBackendTableName
+	}
+
+	b := PgRateLimiterBackend{
+		Client:    client,
+		TableName: tableName,
+	}
+
+	return &b, nil
+}
+
+// Migrations returns the migrations creating and updating the rate limit
+// table. Their schema is the name of the table, so that versions are tracked
+// separately for each table in the schema_versions table.
+func (b *PgRateLimiterBackend) Migrations() pg.Migrations {
+	return pg.Migrations{
+		{
+			Schema:  b.TableName,
+			Version: "20261018T231224Z",
+			Code: []byte(fmt.Sprintf(`
+CREATE TABLE %s
+  (key TEXT PRIMARY KEY,
+   tokens DOUBLE PRECISION NOT NULL,
+   allowed BOOLEAN NOT NULL,
+   update_time TIMESTAMPTZ NOT NULL);
+`, pg.QuoteIdentifier(b.TableName))),
+		},
+	}
+}
+
+// UpdateSchema applies the migrations of the rate limit table which have not
+// been applied yet.
+func (b *PgRateLimiterBackend) UpdateSchema() error {
+	if err := b.Client.ApplyMigrations(b.TableName, b.Migrations()); err != nil {
+		return fmt.Errorf("cannot update rate limit table %q: %w",
+			b.TableName, err)
+	}
+
+	return nil
+}
+
+func (b *PgRateLimiterBackend) Take(key string, limit int, period time.Duration) (*RateLimitResult, error) {
+	// The whole token bucket update happens in a single statement so that
+	// concurrent requests for the same key are serialized by PostgreSQL.
+
+	// Note that in the update clause, t refers to the existing row, locked
+	// by PostgreSQL until the end of the statement.
+	tokens := `LEAST($2::DOUBLE PRECISION, t.tokens + ` +
+		`$3::DOUBLE PRECISION * GREATEST(0, EXTRACT(EPOCH FROM ` +
+		`CURRENT_TIMESTAMP - t.update_time)))`
+
+	query := fmt.Sprintf(`
+INSERT INTO %[1]s AS t (key, tokens, allowed, update_time)
+  VALUES ($1, $2::DOUBLE PRECISION - 1.0, TRUE, CURRENT_TIMESTAMP)
+  ON CONFLICT (key) DO UPDATE
+    SET tokens = CASE WHEN %[2]s >= 1.0 THEN %[2]s - 1.0 ELSE %[2]s END,
+        allowed = %[2]s >= 1.0,
+        update_time = CURRENT_TIMESTAMP
+  RETURNING t.tokens, t.allowed
+`, pg.QuoteIdentifier(b.TableName), tokens)
+
+	rate := float64(limit) / period.Seconds()
+
+	var remainingTokens float64
+	var allowed bool
+
+	err := b.Client.WithConn(func(conn pg.Conn) error {
+		row := pg.QueryRow(conn, query, key, float64(limit), rate)
+		return row.Scan(&remainingTokens, &allowed)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tokenBucketResult(allowed, remainingTokens, limit, period), nil
+}
+
+// DeleteStaleEntries deletes buckets which have not been updated for more
+// than a specific delay. The delay must be at least as long as the longest
+// period of all limiters using the backend. It is meant to be called
+// regularly, for example from a service worker.
+func (b *PgRateLimiterBackend) DeleteStaleEntries(delay time.Duration) (int64, error) {
+	query := fmt.Sprintf(`
+DELETE FROM %s
+  WHERE update_time < CURRENT_TIMESTAMP - $1::DOUBLE PRECISION * INTERVAL '1 second'
+`, pg.QuoteIdentifier(b.TableName))
+
+	var n int64
+
+	err := b.Client.WithConn(func(conn pg.Conn) (err error) {
+		n, err = pg.Exec2(conn, query, delay.Seconds())
+		return
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
@@ -0,0 +1,85 @@
+package shttp
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRateLimiterBackend(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	b := NewMemoryRateLimiterBackend()
+	b.now = func() time.Time { return now }
+
+	take := func(key string) *RateLimitResult {
+		result, err := b.Take(key, 3, 3*time.Second)
+		require.NoError(err)
+		return result
+	}
+
+	for i := range 3 {
+		result := take("a")
+		assert.True(result.Allowed)
+		assert.Equal(2-i, result.Remaining)
+		assert.Equal(time.Duration(i+1)*time.Second, result.ResetDelay)
+	}
+
+	result := take("a")
+	assert.False(result.Allowed)
+	assert.Equal(0, result.Remaining)
+	assert.Equal(time.Second, result.RetryDelay)
+
+	// Keys are independent
+	assert.True(take("b").Allowed)
+
+	now = now.Add(500 * time.Millisecond)
+	result = take("a")
+	assert.False(result.Allowed)
+	assert.Equal(500*time.Millisecond, result.RetryDelay)
+
+	now = now.Add(500 * time.Millisecond)
+	assert.True(take("a").Allowed)
+	assert.False(take("a").Allowed)
+
+	// Full buckets are deleted after some time
+	now = now.Add(time.Hour)
+	assert.True(take("c").Allowed)
+	assert.Len(b.buckets, 1)
+}
+
+func TestRateLimiter(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	s := newTestServer(t, ServerCfg{})
+
+	limiter, err := NewRateLimiter(RateLimiterCfg{
+		Name:   "test",
+		Limit:  2,
+		Period: time.Minute,
+	})
+	require.NoError(err)
+
+	s.RouteWithOptions("/foo", "GET", func(h *Handler) {
+		h.ReplyEmpty(204)
+	}, RouteOptions{RateLimiter: limiter})
+
+	for i := range 2 {
+		res := sendTestRequest(s, httptest.NewRequest("GET", "/foo", nil))
+		assert.Equal(204, res.StatusCode)
+		assert.Equal("2", res.Header.Get("RateLimit-Limit"))
+		assert.Equal(strconv.Itoa(1-i), res.Header.Get("RateLimit-Remaining"))
+	}
+
+	res := sendTestRequest(s, httptest.NewRequest("GET", "/foo", nil))
+	assert.Equal(429, res.StatusCode)
+	assert.Equal("30", res.Header.Get("Retry-After"))
+}
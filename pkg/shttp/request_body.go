@@ -0,0 +1,83 @@
+package shttp
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	DefaultMaxRequestBodySize = 10_000_000 // bytes
+)
+
+var (
+	errUnsupportedContentEncoding = errors.New("unsupported content encoding")
+)
+
+// maxRequestBodySize returns the maximum request body size for the route, or
+// a negative value if there is no limit.
+func (h *Handler) maxRequestBodySize() int64 {
+	if size := h.Options.MaxRequestBodySize; size != 0 {
+		return size
+	}
+
+	return h.Server.Cfg.MaxRequestBodySize
+}
+
+// limitRequestBody makes sure that we never read more than the maximum
+// request body size. Requests whose announced body size is already too large
+// are rejected immediately.
+func (h *Handler) limitRequestBody() bool {
+	maxSize := h.maxRequestBodySize()
+	if maxSize < 0 {
+		return true
+	}
+
+	if h.Request.ContentLength > maxSize {
+		h.replyRequestBodyTooLarge(maxSize)
+		return false
+	}
+
+	h.Request.Body = http.MaxBytesReader(h.ResponseWriter, h.Request.Body,
+		maxSize)
+
+	return true
+}
+
+func (h *Handler) replyRequestBodyTooLarge(maxSize int64) {
+	h.ReplyError(413, "request_body_too_large",
+		"request body too large (maximum size: %d bytes)", maxSize)
+}
+
+// requestBodyReader returns a reader for the decoded request body. The
+// maximum request body size also applies to the decoded body so that small
+// compressed bodies cannot expand to arbitrarily large ones.
+func (h *Handler) requestBodyReader() (io.ReadCloser, error) {
+	body := h.Request.Body
+
+	encoding := strings.ToLower(h.Request.Header.Get("Content-Encoding"))
+
+	switch encoding {
+	case "", "identity":
+		return body, nil
+
+	case "gzip", "x-gzip":
+		r, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip data: %w", err)
+		}
+
+		if maxSize := h.maxRequestBodySize(); maxSize >= 0 {
+			return http.MaxBytesReader(nil, r, maxSize), nil
+		}
+
+		return r, nil
+
+	default:
+		return nil, fmt.Errorf("%w %q", errUnsupportedContentEncoding,
+			encoding)
+	}
+}
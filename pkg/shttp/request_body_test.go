@@ -0,0 +1,126 @@
+package shttp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gzipTestData(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	return buf.Bytes()
+}
+
+func TestRequestBodyLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newTestServer(t, ServerCfg{
+		MaxRequestBodySize: 10,
+	})
+
+	var data []byte
+
+	routeFunc := func(h *Handler) {
+		var err error
+		data, err = h.RequestData()
+		if err != nil {
+			return
+		}
+
+		h.ReplyEmpty(204)
+	}
+
+	s.Route("/small", "POST", routeFunc)
+	s.RouteWithOptions("/large", "POST", routeFunc,
+		RouteOptions{MaxRequestBodySize: 100})
+	s.RouteWithOptions("/unlimited", "POST", routeFunc,
+		RouteOptions{MaxRequestBodySize: -1})
+
+	sendRequest := func(path string, body io.Reader) int {
+		req := httptest.NewRequest("POST", path, body)
+		return sendTestRequest(s, req).StatusCode
+	}
+
+	body := strings.Repeat("a", 20)
+
+	assert.Equal(204, sendRequest("/small", strings.NewReader("abc")))
+	assert.Equal("abc", string(data))
+
+	// Known content length
+	assert.Equal(413, sendRequest("/small", strings.NewReader(body)))
+
+	// Unknown content length
+	assert.Equal(413, sendRequest("/small",
+		io.MultiReader(strings.NewReader(body))))
+
+	assert.Equal(204, sendRequest("/large", strings.NewReader(body)))
+	assert.Equal(body, string(data))
+
+	assert.Equal(204, sendRequest("/unlimited",
+		strings.NewReader(strings.Repeat("a", 1000))))
+
+	// Request bodies are limited by default
+	s = newTestServer(t, ServerCfg{})
+	s.Route("/small", "POST", routeFunc)
+	s.RouteWithOptions("/unlimited", "POST", routeFunc,
+		RouteOptions{MaxRequestBodySize: -1})
+
+	body = strings.Repeat("a", DefaultMaxRequestBodySize+1)
+
+	assert.Equal(413, sendRequest("/small", strings.NewReader(body)))
+
+	assert.Equal(204, sendRequest("/unlimited", strings.NewReader(body)))
+	assert.Len(data, len(body))
+}
+
+func TestRequestBodyDecoding(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newTestServer(t, ServerCfg{
+		MaxRequestBodySize: 1000,
+	})
+
+	var value map[string]int
+
+	s.Route("/", "POST", func(h *Handler) {
+		value = nil
+		if err := h.JSONRequestData(&value); err != nil {
+			return
+		}
+
+		h.ReplyEmpty(204)
+	})
+
+	sendRequest := func(encoding string, body []byte) int {
+		req := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+		req.Header.Set("Content-Encoding", encoding)
+		return sendTestRequest(s, req).StatusCode
+	}
+
+	data := gzipTestData(t, []byte(`{"a": 1}`))
+	assert.Equal(204, sendRequest("gzip", data))
+	assert.Equal(map[string]int{"a": 1}, value)
+
+	assert.Equal(400, sendRequest("gzip", []byte(`{"a": 1}`)))
+	assert.Equal(400, sendRequest("gzip", data[:len(data)-4]))
+
+	assert.Equal(415, sendRequest("br", []byte(`{"a": 1}`)))
+
+	// The decoded body is subject to the same size limit
+	data = gzipTestData(t, bytes.Repeat([]byte(" "), 10_000))
+	assert.Less(len(data), 1000)
+	assert.Equal(413, sendRequest("gzip", data))
+}
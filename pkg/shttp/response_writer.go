@@ -11,6 +11,13 @@ type ResponseWriter struct {
 	Status int
 
 	w http.ResponseWriter
+
+	headerWritten     bool
+	beforeWriteHeader []func()
+
+	compression *responseCompression // optional
+	compressing bool
+	hijacked    bool
 }
 
 func NewResponseWriter(w http.ResponseWriter) *ResponseWriter {
@@ -19,15 +26,47 @@ func NewResponseWriter(w http.ResponseWriter) *ResponseWriter {
 	}
 }
 
+// BeforeWriteHeader registers a function to be called right before the
+// response header is sent, i.e. when it is still possible to modify it.
+func (w *ResponseWriter) BeforeWriteHeader(fn func()) {
+	w.beforeWriteHeader = append(w.beforeWriteHeader, fn)
+}
+
+// Unwrap returns the underlying response writer; it is used by
+// http.ResponseController.
+func (w *ResponseWriter) Unwrap() http.ResponseWriter {
+	return w.w
+}
+
 func (w *ResponseWriter) Header() http.Header {
 	return w.w.Header()
 }
 
 func (w *ResponseWriter) Write(data []byte) (int, error) {
+	if !w.headerWritten {
+		w.WriteHeader(200)
+	}
+
+	if w.compressing {
+		return w.compression.write(data)
+	}
+
 	return w.w.Write(data)
 }
 
 func (w *ResponseWriter) WriteHeader(status int) {
+	if !w.headerWritten {
+		w.headerWritten = true
+
+		w.runBeforeWriteHeader()
+
+		if w.compression != nil && w.compression.writeHeader(status) {
+			w.Status = status
+			w.compressing = true
+			return
+		}
+	}
+
 	w.Status = status
 
 	w.w.WriteHeader(status)
@@ -40,10 +79,49 @@ func (w *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 			fmt.Errorf("response writer does not support connection hijacking")
 	}
 
-	return hijacker.Hijack()
+	conn, rw, err := hijacker.Hijack()
+	if err == nil {
+		w.headerWritten = true
+		w.hijacked = true
+	}
+
+	return conn, rw, err
+}
+
+// runBeforeWriteHeader calls registered functions once. It is used directly
+// when the response header is written on a hijacked connection.
+func (w *ResponseWriter) runBeforeWriteHeader() {
+	fns := w.beforeWriteHeader
+	w.beforeWriteHeader = nil
+
+	for _, fn := range fns {
+		fn()
+	}
 }
 
 func (w *ResponseWriter) Flush() {
+	if !w.headerWritten {
+		w.WriteHeader(200)
+	}
+
+	if w.compressing {
+		// There is no way to report the error; it will be reported by the
+		// next call to Write.
+		w.compression.flush()
+	}
+
 	f := w.w.(http.Flusher)
 	f.Flush()
 }
+
+// finish completes the response, sending data which may have been held back
+// for compression.
+func (w *ResponseWriter) finish() error {
+	if !w.compressing || w.hijacked {
+		return nil
+	}
+
+	w.compressing = false
+
+	return w.compression.close()
+}
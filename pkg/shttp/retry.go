@@ -0,0 +1,162 @@
+package shttp
+
+import (
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.n16f.net/ejson"
+)
+
+const (
+	DefaultRetryMaxAttempts  = 3
+	DefaultRetryInitialDelay = 100    // milliseconds
+	DefaultRetryMaxDelay     = 10_000 // milliseconds
+)
+
+var DefaultRetryStatuses = []int{429, 502, 503, 504}
+
+// Only idempotent methods are retried by default since we cannot know if the
+// server processed a request before a failure.
+var DefaultRetryMethods = []string{
+	"GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE",
+}
+
+// RetryCfg controls how failed requests are retried. Requests are retried
+// after transport errors or if the response status is one of the retryable
+// status codes. The delay between two attempts grows exponentially with
+// random jitter; a Retry-After response header field takes precedence.
+//
+// Requests with a body are only retried if the body can be rewound, i.e. if
+// the request has a GetBody function. Note that the client request timeout
+// applies to all attempts.
+type RetryCfg struct {
+	MaxAttempts  int      `json:"max_attempts"`
+	InitialDelay int      `json:"initial_delay"` // milliseconds
+	MaxDelay     int      `json:"max_delay"`     // milliseconds
+	Statuses     []int    `json:"statuses"`
+	Methods      []string `json:"methods"`
+}
+
+func (cfg *RetryCfg) ValidateJSON(v *ejson.Validator) {
+	v.CheckIntMin("max_attempts", cfg.MaxAttempts, 0)
+	v.CheckIntMin("initial_delay", cfg.InitialDelay, 0)
+	v.CheckIntMin("max_delay", cfg.MaxDelay, 0)
+
+	v.WithChild("statuses", func() {
+		for i, status := range cfg.Statuses {
+			v.CheckIntMinMax(i, status, 100, 599)
+		}
+	})
+
+	v.WithChild("methods", func() {
+		for i, method := range cfg.Methods {
+			v.CheckStringNotEmpty(i, method)
+		}
+	})
+}
+
+func (cfg *RetryCfg) setDefaults() {
+	if cfg.MaxAttempts == 0 {
+		cfg.MaxAttempts = DefaultRetryMaxAttempts
+	}
+
+	if cfg.InitialDelay == 0 {
+		cfg.InitialDelay = DefaultRetryInitialDelay
+	}
+
+	if cfg.MaxDelay == 0 {
+		cfg.MaxDelay = DefaultRetryMaxDelay
+	}
+
+	if cfg.Statuses == nil {
+		cfg.Statuses = DefaultRetryStatuses
+	}
+
+	if cfg.Methods == nil {
+		cfg.Methods = DefaultRetryMethods
+	}
+}
+
+func (cfg *RetryCfg) retryableRequest(req *http.Request) bool {
+	if cfg.MaxAttempts <= 1 {
+		return false
+	}
+
+	if !slices.Contains(cfg.Methods, req.Method) {
+		return false
+	}
+
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// retryDelay returns the delay before the next attempt and whether the
+// request should be retried at all.
+func (cfg *RetryCfg) retryDelay(attempt int, res *http.Response, err error) (time.Duration, bool) {
+	maxDelay := time.Duration(cfg.MaxDelay) * time.Millisecond
+
+	// Retrying immediately would only fail again
+	if errors.Is(err, ErrCircuitOpen) {
+		return 0, false
+	}
+
+	if err == nil {
+		if !slices.Contains(cfg.Statuses, res.StatusCode) {
+			return 0, false
+		}
+
+		if delay, ok := parseRetryAfter(res.Header.Get("Retry-After")); ok {
+			// If the server asks us to wait longer than we are willing to,
+			// there is no point in retrying.
+			if delay > maxDelay {
+				return 0, false
+			}
+
+			return delay, true
+		}
+	}
+
+	delay := time.Duration(cfg.InitialDelay) * time.Millisecond
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+
+	delay = min(delay, maxDelay)
+
+	// Equal jitter: half of the delay is fixed, the other half is random
+	return delay/2 + rand.N(delay/2+1), true
+}
+
+func parseRetryAfter(s string) (time.Duration, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(s); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	t, err := http.ParseTime(s)
+	if err != nil {
+		return 0, false
+	}
+
+	return max(time.Until(t), 0), true
+}
+
+// discardResponse releases a response we are not going to return so that
+// the connection can be reused.
+func discardResponse(res *http.Response) {
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+	res.Body.Close()
+}
@@ -0,0 +1,158 @@
+package shttp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.n16f.net/log"
+)
+
+func newRetryTestClient(t *testing.T, retryCfg RetryCfg) *Client {
+	t.Helper()
+
+	client, err := NewClient(ClientCfg{
+		Log:   log.DefaultLogger("test"),
+		Retry: &retryCfg,
+	})
+	require.NoError(t, err)
+
+	return client
+}
+
+func TestClientRetry(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var nbRequests, nbFailures int
+	var bodies []string
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			nbRequests++
+
+			body, _ := io.ReadAll(req.Body)
+			bodies = append(bodies, string(body))
+
+			if nbRequests <= nbFailures {
+				w.WriteHeader(503)
+				return
+			}
+
+			w.WriteHeader(200)
+		}))
+	defer server.Close()
+
+	client := newRetryTestClient(t, RetryCfg{InitialDelay: 1, MaxDelay: 10})
+
+	// Successful after two retries, with the body sent each time
+	nbFailures = 2
+
+	req, err := http.NewRequest("PUT", server.URL, strings.NewReader("foo"))
+	require.NoError(err)
+
+	res, err := client.Do(req)
+	require.NoError(err)
+	res.Body.Close()
+
+	assert.Equal(200, res.StatusCode)
+	assert.Equal(3, nbRequests)
+	assert.Equal([]string{"foo", "foo", "foo"}, bodies)
+
+	// Non-idempotent methods are not retried
+	nbRequests = 0
+
+	req, err = http.NewRequest("POST", server.URL, strings.NewReader("foo"))
+	require.NoError(err)
+
+	res, err = client.Do(req)
+	require.NoError(err)
+	res.Body.Close()
+
+	assert.Equal(503, res.StatusCode)
+	assert.Equal(1, nbRequests)
+
+	// The last response is returned when all attempts fail
+	nbRequests = 0
+	nbFailures = 10
+
+	req, err = http.NewRequest("GET", server.URL, nil)
+	require.NoError(err)
+
+	res, err = client.Do(req)
+	require.NoError(err)
+	res.Body.Close()
+
+	assert.Equal(503, res.StatusCode)
+	assert.Equal(3, nbRequests)
+}
+
+func TestClientRetryAfter(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var nbRequests int
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			nbRequests++
+
+			if nbRequests == 1 {
+				w.Header().Set("Retry-After", req.URL.Query().Get("delay"))
+				w.WriteHeader(429)
+				return
+			}
+
+			w.WriteHeader(200)
+		}))
+	defer server.Close()
+
+	client := newRetryTestClient(t, RetryCfg{InitialDelay: 1, MaxDelay: 2000})
+
+	// Retry-After delay within the maximum delay
+	start := time.Now()
+
+	res, err := client.Client.Get(server.URL + "?delay=1")
+	require.NoError(err)
+	res.Body.Close()
+
+	assert.Equal(200, res.StatusCode)
+	assert.Equal(2, nbRequests)
+	assert.GreaterOrEqual(time.Since(start), time.Second)
+
+	// Retry-After delay above the maximum delay
+	nbRequests = 0
+
+	res, err = client.Client.Get(server.URL + "?delay=60")
+	require.NoError(err)
+	res.Body.Close()
+
+	assert.Equal(429, res.StatusCode)
+	assert.Equal(1, nbRequests)
+}
+
+func TestRetryDelay(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := RetryCfg{InitialDelay: 100, MaxDelay: 1000}
+	cfg.setDefaults()
+
+	for attempt, maxDelay := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		maxDelay *= time.Millisecond
+
+		delay, retry := cfg.retryDelay(attempt+1, nil, io.ErrUnexpectedEOF)
+		if assert.True(retry) {
+			assert.GreaterOrEqual(delay, maxDelay/2)
+			assert.LessOrEqual(delay, maxDelay)
+		}
+	}
+
+	res := http.Response{StatusCode: 404}
+	_, retry := cfg.retryDelay(1, &res, nil)
+	assert.False(retry)
+}
@@ -1,11 +1,20 @@
 package shttp
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"strconv"
+	"syscall"
 	"time"
 
 	"go.n16f.net/log"
+	"go.n16f.net/service/pkg/influx"
+	"go.n16f.net/service/pkg/tracing"
 	"go.n16f.net/service/pkg/utils"
 )
 
@@ -14,28 +23,136 @@ type RoundTripper struct {
 	Log *log.Logger
 
 	http.RoundTripper
+
+	circuitBreaker *circuitBreaker
 }
 
 func NewRoundTripper(rt http.RoundTripper, cfg *ClientCfg) *RoundTripper {
+	if cfg.Retry != nil {
+		cfg.Retry.setDefaults()
+	}
+
+	var breaker *circuitBreaker
+	if cfg.CircuitBreaker != nil {
+		cfg.CircuitBreaker.setDefaults()
+		breaker = newCircuitBreaker(cfg)
+	}
+
 	return &RoundTripper{
 		Cfg: cfg,
 		Log: cfg.Log,
 
 		RoundTripper: rt,
+
+		circuitBreaker: breaker,
 	}
 }
 
 func (rt *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.finalizeReq(req)
+
+	retryCfg := rt.Cfg.Retry
+	if retryCfg == nil || !retryCfg.retryableRequest(req) {
+		return rt.sendRequest(req, 1)
+	}
+
+	ctx := req.Context()
+	attemptReq := req
+
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			attemptReq = req.Clone(ctx)
+
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, fmt.Errorf("cannot rewind request body: %w",
+						err)
+				}
+
+				attemptReq.Body = body
+			}
+		}
+
+		res, err := rt.sendRequest(attemptReq, attempt)
+		if attempt >= retryCfg.MaxAttempts {
+			return res, err
+		}
+
+		delay, retry := retryCfg.retryDelay(attempt, res, err)
+		if !retry || ctx.Err() != nil {
+			return res, err
+		}
+
+		if res != nil {
+			discardResponse(res)
+		}
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (rt *RoundTripper) sendRequest(req *http.Request, attempt int) (*http.Response, error) {
+	if rt.circuitBreaker != nil {
+		if err := rt.circuitBreaker.allow(req.URL.Host); err != nil {
+			// State changes are already logged, there is no point in
+			// logging each rejected request.
+			rt.enqueueRequestPoint(req, nil, err, 0)
+			return nil, err
+		}
+	}
+
 	start := time.Now()
 
-	rt.finalizeReq(req)
+	ctx, span := rt.Cfg.Tracer.StartSpan(req.Context(), req.Method,
+		tracing.SpanKindClient)
+	defer span.End()
+
+	span.SetAttribute("http.request.method", req.Method)
+	span.SetAttribute("server.address", req.URL.Hostname())
+	span.SetAttribute("url.full", req.URL.Redacted())
+
+	if attempt > 1 {
+		span.SetAttribute("http.request.resend_count", attempt-1)
+	}
+
+	if sc, ok := tracing.SpanContextFromContext(ctx); ok {
+		req.Header.Set("traceparent", sc.Traceparent())
+		if sc.TraceState != "" {
+			req.Header.Set("tracestate", sc.TraceState)
+		}
+	}
 
 	res, err := rt.RoundTripper.RoundTrip(req)
 
-	if err == nil && rt.Cfg.LogRequests {
-		rt.logRequest(req, res, time.Since(start).Seconds())
+	if rt.circuitBreaker != nil {
+		rt.circuitBreaker.done(req.URL.Host, res, err)
 	}
 
+	if err != nil {
+		span.SetError(err)
+	} else {
+		span.SetAttribute("http.response.status_code", res.StatusCode)
+		if res.StatusCode >= 500 {
+			span.SetStatus(tracing.SpanStatusError, "")
+		}
+	}
+
+	reqTime := time.Since(start)
+
+	if rt.Cfg.LogRequests {
+		rt.logRequest(req, res, err, attempt, reqTime.Seconds())
+	}
+
+	rt.enqueueRequestPoint(req, res, err, reqTime)
+
 	return res, err
 }
 
@@ -45,9 +162,15 @@ func (rt *RoundTripper) finalizeReq(req *http.Request) {
 			req.Header.Add(name, value)
 		}
 	}
+
+	if req.Header.Get("X-Request-Id") == "" {
+		if id := utils.RequestIdFromContext(req.Context()); id != "" {
+			req.Header.Set("X-Request-Id", id)
+		}
+	}
 }
 
-func (rt *RoundTripper) logRequest(req *http.Request, res *http.Response, seconds float64) {
+func (rt *RoundTripper) logRequest(req *http.Request, res *http.Response, err error, attempt int, seconds float64) {
 	var statusString string
 	if res == nil {
 		statusString = "-"
@@ -55,6 +178,90 @@ func (rt *RoundTripper) logRequest(req *http.Request, res *http.Response, second
 		statusString = strconv.Itoa(res.StatusCode)
 	}
 
-	rt.Log.Info("%s %s %s %s", req.Method, req.URL.String(), statusString,
-		utils.FormatSeconds(seconds, 1))
+	data := log.Data{"attempt": attempt}
+
+	var attemptString string
+	if attempt > 1 {
+		attemptString = " (attempt " + strconv.Itoa(attempt) + ")"
+	}
+
+	if err != nil {
+		data["error"] = requestErrorKind(err)
+
+		rt.Log.ErrorData(data, "%s %s %s %s%s: %v", req.Method,
+			req.URL.String(), statusString, utils.FormatSeconds(seconds, 1),
+			attemptString, err)
+		return
+	}
+
+	rt.Log.InfoData(data, "%s %s %s %s%s", req.Method, req.URL.String(),
+		statusString, utils.FormatSeconds(seconds, 1), attemptString)
+}
+
+func (rt *RoundTripper) enqueueRequestPoint(req *http.Request, res *http.Response, err error, reqTime time.Duration) {
+	if rt.Cfg.InfluxClient == nil {
+		return
+	}
+
+	tags := influx.Tags{
+		"host":   req.URL.Host,
+		"method": req.Method,
+	}
+
+	if rt.Cfg.Name != "" {
+		tags["client"] = rt.Cfg.Name
+	}
+
+	fields := influx.Fields{
+		"req_time": reqTime.Microseconds(),
+	}
+
+	if err != nil {
+		tags["error"] = requestErrorKind(err)
+	} else {
+		if status := statusClass(res.StatusCode); status != "" {
+			tags["status"] = status
+		}
+
+		fields["status_code"] = res.StatusCode
+	}
+
+	point := influx.NewPoint("outgoing_http_requests", tags, fields)
+	rt.Cfg.InfluxClient.EnqueuePoint(point)
+}
+
+// requestErrorKind classifies transport errors so that they can be used as
+// tags in metrics and logs.
+func requestErrorKind(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var certErr *tls.CertificateVerificationError
+	var recordHeaderErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var opErr *net.OpError
+
+	switch {
+	case errors.Is(err, ErrCircuitOpen):
+		return "circuit_open"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection_refused"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return "connection_reset"
+	case errors.As(err, &certErr), errors.As(err, &recordHeaderErr),
+		errors.As(err, &alertErr):
+		return "tls"
+	case errors.As(err, &opErr):
+		return "connection"
+	default:
+		return "other"
+	}
 }
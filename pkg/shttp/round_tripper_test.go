@@ -0,0 +1,126 @@
+package shttp
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.n16f.net/log"
+	"go.n16f.net/service/pkg/influx"
+)
+
+func TestRequestErrorKind(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			time.Sleep(100 * time.Millisecond)
+		}))
+	defer server.Close()
+
+	// Find an address nobody listens on
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	closedAddress := listener.Addr().String()
+	listener.Close()
+
+	// Request points are sent to a fake InfluxDB server
+	influxData := make(chan string, 10)
+
+	influxServer := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			data, _ := io.ReadAll(req.Body)
+			influxData <- string(data)
+			w.WriteHeader(204)
+		}))
+	defer influxServer.Close()
+
+	influxClient, err := influx.NewClient(influx.ClientCfg{
+		HTTPClient: influxServer.Client(),
+		URI:        influxServer.URL,
+		Bucket:     "test",
+		BatchSize:  1,
+	})
+	require.NoError(err)
+
+	client, err := NewClient(ClientCfg{
+		Log:          log.DefaultLogger("test"),
+		LogRequests:  true,
+		InfluxClient: influxClient,
+	})
+	require.NoError(err)
+
+	// Name resolution failures are simulated so that the test does not
+	// depend on the DNS configuration of the system.
+	transport := client.Client.Transport.(*RoundTripper).RoundTripper.(*http.Transport)
+	transport.Proxy = nil
+
+	dial := transport.DialContext
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if host, _, _ := net.SplitHostPort(address); host == "unknown.test" {
+			return nil, &net.DNSError{
+				Err:        "no such host",
+				Name:       host,
+				IsNotFound: true,
+			}
+		}
+
+		return dial(ctx, network, address)
+	}
+
+	sendRequest := func(ctx context.Context, uri string) error {
+		req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+		require.NoError(err)
+
+		res, err := client.Client.Transport.RoundTrip(req)
+		if err == nil {
+			res.Body.Close()
+		}
+
+		return err
+	}
+
+	assertPoint := func(errorKind string) {
+		t.Helper()
+
+		select {
+		case data := <-influxData:
+			assert.True(strings.HasPrefix(data, "outgoing_http_requests,"),
+				"invalid point %q", data)
+			assert.Contains(data, "error="+errorKind)
+		case <-time.After(5 * time.Second):
+			assert.Fail("missing point")
+		}
+	}
+
+	err = sendRequest(context.Background(), "http://"+closedAddress)
+	assert.Equal("connection_refused", requestErrorKind(err))
+	assertPoint("connection_refused")
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+
+	err = sendRequest(ctx, server.URL)
+	assert.Equal("timeout", requestErrorKind(err))
+	assertPoint("timeout")
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+
+	err = sendRequest(ctx, server.URL)
+	assert.Equal("canceled", requestErrorKind(err))
+	assertPoint("canceled")
+
+	err = sendRequest(context.Background(), "http://unknown.test")
+	assert.Equal("dns", requestErrorKind(err))
+	assertPoint("dns")
+}
@@ -5,8 +5,10 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"html/template"
 	"net"
 	"net/http"
+	"net/netip"
 	"strings"
 	"sync"
 	"time"
@@ -15,6 +17,9 @@ import (
 	"go.n16f.net/log"
 	"go.n16f.net/program"
 	"go.n16f.net/service/pkg/influx"
+	"go.n16f.net/service/pkg/tracing"
+	"go.n16f.net/service/pkg/utils"
+	"go.n16f.net/uuid"
 )
 
 type contextKey struct{}
@@ -26,25 +31,77 @@ var (
 type RouteFunc func(*Handler)
 
 type RouteOptions struct {
-	MethodlessRouteIds bool
-	DisableAccessLog   bool
+	MethodlessRouteIds    bool
+	DisableAccessLog      bool
+	DisableCSRFProtection bool
+	RateLimiter           *RateLimiter
+
+	// The maximum size of the request body in bytes, overriding the server
+	// setting if not null. Negative values disable the limit.
+	MaxRequestBodySize int64
+
+	// The write timeout of the route, overriding the server setting if not
+	// null. Negative values disable the timeout, which is useful for long
+	// lived responses such as SSE streams.
+	WriteTimeout time.Duration
 }
 
 type ErrorData interface{}
 type ErrorHandler func(*Handler, int, string, string, ErrorData)
 
 type ServerCfg struct {
-	Log          *log.Logger    `json:"-"`
-	ErrorChan    chan<- error   `json:"-"`
-	InfluxClient *influx.Client `json:"-"`
-	Name         string         `json:"-"`
-	ErrorHandler ErrorHandler   `json:"-"`
+	Log          *log.Logger     `json:"-"`
+	ErrorChan    chan<- error    `json:"-"`
+	InfluxClient *influx.Client  `json:"-"`
+	Tracer       *tracing.Tracer `json:"-"`
+	Name         string          `json:"-"`
+	ErrorHandler ErrorHandler    `json:"-"`
+
+	// Templates used by Handler.ReplyHTMLTemplate and
+	// Handler.ReplyNegotiatedWithTemplate. The template set is cloned for each
+	// request to bind request-specific functions, so it must never be
+	// executed directly. Servers created by a service use the HTML templates
+	// of the service by default.
+	HTMLTemplates *template.Template `json:"-"`
 
 	SocketType ServerSocketType `json:"socket_type"`
 	Address    string           `json:"address"`
 
 	TLS *TLSServerCfg `json:"tls"`
 
+	// HTTP/2 is enabled by default for TLS connections. H2C enables HTTP/2
+	// on cleartext connections, which is only useful for internal traffic,
+	// for example behind a reverse proxy.
+	DisableHTTP2 bool `json:"disable_http2"`
+	H2C          bool `json:"h2c"`
+
+	ReadHeaderTimeout *int `json:"read_header_timeout"` // seconds
+	ReadTimeout       int  `json:"read_timeout"`        // seconds
+	WriteTimeout      int  `json:"write_timeout"`       // seconds
+	IdleTimeout       *int `json:"idle_timeout"`        // seconds
+	MaxHeaderBytes    int  `json:"max_header_bytes"`
+
+	// Forwarding header fields (Forwarded, X-Forwarded-For, X-Real-IP) are
+	// only used to obtain the client address of requests sent by trusted
+	// proxies. Entries are either IP addresses or CIDR blocks.
+	TrustedProxies []string `json:"trusted_proxies"`
+
+	Sessions *SessionCfg `json:"sessions"`
+	CSRF     *CSRFCfg    `json:"csrf"`
+	CORS     *CORSCfg    `json:"cors"`
+
+	Compression *CompressionCfg `json:"compression"`
+
+	// The default configuration of WebSocket connections, which can be
+	// overridden with Handler.UpgradeWebSocketWithCfg.
+	WebSocket *WebSocketCfg `json:"websocket"`
+
+	// The maximum size of request bodies in bytes, before and after
+	// decompression. The default value is DefaultMaxRequestBodySize (10MB);
+	// negative values disable the limit. Routes can set their own limit, or
+	// disable it, with RouteOptions.MaxRequestBodySize.
+	MaxRequestBodySize int64 `json:"max_request_body_size"`
+
 	LogSuccessfulRequests bool `json:"log_successful_requests"`
 	HideInternalErrors    bool `json:"hide_internal_errors"`
 	ShutdownTimeout       int  `json:"shutdown_timeout"` // seconds
@@ -56,8 +113,48 @@ func (cfg *ServerCfg) ValidateJSON(v *ejson.Validator) {
 	}
 
 	v.CheckOptionalObject("tls", cfg.TLS)
+
+	if cfg.H2C {
+		v.Check("h2c", cfg.TLS == nil, "incompatible_h2c",
+			"h2c cannot be used with TLS")
+		v.Check("h2c", !cfg.DisableHTTP2, "incompatible_h2c",
+			"h2c cannot be used with HTTP/2 disabled")
+	}
+
+	if cfg.ReadHeaderTimeout != nil {
+		v.CheckIntMin("read_header_timeout", *cfg.ReadHeaderTimeout, 0)
+	}
+	v.CheckIntMin("read_timeout", cfg.ReadTimeout, 0)
+	v.CheckIntMin("write_timeout", cfg.WriteTimeout, 0)
+	if cfg.IdleTimeout != nil {
+		v.CheckIntMin("idle_timeout", *cfg.IdleTimeout, 0)
+	}
+	v.CheckIntMin("max_header_bytes", cfg.MaxHeaderBytes, 0)
+
+	v.WithChild("trusted_proxies", func() {
+		for i, s := range cfg.TrustedProxies {
+			_, err := parseTrustedProxy(s)
+			v.Check(i, err == nil, "invalid_trusted_proxy", "%v", err)
+		}
+	})
+
+	v.CheckOptionalObject("sessions", cfg.Sessions)
+	v.CheckOptionalObject("csrf", cfg.CSRF)
+	v.CheckOptionalObject("cors", cfg.CORS)
+	v.CheckOptionalObject("compression", cfg.Compression)
+	v.CheckOptionalObject("websocket", cfg.WebSocket)
+
+	if cfg.CSRF != nil && cfg.Sessions == nil {
+		v.AddError("csrf", "missing_sessions",
+			"CSRF protection requires sessions to be enabled")
+	}
 }
 
+const (
+	DefaultServerReadHeaderTimeout = 5  // seconds
+	DefaultServerIdleTimeout       = 10 // seconds
+)
+
 type ServerSocketType string
 
 const (
@@ -73,11 +170,57 @@ var ServerSocketTypeValues = []ServerSocketType{
 type TLSServerCfg struct {
 	Certificate string `json:"certificate"`
 	PrivateKey  string `json:"private_key"`
+
+	// Certificate files are reloaded when they are modified, and when the
+	// process receives a SIGHUP signal.
+	CertificateReloadInterval int `json:"certificate_reload_interval"` // seconds
+
+	// If client CA certificates are provided, client certificates are
+	// required by default.
+	ClientCACertificates []string                `json:"client_ca_certificates"`
+	ClientAuthentication TLSClientAuthentication `json:"client_authentication"`
+
+	MinVersion string `json:"min_version"` // default: "1.3"
+
+	// Additional protocols supported for application-layer protocol
+	// negotiation. Protocols for enabled HTTP versions are always included.
+	ALPNProtocols []string `json:"alpn_protocols"`
 }
 
 func (cfg *TLSServerCfg) ValidateJSON(v *ejson.Validator) {
 	v.CheckStringNotEmpty("certificate", cfg.Certificate)
 	v.CheckStringNotEmpty("private_key", cfg.PrivateKey)
+
+	v.CheckIntMin("certificate_reload_interval",
+		cfg.CertificateReloadInterval, 0)
+
+	v.WithChild("client_ca_certificates", func() {
+		for i, filePath := range cfg.ClientCACertificates {
+			v.CheckStringNotEmpty(i, filePath)
+		}
+	})
+
+	if cfg.ClientAuthentication != "" {
+		v.CheckStringValue("client_authentication",
+			cfg.ClientAuthentication, TLSClientAuthenticationValues)
+
+		if cfg.ClientAuthentication != TLSClientAuthenticationNone {
+			v.Check("client_ca_certificates",
+				len(cfg.ClientCACertificates) > 0,
+				"missing_client_ca_certificates",
+				"client authentication requires client CA certificates")
+		}
+	}
+
+	if cfg.MinVersion != "" {
+		v.CheckStringValue("min_version", cfg.MinVersion, TLSVersionValues)
+	}
+
+	v.WithChild("alpn_protocols", func() {
+		for i, protocol := range cfg.ALPNProtocols {
+			v.CheckStringNotEmpty(i, protocol)
+		}
+	})
 }
 
 type Server struct {
@@ -89,6 +232,16 @@ type Server struct {
 
 	errorHandler ErrorHandler
 
+	trustedProxies []netip.Prefix
+
+	sessionCodec   *sessionCodec
+	csrfProtection *csrfProtection
+	corsPolicy     *corsPolicy
+
+	compressionPolicy *compressionPolicy
+
+	certificateLoader *certificateLoader
+
 	errorChan chan<- error
 	wg        sync.WaitGroup
 }
@@ -118,6 +271,18 @@ func NewServer(cfg ServerCfg) (*Server, error) {
 		cfg.ErrorHandler = DefaultErrorHandler
 	}
 
+	if cfg.ReadHeaderTimeout == nil {
+		cfg.ReadHeaderTimeout = utils.Ref(DefaultServerReadHeaderTimeout)
+	}
+
+	if cfg.IdleTimeout == nil {
+		cfg.IdleTimeout = utils.Ref(DefaultServerIdleTimeout)
+	}
+
+	if cfg.MaxRequestBodySize == 0 {
+		cfg.MaxRequestBodySize = DefaultMaxRequestBodySize
+	}
+
 	s := &Server{
 		Cfg: cfg,
 		Log: cfg.Log,
@@ -127,20 +292,83 @@ func NewServer(cfg ServerCfg) (*Server, error) {
 		errorChan: cfg.ErrorChan,
 	}
 
-	s.server = &http.Server{
-		Addr:     cfg.Address,
-		Handler:  s,
-		ErrorLog: s.Log.StdLogger(log.LevelError),
+	trustedProxies, err := parseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+	s.trustedProxies = trustedProxies
+
+	if cfg.Sessions != nil {
+		codec, err := newSessionCodec(cfg.Sessions)
+		if err != nil {
+			return nil, fmt.Errorf("invalid session configuration: %w", err)
+		}
+
+		s.sessionCodec = codec
+	}
+
+	if cfg.CSRF != nil {
+		if s.sessionCodec == nil {
+			return nil, fmt.Errorf("CSRF protection requires sessions to " +
+				"be enabled")
+		}
+
+		protection, err := newCSRFProtection(cfg.CSRF)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSRF configuration: %w", err)
+		}
+
+		s.csrfProtection = protection
+	}
+
+	if cfg.CORS != nil {
+		policy, err := newCORSPolicy(cfg.CORS)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CORS configuration: %w", err)
+		}
 
-		ReadHeaderTimeout: 5 * time.Second,
-		IdleTimeout:       10 * time.Second,
+		s.corsPolicy = policy
+	}
+
+	if cfg.Compression != nil {
+		policy, err := newCompressionPolicy(cfg.Compression)
+		if err != nil {
+			return nil, fmt.Errorf("invalid compression configuration: %w",
+				err)
+		}
+
+		s.compressionPolicy = policy
+	}
+
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	if cfg.TLS != nil && !cfg.DisableHTTP2 {
+		protocols.SetHTTP2(true)
+	}
+	if cfg.H2C {
+		protocols.SetUnencryptedHTTP2(true)
+	}
+
+	s.server = &http.Server{
+		Addr:      cfg.Address,
+		Handler:   s,
+		ErrorLog:  s.Log.StdLogger(log.LevelError),
+		Protocols: protocols,
+
+		ReadHeaderTimeout: time.Duration(*cfg.ReadHeaderTimeout) * time.Second,
+		ReadTimeout:       time.Duration(cfg.ReadTimeout) * time.Second,
+		WriteTimeout:      time.Duration(cfg.WriteTimeout) * time.Second,
+		IdleTimeout:       time.Duration(*cfg.IdleTimeout) * time.Second,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
 	}
 
 	if cfg.TLS != nil {
-		s.server.TLSConfig = &tls.Config{
-			MinVersion:               tls.VersionTLS13,
-			PreferServerCipherSuites: true,
+		tlsCfg, err := s.tlsConfig(cfg.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS configuration: %w", err)
 		}
+
+		s.server.TLSConfig = tlsCfg
 	}
 
 	s.mux = http.NewServeMux()
@@ -149,6 +377,55 @@ func NewServer(cfg ServerCfg) (*Server, error) {
 	return s, nil
 }
 
+func (s *Server) tlsConfig(cfg *TLSServerCfg) (*tls.Config, error) {
+	tlsCfg := tls.Config{
+		MinVersion: tls.VersionTLS13,
+		NextProtos: cfg.ALPNProtocols,
+	}
+
+	if cfg.MinVersion != "" {
+		version, err := parseTLSVersion(cfg.MinVersion)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsCfg.MinVersion = version
+	}
+
+	reloadInterval := DefaultCertificateReloadInterval
+	if cfg.CertificateReloadInterval > 0 {
+		reloadInterval = cfg.CertificateReloadInterval
+	}
+
+	loader, err := newCertificateLoader(cfg.Certificate, cfg.PrivateKey,
+		time.Duration(reloadInterval)*time.Second, s.Log)
+	if err != nil {
+		return nil, err
+	}
+
+	s.certificateLoader = loader
+	tlsCfg.GetCertificate = loader.getCertificate
+
+	if len(cfg.ClientCACertificates) > 0 {
+		pool, err := LoadCertificates(cfg.ClientCACertificates)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client CA certificates: %w",
+				err)
+		}
+
+		tlsCfg.ClientCAs = pool
+
+		clientAuthentication := cfg.ClientAuthentication
+		if clientAuthentication == "" {
+			clientAuthentication = TLSClientAuthenticationRequired
+		}
+
+		tlsCfg.ClientAuth = clientAuthentication.clientAuthType()
+	}
+
+	return &tlsCfg, nil
+}
+
 func (s *Server) Start() error {
 	var network string
 
@@ -168,6 +445,10 @@ func (s *Server) Start() error {
 
 	s.Log.Info("listening on %s", s.Cfg.Address)
 
+	if s.certificateLoader != nil {
+		s.certificateLoader.start()
+	}
+
 	s.wg.Add(1)
 	go func() {
 		defer s.wg.Done()
@@ -177,10 +458,8 @@ func (s *Server) Start() error {
 		if s.Cfg.TLS == nil {
 			err = s.server.Serve(listener)
 		} else {
-			certificate := s.Cfg.TLS.Certificate
-			privateKey := s.Cfg.TLS.PrivateKey
-
-			err = s.server.ServeTLS(listener, certificate, privateKey)
+			// The certificate is provided by the certificate loader
+			err = s.server.ServeTLS(listener, "", "")
 		}
 
 		if err != nil {
@@ -198,6 +477,10 @@ func (s *Server) Start() error {
 func (s *Server) Stop() {
 	s.shutdown()
 	s.wg.Wait()
+
+	if s.certificateLoader != nil {
+		s.certificateLoader.stop()
+	}
 }
 
 func (s *Server) shutdown() {
@@ -218,23 +501,52 @@ func (s *Server) shutdown() {
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	rw := NewResponseWriter(w)
+	if s.compressionPolicy != nil {
+		rw.compression = newResponseCompression(s.compressionPolicy, w, req)
+	}
+
 	h := Handler{
 		Server: s,
 		Log:    s.Log.Child("", log.Data{}),
 
-		ResponseWriter: NewResponseWriter(w),
+		ResponseWriter: rw,
 	}
 
+	h.RequestId = requestId(req)
+	w.Header().Set("X-Request-Id", h.RequestId)
+
 	ctx := req.Context()
 	ctx = context.WithValue(ctx, contextKeyHandler, &h)
+	ctx = utils.ContextWithRequestId(ctx, h.RequestId)
+
+	if value := req.Header.Get("traceparent"); value != "" {
+		if sc, err := tracing.ParseTraceparent(value); err == nil {
+			sc.TraceState = req.Header.Get("tracestate")
+			ctx = tracing.ContextWithRemoteSpanContext(ctx, sc)
+		}
+	}
+
+	ctx, h.span = s.Cfg.Tracer.StartSpan(ctx, req.Method,
+		tracing.SpanKindServer)
+
+	if sc, ok := tracing.SpanContextFromContext(ctx); ok {
+		h.Log.Data["trace_id"] = sc.TraceId.String()
+	}
+
 	h.Request = req.WithContext(ctx)
 
 	h.start = time.Now()
 
+	defer h.endSpan()
 	defer h.sendInfluxPoints()
 	defer h.logRequest()
 
 	s.mux.ServeHTTP(h.ResponseWriter, h.Request)
+
+	if err := rw.finish(); err != nil {
+		h.Log.Error("cannot finish response: %v", err)
+	}
 }
 
 func (s *Server) Route(pathPattern, method string, routeFunc RouteFunc) {
@@ -242,7 +554,11 @@ func (s *Server) Route(pathPattern, method string, routeFunc RouteFunc) {
 }
 
 func (s *Server) RouteWithOptions(pathPattern, method string, routeFunc RouteFunc, options RouteOptions) {
-	handlerFunc := func(w http.ResponseWriter, req *http.Request) {
+	s.route(pathPattern, method, routeFunc, options, false)
+}
+
+func (s *Server) route(pathPattern, method string, routeFunc RouteFunc, options RouteOptions, corsOptionsRoute bool) {
+	var handlerFunc http.HandlerFunc = func(w http.ResponseWriter, req *http.Request) {
 		h := requestHandler(req)
 		h.Options = options
 
@@ -257,9 +573,48 @@ func (s *Server) RouteWithOptions(pathPattern, method string, routeFunc RouteFun
 			}
 		}()
 
+		if options.WriteTimeout != 0 {
+			h.setWriteTimeout(options.WriteTimeout)
+		}
+
+		if !h.limitRequestBody() {
+			return
+		}
+
+		if s.corsPolicy != nil && s.corsPolicy.handleRequest(h) {
+			return
+		}
+
+		if limiter := options.RateLimiter; limiter != nil && !limiter.check(h) {
+			return
+		}
+
+		if s.csrfProtection != nil && !options.DisableCSRFProtection {
+			if err := s.csrfProtection.check(h); err != nil {
+				h.ReplyError(403, "csrf_failure",
+					"CSRF validation failed: %v", err)
+				return
+			}
+		}
+
 		routeFunc(h)
 	}
 
+	// OPTIONS routes registered automatically for CORS can be replaced by an
+	// OPTIONS route registered by the application for the same path pattern.
+	// Since the muxer does not support replacing routes, OPTIONS requests are
+	// dispatched through the CORS policy.
+	if s.corsPolicy != nil && method == "OPTIONS" {
+		if !s.corsPolicy.setOptionsRoute(pathPattern, handlerFunc,
+			corsOptionsRoute) {
+			return
+		}
+
+		handlerFunc = func(w http.ResponseWriter, req *http.Request) {
+			s.corsPolicy.optionsRoute(pathPattern)(w, req)
+		}
+	}
+
 	pattern := pathPattern
 
 	if method != "" {
@@ -278,6 +633,21 @@ func (s *Server) RouteWithOptions(pathPattern, method string, routeFunc RouteFun
 	if !hasSuffix("/") && !hasSuffix("{$}") && !hasSuffix("...}") {
 		s.mux.HandleFunc(pattern+"/{$}", handlerFunc)
 	}
+
+	// CORS preflight requests use the OPTIONS method, so we need an OPTIONS
+	// route for each path pattern.
+	if s.corsPolicy != nil && method != "" {
+		if s.corsPolicy.addRoute(pathPattern, method) && method != "OPTIONS" {
+			optionsRouteOptions := RouteOptions{
+				MethodlessRouteIds:    options.MethodlessRouteIds,
+				DisableAccessLog:      options.DisableAccessLog,
+				DisableCSRFProtection: true,
+			}
+
+			s.route(pathPattern, "OPTIONS", s.hCORSOptions,
+				optionsRouteOptions, true)
+		}
+	}
 }
 
 func (s *Server) finalizeHandler(h *Handler, req *http.Request, pathPattern, method string, routeFunc RouteFunc, options *RouteOptions) {
@@ -288,20 +658,32 @@ func (s *Server) finalizeHandler(h *Handler, req *http.Request, pathPattern, met
 	h.PathPattern = pathPattern
 	h.RouteId = s.RouteId(method, pathPattern, options)
 
-	h.ClientAddress = requestClientAddress(req)
-	h.RequestId = requestId(req)
+	h.ClientAddress = s.requestClientAddress(req)
+
+	if req.TLS != nil && len(req.TLS.VerifiedChains) > 0 {
+		h.PeerCertificate = req.TLS.VerifiedChains[0][0]
+	}
 
 	if h.RouteId != "" {
 		h.Log.Data["route"] = h.RouteId
 	}
 
+	if pathPattern != "" {
+		h.span.SetName(req.Method + " " + pathPattern)
+		h.span.SetAttribute("http.route", pathPattern)
+	}
+
+	h.span.SetAttribute("http.request.method", req.Method)
+	h.span.SetAttribute("url.path", req.URL.Path)
 	if h.ClientAddress != "" {
-		h.Log.Data["address"] = h.ClientAddress
+		h.span.SetAttribute("client.address", h.ClientAddress)
 	}
 
-	if h.RequestId != "" {
-		h.Log.Data["request_id"] = h.RequestId
+	if h.ClientAddress != "" {
+		h.Log.Data["address"] = h.ClientAddress
 	}
+
+	h.Log.Data["request_id"] = h.RequestId
 }
 
 func (s *Server) RouteId(method, pathPattern string, options *RouteOptions) string {
@@ -341,16 +723,30 @@ func JSONErrorHandler(h *Handler, status int, code string, msg string, data Erro
 	h.ReplyJSON(status, &responseData)
 }
 
+// AdaptativeErrorHandler sends errors as JSON, YAML or text depending on the
+// Accept header field of the request. Text is also used for clients
+// preferring HTML, i.e. web browsers. JSON is used if no representation is
+// acceptable since we have to send the error anyway.
 func AdaptativeErrorHandler(h *Handler, status int, code string, msg string, data ErrorData) {
-	var handler ErrorHandler
+	mediaType := h.NegotiateMediaType(MediaTypeJSON, MediaTypeYAML,
+		MediaTypeText, MediaTypeHTML)
+
+	switch mediaType {
+	case MediaTypeYAML:
+		responseData := JSONError{
+			Code:    code,
+			Message: msg,
+			Data:    data,
+		}
 
-	if RequestAcceptsText(h.Request) {
-		handler = DefaultErrorHandler
-	} else {
-		handler = JSONErrorHandler
-	}
+		h.ReplyYAML(status, &responseData)
 
-	handler(h, status, code, msg, data)
+	case MediaTypeText, MediaTypeHTML:
+		DefaultErrorHandler(h, status, code, msg, data)
+
+	default:
+		JSONErrorHandler(h, status, code, msg, data)
+	}
 }
 
 func (s *Server) hNotFound(w http.ResponseWriter, req *http.Request) {
@@ -369,28 +765,29 @@ func requestHandler(req *http.Request) *Handler {
 	return value.(*Handler)
 }
 
-func requestClientAddress(req *http.Request) string {
-	if v := req.Header.Get("X-Real-IP"); v != "" {
-		return v
-	} else if v := req.Header.Get("X-Forwarded-For"); v != "" {
-		i := strings.Index(v, ", ")
-		if i == -1 {
-			return v
-		}
+// requestId returns the request id provided by the client or a new UUID v7
+// if there is none. We only accept ids which can safely be logged and sent
+// back in a header field.
+func requestId(req *http.Request) string {
+	if id := req.Header.Get("X-Request-Id"); validRequestId(id) {
+		return id
+	}
 
-		return v[:i]
-	} else {
-		host, _, err := net.SplitHostPort(req.RemoteAddr)
-		if err != nil {
-			return ""
-		}
+	return uuid.MustGenerate(uuid.V7).String()
+}
+
+func validRequestId(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
 
-		return host
+	for _, c := range []byte(id) {
+		if c <= 0x20 || c >= 0x7f {
+			return false
+		}
 	}
-}
 
-func requestId(req *http.Request) string {
-	return req.Header.Get("X-Request-Id")
+	return true
 }
 
 func RequestAcceptsText(req *http.Request) bool {
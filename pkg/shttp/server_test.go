@@ -0,0 +1,226 @@
+package shttp
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.n16f.net/log"
+	"go.n16f.net/service/pkg/tracing"
+	"go.n16f.net/service/pkg/utils"
+	"go.n16f.net/uuid"
+)
+
+func newTestServer(t *testing.T, cfg ServerCfg) *Server {
+	t.Helper()
+
+	cfg.ErrorChan = make(chan error, 1)
+	cfg.Name = "test"
+
+	s, err := NewServer(cfg)
+	require.NoError(t, err)
+
+	return s
+}
+
+func sendTestRequest(s *Server, req *http.Request) *http.Response {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w.Result()
+}
+
+func TestServerRouteId(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newTestServer(t, ServerCfg{})
+
+	assert.Equal("/foo GET", s.RouteId("GET", "/foo", &RouteOptions{}))
+	assert.Equal("/foo GET", s.RouteId("GET", "/foo/{$}", &RouteOptions{}))
+	assert.Equal("/ GET", s.RouteId("GET", "/{$}", &RouteOptions{}))
+	assert.Equal("", s.RouteId("", "/foo", &RouteOptions{}))
+	assert.Equal("/foo", s.RouteId("GET", "/foo",
+		&RouteOptions{MethodlessRouteIds: true}))
+}
+
+func TestServerRequestId(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newTestServer(t, ServerCfg{})
+
+	var contextRequestId string
+	s.Route("/foo", "GET", func(h *Handler) {
+		contextRequestId = utils.RequestIdFromContext(h.Context())
+		h.ReplyEmpty(204)
+	})
+
+	// Generated request id
+	res := sendTestRequest(s, httptest.NewRequest("GET", "/foo", nil))
+	id := res.Header.Get("X-Request-Id")
+	if assert.NotEmpty(id) {
+		var uid uuid.UUID
+		assert.NoError(uid.Parse(id))
+	}
+	assert.Equal(id, contextRequestId)
+
+	// Request id provided by the client
+	req := httptest.NewRequest("GET", "/foo", nil)
+	req.Header.Set("X-Request-Id", "abc-123")
+	res = sendTestRequest(s, req)
+	assert.Equal("abc-123", res.Header.Get("X-Request-Id"))
+	assert.Equal("abc-123", contextRequestId)
+
+	// Invalid request id
+	req = httptest.NewRequest("GET", "/foo", nil)
+	req.Header.Set("X-Request-Id", "abc def")
+	res = sendTestRequest(s, req)
+	assert.NotEqual("abc def", res.Header.Get("X-Request-Id"))
+	assert.NotEmpty(res.Header.Get("X-Request-Id"))
+}
+
+func TestClientRequestIdPropagation(t *testing.T) {
+	var requestId string
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			requestId = req.Header.Get("X-Request-Id")
+		}))
+	defer server.Close()
+
+	client, err := NewClient(ClientCfg{Log: log.DefaultLogger("test")})
+	require.NoError(t, err)
+
+	ctx := utils.ContextWithRequestId(context.Background(), "abc-123")
+	req, err := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	require.NoError(t, err)
+
+	res, err := client.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+
+	assert.Equal(t, "abc-123", requestId)
+}
+
+func TestServerTracePropagation(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var traceparent string
+
+	upstream := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			traceparent = req.Header.Get("traceparent")
+		}))
+	defer upstream.Close()
+
+	tracer, err := tracing.NewTracer(tracing.TracerCfg{
+		HTTPClient: http.DefaultClient,
+	})
+	require.NoError(err)
+
+	client, err := NewClient(ClientCfg{
+		Log:    log.DefaultLogger("test"),
+		Tracer: tracer,
+	})
+	require.NoError(err)
+
+	s := newTestServer(t, ServerCfg{Tracer: tracer})
+
+	s.Route("/foo", "GET", func(h *Handler) {
+		req, err := http.NewRequestWithContext(h.Context(), "GET",
+			upstream.URL, nil)
+		require.NoError(err)
+
+		res, err := client.Do(req)
+		require.NoError(err)
+		res.Body.Close()
+
+		h.ReplyEmpty(204)
+	})
+
+	req := httptest.NewRequest("GET", "/foo", nil)
+	req.Header.Set("traceparent",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	sendTestRequest(s, req)
+
+	sc, err := tracing.ParseTraceparent(traceparent)
+	require.NoError(err)
+	assert.Equal("4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceId.String())
+	assert.NotEqual("00f067aa0ba902b7", sc.SpanId.String())
+}
+
+func TestServerH2C(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	s := newTestServer(t, ServerCfg{H2C: true})
+
+	s.Route("/", "GET", func(h *Handler) {
+		h.ReplyText(200, h.Request.Proto)
+	})
+
+	ts := httptest.NewUnstartedServer(s)
+	ts.Config.Protocols = s.server.Protocols
+	ts.Start()
+	defer ts.Close()
+
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+
+	client := http.Client{
+		Transport: &http.Transport{Protocols: protocols},
+	}
+
+	res, err := client.Get(ts.URL)
+	require.NoError(err)
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(err)
+
+	assert.Equal(2, res.ProtoMajor)
+	assert.Equal("HTTP/2.0", string(body))
+}
+
+func TestServerRouteWriteTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newTestServer(t, ServerCfg{})
+
+	routeFunc := func(h *Handler) {
+		time.Sleep(300 * time.Millisecond)
+		h.ReplyText(200, "ok")
+	}
+
+	s.Route("/default", "GET", routeFunc)
+	s.RouteWithOptions("/stream", "GET", routeFunc,
+		RouteOptions{WriteTimeout: -1})
+
+	ts := httptest.NewUnstartedServer(s)
+	ts.Config.WriteTimeout = 100 * time.Millisecond
+	ts.Start()
+	defer ts.Close()
+
+	get := func(path string) (string, error) {
+		res, err := ts.Client().Get(ts.URL + path)
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+
+		body, err := io.ReadAll(res.Body)
+		return string(body), err
+	}
+
+	_, err := get("/default")
+	assert.Error(err)
+
+	body, err := get("/stream")
+	if assert.NoError(err) {
+		assert.Equal("ok", body)
+	}
+}
@@ -0,0 +1,410 @@
+package shttp
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.n16f.net/ejson"
+	"go.n16f.net/program"
+	"go.n16f.net/service/pkg/scrypto"
+)
+
+const (
+	DefaultSessionCookieName = "session"
+	DefaultSessionMaxAge     = 7 * 86400 // seconds
+
+	// Browsers are not required to store cookies larger than 4096 bytes
+	// (RFC 6265 6.1.), name and attributes included.
+	MaxSessionCookieSize = 4096
+)
+
+type SessionCfg struct {
+	// If no store is provided, session values are stored in the cookie
+	// itself.
+	Store SessionStore `json:"-"`
+
+	// The first key is used to encrypt cookies; all keys are used to decrypt
+	// them. Adding a new key at the beginning of the list is therefore enough
+	// to rotate keys without invalidating existing sessions.
+	Keys []scrypto.AES256Key `json:"keys"`
+
+	MaxAge int `json:"max_age"` // seconds
+
+	CookieName     string `json:"cookie_name"`
+	CookiePath     string `json:"cookie_path"`
+	CookieDomain   string `json:"cookie_domain"`
+	CookieSameSite string `json:"cookie_same_site"`
+	InsecureCookie bool   `json:"insecure_cookie"`
+
+	PgStore *PgSessionStoreCfg `json:"pg_store"`
+}
+
+type PgSessionStoreCfg struct {
+	Client    string `json:"client"`
+	TableName string `json:"table_name"`
+}
+
+var SessionCookieSameSiteValues = []string{"lax", "strict", "none"}
+
+func (cfg *SessionCfg) ValidateJSON(v *ejson.Validator) {
+	v.Check("keys", len(cfg.Keys) > 0, "missing_value",
+		"at least one key is required")
+
+	if cfg.MaxAge != 0 {
+		v.CheckIntMin("max_age", cfg.MaxAge, 1)
+	}
+
+	if cfg.CookieSameSite != "" {
+		v.CheckStringValue("cookie_same_site", cfg.CookieSameSite,
+			SessionCookieSameSiteValues)
+	}
+
+	v.CheckOptionalObject("pg_store", cfg.PgStore)
+}
+
+func (cfg *PgSessionStoreCfg) ValidateJSON(v *ejson.Validator) {
+	v.CheckStringNotEmpty("client", cfg.Client)
+}
+
+// SessionStore is the interface used to store session values outside of the
+// session cookie. Stores are only responsible for values: session identifiers
+// and expiration times are always carried by the encrypted cookie.
+type SessionStore interface {
+	// LoadSession returns nil without error if the session does not exist or
+	// has expired.
+	LoadSession(id string) (map[string]string, error)
+	StoreSession(id string, expirationTime time.Time, values map[string]string) error
+	DeleteSession(id string) error
+}
+
+type SessionData struct {
+	Id             string            `json:"id"`
+	ExpirationTime time.Time         `json:"expiration_time"`
+	Values         map[string]string `json:"values,omitempty"`
+}
+
+type Session struct {
+	Data SessionData
+
+	handler   *Handler
+	modified  bool
+	destroyed bool
+	staleIds  []string
+}
+
+type sessionCodec struct {
+	cfg *SessionCfg
+}
+
+func newSessionCodec(cfg *SessionCfg) (*sessionCodec, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, fmt.Errorf("missing session keys")
+	}
+
+	if cfg.MaxAge == 0 {
+		cfg.MaxAge = DefaultSessionMaxAge
+	}
+
+	if cfg.CookieName == "" {
+		cfg.CookieName = DefaultSessionCookieName
+	}
+
+	if cfg.CookiePath == "" {
+		cfg.CookiePath = "/"
+	}
+
+	c := sessionCodec{
+		cfg: cfg,
+	}
+
+	return &c, nil
+}
+
+func (c *sessionCodec) encode(data *SessionData) (string, error) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return "", fmt.Errorf("cannot encode session data: %w", err)
+	}
+
+	// The cookie name is used as additional data so that the value of a
+	// session cookie cannot be reused in another cookie encrypted with the
+	// same key.
+	encryptedData, err := scrypto.EncryptAES256GCM(jsonData, c.cfg.Keys[0],
+		[]byte(c.cfg.CookieName))
+	if err != nil {
+		return "", fmt.Errorf("cannot encrypt session data: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(encryptedData), nil
+}
+
+func (c *sessionCodec) decode(s string) (*SessionData, error) {
+	encryptedData, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 value: %w", err)
+	}
+
+	var jsonData []byte
+
+	for _, key := range c.cfg.Keys {
+		jsonData, err = scrypto.DecryptAES256GCM(encryptedData, key,
+			[]byte(c.cfg.CookieName))
+		if err == nil {
+			break
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt session data: %w", err)
+	}
+
+	var data SessionData
+	if err := json.Unmarshal(jsonData, &data); err != nil {
+		return nil, fmt.Errorf("cannot decode session data: %w", err)
+	}
+
+	return &data, nil
+}
+
+func (c *sessionCodec) cookie(value string, expirationTime time.Time) *http.Cookie {
+	cookie := http.Cookie{
+		Name:     c.cfg.CookieName,
+		Value:    value,
+		Path:     c.cfg.CookiePath,
+		Domain:   c.cfg.CookieDomain,
+		Expires:  expirationTime,
+		Secure:   !c.cfg.InsecureCookie,
+		HttpOnly: true,
+	}
+
+	switch strings.ToLower(c.cfg.CookieSameSite) {
+	case "strict":
+		cookie.SameSite = http.SameSiteStrictMode
+	case "none":
+		cookie.SameSite = http.SameSiteNoneMode
+	default:
+		cookie.SameSite = http.SameSiteLaxMode
+	}
+
+	if value == "" {
+		cookie.MaxAge = -1
+	} else {
+		cookie.MaxAge = int(time.Until(expirationTime).Seconds())
+	}
+
+	return &cookie
+}
+
+func generateSessionId() string {
+	return hex.EncodeToString(scrypto.RandomBytes(32))
+}
+
+// Session returns the session associated with the request, creating a new one
+// if the request does not contain a valid session cookie. The session is
+// saved automatically right before the response header is sent.
+func (h *Handler) Session() *Session {
+	if h.session != nil {
+		return h.session
+	}
+
+	codec := h.Server.sessionCodec
+	if codec == nil {
+		program.Panic("sessions are not enabled on server %q",
+			h.Server.Cfg.Name)
+	}
+
+	h.session = &Session{handler: h}
+
+	if err := h.session.load(); err != nil {
+		h.Log.Info("ignoring session: %v", err)
+		h.session.Data = SessionData{}
+	}
+
+	if h.session.Data.Id == "" {
+		h.session.reset()
+	}
+
+	h.ResponseWriter.(*ResponseWriter).BeforeWriteHeader(func() {
+		if err := h.session.save(); err != nil {
+			h.Log.Error("cannot save session: %v", err)
+		}
+	})
+
+	return h.session
+}
+
+func (s *Session) codec() *sessionCodec {
+	return s.handler.Server.sessionCodec
+}
+
+func (s *Session) load() error {
+	codec := s.codec()
+
+	cookie, err := s.handler.Request.Cookie(codec.cfg.CookieName)
+	if err != nil {
+		return nil
+	}
+
+	data, err := codec.decode(cookie.Value)
+	if err != nil {
+		return err
+	}
+
+	if time.Now().After(data.ExpirationTime) {
+		return nil
+	}
+
+	if store := codec.cfg.Store; store != nil {
+		values, err := store.LoadSession(data.Id)
+		if err != nil {
+			return fmt.Errorf("cannot load session %q: %w", data.Id, err)
+		} else if values == nil {
+			return nil
+		}
+
+		data.Values = values
+	}
+
+	if data.Values == nil {
+		data.Values = make(map[string]string)
+	}
+
+	s.Data = *data
+
+	// Sessions are only saved when they are modified. We still want active
+	// sessions to be extended, so we mark them as modified when they are
+	// halfway to expiration.
+	maxAge := time.Duration(codec.cfg.MaxAge) * time.Second
+	if time.Until(s.Data.ExpirationTime) < maxAge/2 {
+		s.modified = true
+	}
+
+	return nil
+}
+
+func (s *Session) reset() {
+	if s.Data.Id != "" {
+		s.staleIds = append(s.staleIds, s.Data.Id)
+	}
+
+	s.Data = SessionData{
+		Id:     generateSessionId(),
+		Values: make(map[string]string),
+	}
+
+	// A new session is only worth sending to the client once something has
+	// been stored in it.
+	s.modified = false
+}
+
+func (s *Session) save() error {
+	codec := s.codec()
+	store := codec.cfg.Store
+
+	if store != nil {
+		for _, id := range s.staleIds {
+			if err := store.DeleteSession(id); err != nil {
+				return fmt.Errorf("cannot delete session %q: %w", id, err)
+			}
+		}
+	}
+
+	if s.destroyed {
+		s.handler.AddCookie(codec.cookie("", time.Time{}))
+		return nil
+	}
+
+	if !s.modified {
+		return nil
+	}
+
+	maxAge := time.Duration(codec.cfg.MaxAge) * time.Second
+	s.Data.ExpirationTime = time.Now().Add(maxAge).Truncate(time.Second)
+
+	cookieData := SessionData{
+		Id:             s.Data.Id,
+		ExpirationTime: s.Data.ExpirationTime,
+	}
+
+	if store == nil {
+		cookieData.Values = s.Data.Values
+	} else {
+		err := store.StoreSession(s.Data.Id, s.Data.ExpirationTime,
+			s.Data.Values)
+		if err != nil {
+			return fmt.Errorf("cannot store session %q: %w", s.Data.Id, err)
+		}
+	}
+
+	value, err := codec.encode(&cookieData)
+	if err != nil {
+		return err
+	}
+
+	cookie := codec.cookie(value, s.Data.ExpirationTime)
+	if cookieString := cookie.String(); len(cookieString) > MaxSessionCookieSize {
+		return fmt.Errorf("session cookie too large (%d bytes)",
+			len(cookieString))
+	}
+
+	s.handler.AddCookie(cookie)
+
+	return nil
+}
+
+func (s *Session) Id() string {
+	return s.Data.Id
+}
+
+func (s *Session) ExpirationTime() time.Time {
+	return s.Data.ExpirationTime
+}
+
+func (s *Session) Get(key string) string {
+	return s.Data.Values[key]
+}
+
+func (s *Session) Has(key string) bool {
+	_, found := s.Data.Values[key]
+	return found
+}
+
+func (s *Session) Set(key, value string) {
+	s.Data.Values[key] = value
+	s.modified = true
+	s.destroyed = false
+}
+
+func (s *Session) Delete(key string) {
+	if _, found := s.Data.Values[key]; !found {
+		return
+	}
+
+	delete(s.Data.Values, key)
+	s.modified = true
+}
+
+// Regenerate assigns a new identifier to the session while keeping its
+// values. It must be called when the privilege level of the session changes,
+// typically after a successful login, to prevent session fixation attacks.
+func (s *Session) Regenerate() {
+	values := s.Data.Values
+
+	s.reset()
+
+	s.Data.Values = values
+	s.modified = true
+	s.destroyed = false
+}
+
+// Destroy deletes all session values and instructs the client to delete the
+// session cookie.
+func (s *Session) Destroy() {
+	s.reset()
+	s.destroyed = true
+}
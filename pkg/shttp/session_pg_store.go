@@ -0,0 +1,148 @@
+package shttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.n16f.net/service/pkg/pg"
+)
+
+const DefaultPgSessionStoreTableName = "shttp_sessions"
+
+type PgSessionStore struct {
+	Client    *pg.Client
+	TableName string
+}
+
+// NewPgSessionStore creates a session store using a PostgreSQL table. The
+// table is not created by the constructor: it is created by the migrations
+// returned by Migrations, usually applied with UpdateSchema when the
+// application starts.
+func NewPgSessionStore(client *pg.Client, tableName string) (*PgSessionStore, error) {
+	if tableName == "" {
+		tableName = DefaultPgSessionStoreTableName
+	}
+
+	s := PgSessionStore{
+		Client:    client,
+		TableName: tableName,
+	}
+
+	return &s, nil
+}
+
+// Migrations returns the migrations creating and updating the session table.
+// Their schema is the name of the table, so that versions are tracked
+// separately for each table in the schema_versions table.
+func (s *PgSessionStore) Migrations() pg.Migrations {
+	table := pg.QuoteIdentifier(s.TableName)
+
+	return pg.Migrations{
+		{
+			Schema:  s.TableName,
+			Version: "20261018T230812Z",
+			Code: []byte(fmt.Sprintf(`
+CREATE TABLE %s
+  (id TEXT PRIMARY KEY,
+   expiration_time TIMESTAMPTZ NOT NULL,
+   data JSONB NOT NULL);
+`, table)),
+		},
+	}
+}
+
+// UpdateSchema applies the migrations of the session table which have not
+// been applied yet.
+func (s *PgSessionStore) UpdateSchema() error {
+	if err := s.Client.ApplyMigrations(s.TableName, s.Migrations()); err != nil {
+		return fmt.Errorf("cannot update session table %q: %w",
+			s.TableName, err)
+	}
+
+	return nil
+}
+
+func (s *PgSessionStore) LoadSession(id string) (map[string]string, error) {
+	query := fmt.Sprintf(`
+SELECT data
+  FROM %s
+  WHERE id = $1 AND expiration_time > CURRENT_TIMESTAMP
+`, pg.QuoteIdentifier(s.TableName))
+
+	var data []byte
+
+	err := s.Client.WithConn(func(conn pg.Conn) error {
+		return pg.QueryRow(conn, query, id).Scan(&data)
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var values map[string]string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("cannot decode session data: %w", err)
+	}
+
+	if values == nil {
+		values = make(map[string]string)
+	}
+
+	return values, nil
+}
+
+func (s *PgSessionStore) StoreSession(id string, expirationTime time.Time, values map[string]string) error {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return fmt.Errorf("cannot encode session data: %w", err)
+	}
+
+	query := fmt.Sprintf(`
+INSERT INTO %s (id, expiration_time, data)
+  VALUES ($1, $2, $3)
+  ON CONFLICT (id) DO UPDATE
+    SET expiration_time = EXCLUDED.expiration_time,
+        data = EXCLUDED.data
+`, pg.QuoteIdentifier(s.TableName))
+
+	return s.Client.WithConn(func(conn pg.Conn) error {
+		return pg.Exec(conn, query, id, expirationTime, data)
+	})
+}
+
+func (s *PgSessionStore) DeleteSession(id string) error {
+	query := fmt.Sprintf(`
+DELETE FROM %s WHERE id = $1
+`, pg.QuoteIdentifier(s.TableName))
+
+	return s.Client.WithConn(func(conn pg.Conn) error {
+		return pg.Exec(conn, query, id)
+	})
+}
+
+// DeleteExpiredSessions deletes expired sessions and returns the number of
+// sessions deleted. It is meant to be called regularly, for example from a
+// service worker.
+func (s *PgSessionStore) DeleteExpiredSessions() (int64, error) {
+	query := fmt.Sprintf(`
+DELETE FROM %s WHERE expiration_time <= CURRENT_TIMESTAMP
+`, pg.QuoteIdentifier(s.TableName))
+
+	var n int64
+
+	err := s.Client.WithConn(func(conn pg.Conn) (err error) {
+		n, err = pg.Exec2(conn, query)
+		return
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
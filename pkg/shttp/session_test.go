@@ -0,0 +1,130 @@
+package shttp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.n16f.net/service/pkg/scrypto"
+)
+
+func TestSessions(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var key1, key2 scrypto.AES256Key
+	copy(key1[:], scrypto.RandomBytes(32))
+	copy(key2[:], scrypto.RandomBytes(32))
+
+	sessionCfg := SessionCfg{Keys: []scrypto.AES256Key{key1}}
+	s := newTestServer(t, ServerCfg{Sessions: &sessionCfg})
+
+	var sessionId string
+
+	s.Route("/set", "POST", func(h *Handler) {
+		session := h.Session()
+		session.Set("user", h.QueryParameter("user"))
+		sessionId = session.Id()
+		h.ReplyEmpty(204)
+	})
+
+	s.Route("/get", "GET", func(h *Handler) {
+		h.ReplyText(200, h.Session().Get("user"))
+	})
+
+	s.Route("/regenerate", "POST", func(h *Handler) {
+		session := h.Session()
+		session.Regenerate()
+		sessionId = session.Id()
+		h.ReplyEmpty(204)
+	})
+
+	s.Route("/destroy", "POST", func(h *Handler) {
+		h.Session().Destroy()
+		h.ReplyEmpty(204)
+	})
+
+	sendRequest := func(method, uri string, cookie *http.Cookie) *http.Response {
+		req := httptest.NewRequest(method, uri, nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+
+		return sendTestRequest(s, req)
+	}
+
+	sessionCookie := func(res *http.Response) *http.Cookie {
+		for _, cookie := range res.Cookies() {
+			if cookie.Name == DefaultSessionCookieName {
+				return cookie
+			}
+		}
+
+		return nil
+	}
+
+	readBody := func(res *http.Response) string {
+		data, err := io.ReadAll(res.Body)
+		require.NoError(err)
+		return string(data)
+	}
+
+	// Reading a session without a cookie does not create one
+	res := sendRequest("GET", "/get", nil)
+	assert.Equal(200, res.StatusCode)
+	assert.Nil(sessionCookie(res))
+
+	// Setting a value
+	res = sendRequest("POST", "/set?user=bob", nil)
+	require.Equal(204, res.StatusCode)
+	cookie := sessionCookie(res)
+	require.NotNil(cookie)
+	assert.True(cookie.Secure)
+	assert.True(cookie.HttpOnly)
+	assert.NotContains(cookie.Value, "bob")
+	firstSessionId := sessionId
+
+	res = sendRequest("GET", "/get", cookie)
+	assert.Equal("bob", readBody(res))
+
+	// Tampered cookies are ignored
+	tamperedCookie := *cookie
+	tamperedChar := "x"
+	if cookie.Value[0] == 'x' {
+		tamperedChar = "y"
+	}
+	tamperedCookie.Value = tamperedChar + cookie.Value[1:]
+	res = sendRequest("GET", "/get", &tamperedCookie)
+	assert.Equal("", readBody(res))
+
+	// Regeneration keeps values but changes the identifier
+	res = sendRequest("POST", "/regenerate", cookie)
+	require.Equal(204, res.StatusCode)
+	assert.NotEqual(firstSessionId, sessionId)
+	cookie = sessionCookie(res)
+	require.NotNil(cookie)
+
+	res = sendRequest("GET", "/get", cookie)
+	assert.Equal("bob", readBody(res))
+
+	// Key rotation
+	sessionCfg.Keys = []scrypto.AES256Key{key2, key1}
+	res = sendRequest("GET", "/get", cookie)
+	assert.Equal("bob", readBody(res))
+
+	sessionCfg.Keys = []scrypto.AES256Key{key2}
+	res = sendRequest("GET", "/get", cookie)
+	assert.Equal("", readBody(res))
+
+	// Destruction
+	sessionCfg.Keys = []scrypto.AES256Key{key1}
+	res = sendRequest("POST", "/destroy", cookie)
+	require.Equal(204, res.StatusCode)
+	cookie = sessionCookie(res)
+	require.NotNil(cookie)
+	assert.Equal("", cookie.Value)
+	assert.True(cookie.MaxAge < 0)
+}
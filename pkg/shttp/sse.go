@@ -3,17 +3,27 @@ package shttp
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
-	"math"
 	"strconv"
+	"strings"
 )
 
 // Reference: https://html.spec.whatwg.org/multipage/server-sent-events.html
 
 type SSEReader struct {
 	buf *bufio.Reader
+
+	// The id of the last event, which persists across events as mandated by
+	// the specification.
+	LastEventId string
+
+	// The last retry delay sent by the server, 0 if there was none.
+	RetryDelay int // milliseconds
+
+	started bool
+	skipLF  bool
 }
 
 type SSEEvent struct {
@@ -25,22 +35,32 @@ type SSEEvent struct {
 	Retry int // milliseconds
 }
 
+func (e *SSEEvent) DecodeData(dest any) error {
+	return json.Unmarshal([]byte(e.Data), dest)
+}
+
 func NewSSEReader(r io.Reader) *SSEReader {
 	return &SSEReader{
 		buf: bufio.NewReader(r),
 	}
 }
 
+// ReadEvent returns the next event of the stream, or nil when the end of the
+// stream is reached. Blocks which do not contain any data field do not
+// produce events, but their id and retry fields are still processed.
 func (r *SSEReader) ReadEvent() (*SSEEvent, error) {
 	// See 9.2.6.
 
 	var event SSEEvent
+	var data strings.Builder
+	var hasData bool
 
 	for {
 		line, err := r.readLine()
 		if err != nil {
-			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
-				break
+			if errors.Is(err, io.EOF) {
+				// Incomplete events are discarded
+				return nil, nil
 			}
 
 			return nil, err
@@ -48,6 +68,14 @@ func (r *SSEReader) ReadEvent() (*SSEEvent, error) {
 
 		if len(line) == 0 {
 			// Event end
+			if !hasData {
+				event = SSEEvent{}
+				continue
+			}
+
+			event.Id = r.LastEventId
+			event.Data = strings.TrimSuffix(data.String(), "\n")
+
 			return &event, nil
 		}
 
@@ -58,69 +86,102 @@ func (r *SSEReader) ReadEvent() (*SSEEvent, error) {
 
 		fieldData, valueData, _ := bytes.Cut(line, []byte{':'})
 		field := string(fieldData)
-		value := string(bytes.TrimLeft(valueData, " "))
+		value := string(bytes.TrimPrefix(valueData, []byte{' '}))
 
 		switch field {
 		case "event":
 			event.Type = value
 
 		case "data":
-			event.Data = value
+			data.WriteString(value)
+			data.WriteByte('\n')
+			hasData = true
 
 		case "id":
-			event.Id = value
+			if !strings.ContainsRune(value, 0) {
+				r.LastEventId = value
+			}
 
 		case "retry":
-			// We are supposed to ignore invalid retry values, but let us be
-			// strict and avoid stupid mistakes.
-			i64, err := strconv.ParseInt(value, 10, 64)
-			if err != nil || i64 < 0 {
-				return nil, fmt.Errorf("invalid retry delay %q", value)
-			} else if i64 > math.MaxInt {
-				return nil, fmt.Errorf("retry delay %d too large (max: %d)",
-					i64, math.MaxInt)
+			// Invalid values are ignored
+			if delay, ok := parseSSERetryDelay(value); ok {
+				event.Retry = delay
+				r.RetryDelay = delay
 			}
 
 		default:
-			// Unknown fields are supposed to be ignored, but again let us be
-			// careful.
-			return nil, fmt.Errorf("unknown field %q", field)
+			// Unknown fields are ignored
 		}
 	}
-
-	return nil, nil
 }
 
 func (r *SSEReader) readLine() ([]byte, error) {
-	// We have to support three possible line ending sequences because SSE is
-	// stupid. So it cannot really be made efficient.
+	// We have to support three possible line ending sequences: CRLF, LF and
+	// CR. A line which is not terminated is incomplete and therefore
+	// reported as an end of file.
+
+	if !r.started {
+		r.started = true
+
+		// The stream can start with a byte order mark
+		if c, err := r.buf.Peek(1); err == nil && c[0] == 0xef {
+			bom, err := r.buf.Peek(3)
+			if err == nil && bytes.Equal(bom, []byte{0xef, 0xbb, 0xbf}) {
+				r.buf.Discard(3)
+			}
+		}
+	}
 
 	var line []byte
-	var i int
 
 	for {
 		c, err := r.buf.ReadByte()
 		if err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				err = io.EOF
+			}
+
 			return nil, err
 		}
 
-		if c == '\n' {
-			break
-		} else if c == '\r' {
-			c2, err := r.buf.Peek(1)
-			if err != nil {
-				return nil, err
-			}
+		// We cannot wait for the character following CR since it could
+		// block until the next event is sent, so we remember that a LF
+		// must be skipped.
+		skipLF := r.skipLF
+		r.skipLF = false
 
-			if c2[0] == '\n' {
-				r.buf.Discard(1)
-				i++
+		switch c {
+		case '\n':
+			if skipLF && len(line) == 0 {
+				continue
 			}
+
+			return line, nil
+
+		case '\r':
+			r.skipLF = true
+			return line, nil
 		}
 
 		line = append(line, c)
-		i++
+	}
+}
+
+func parseSSERetryDelay(s string) (int, bool) {
+	if s == "" {
+		return 0, false
+	}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, false
+		}
+	}
+
+	delay, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, false
 	}
 
-	return line, nil
+	return delay, true
 }
@@ -0,0 +1,369 @@
+package shttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.n16f.net/log"
+	"go.n16f.net/service/pkg/pg"
+)
+
+// SSEBroker distributes events published on topics to the clients subscribed
+// to them. Each client has a bounded queue; clients which do not consume
+// events fast enough are disconnected and can resume the stream when they
+// reconnect since recent events are kept in a replay buffer.
+//
+// Event ids are generated by the broker; they are only meaningful for the
+// broker which generated them. When several replicas are bridged with
+// PostgreSQL notifications, a client reconnecting to another replica may
+// therefore miss or receive again some events.
+//
+// Streams stay open indefinitely: routes serving them should disable the
+// write timeout of the server with RouteOptions.WriteTimeout.
+
+const (
+	DefaultSSEBrokerQueueSize         = 64
+	DefaultSSEBrokerReplayBufferSize  = 1024
+	DefaultSSEBrokerHeartbeatInterval = 15 * time.Second
+	DefaultSSEBrokerPgBufferSize      = 1024
+)
+
+var ErrSSEBrokerClosed = errors.New("SSE broker closed")
+var ErrSSESlowConsumer = errors.New("SSE client too slow")
+
+type SSEBrokerCfg struct {
+	Log *log.Logger
+
+	QueueSize         int
+	ReplayBufferSize  int
+	HeartbeatInterval time.Duration
+
+	// The number of PostgreSQL notifications buffered by ListenPg.
+	// Notifications received while the buffer is full are dropped and
+	// logged by the PostgreSQL client.
+	PgBufferSize int
+}
+
+type SSEBroker struct {
+	Cfg SSEBrokerCfg
+	Log *log.Logger
+
+	lastEventId   int64
+	replayBuffer  []*sseBrokerEvent // ring buffer
+	replayStart   int
+	subscribers   map[*sseSubscriber]struct{}
+	closed        bool
+	subscribeLock sync.Mutex
+
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+type sseBrokerEvent struct {
+	Topic string
+	SSEEvent
+}
+
+type sseSubscriber struct {
+	topics  []string
+	events  chan *sseBrokerEvent
+	evicted chan struct{}
+}
+
+func NewSSEBroker(cfg SSEBrokerCfg) *SSEBroker {
+	if cfg.Log == nil {
+		cfg.Log = log.DefaultLogger("sse_broker")
+	}
+
+	if cfg.QueueSize == 0 {
+		cfg.QueueSize = DefaultSSEBrokerQueueSize
+	}
+
+	if cfg.ReplayBufferSize == 0 {
+		cfg.ReplayBufferSize = DefaultSSEBrokerReplayBufferSize
+	}
+
+	if cfg.HeartbeatInterval == 0 {
+		cfg.HeartbeatInterval = DefaultSSEBrokerHeartbeatInterval
+	}
+
+	if cfg.PgBufferSize == 0 {
+		cfg.PgBufferSize = DefaultSSEBrokerPgBufferSize
+	}
+
+	b := SSEBroker{
+		Cfg: cfg,
+		Log: cfg.Log,
+
+		replayBuffer: make([]*sseBrokerEvent, 0, cfg.ReplayBufferSize),
+		subscribers:  make(map[*sseSubscriber]struct{}),
+
+		stopChan: make(chan struct{}),
+	}
+
+	return &b
+}
+
+// Close disconnects all clients. It is called automatically when the server
+// stops for brokers registered with Server.RegisterSSEBroker.
+func (b *SSEBroker) Close() {
+	b.subscribeLock.Lock()
+	if b.closed {
+		b.subscribeLock.Unlock()
+		return
+	}
+
+	b.closed = true
+	b.subscribeLock.Unlock()
+
+	close(b.stopChan)
+	b.wg.Wait()
+}
+
+// Publish sends an event to all clients subscribed to the topic and returns
+// the id of the event.
+func (b *SSEBroker) Publish(topic, eventType, data string) int64 {
+	b.subscribeLock.Lock()
+	defer b.subscribeLock.Unlock()
+
+	b.lastEventId++
+
+	event := sseBrokerEvent{
+		Topic: topic,
+		SSEEvent: SSEEvent{
+			Id:   strconv.FormatInt(b.lastEventId, 10),
+			Type: eventType,
+			Data: data,
+		},
+	}
+
+	if len(b.replayBuffer) < b.Cfg.ReplayBufferSize {
+		b.replayBuffer = append(b.replayBuffer, &event)
+	} else {
+		b.replayBuffer[b.replayStart] = &event
+		b.replayStart = (b.replayStart + 1) % len(b.replayBuffer)
+	}
+
+	for sub := range b.subscribers {
+		if !slices.Contains(sub.topics, topic) {
+			continue
+		}
+
+		select {
+		case sub.events <- &event:
+		default:
+			// The client will resume from the replay buffer when it
+			// reconnects.
+			close(sub.evicted)
+			delete(b.subscribers, sub)
+		}
+	}
+
+	return b.lastEventId
+}
+
+func (b *SSEBroker) PublishJSON(topic, eventType string, value any) (int64, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return 0, fmt.Errorf("cannot encode JSON event data: %w", err)
+	}
+
+	return b.Publish(topic, eventType, string(data)), nil
+}
+
+// Serve sends the events of a set of topics to the client until it
+// disconnects, is evicted or until the broker is closed. If the client sent
+// a Last-Event-ID header field, events still in the replay buffer are sent
+// first.
+func (b *SSEBroker) Serve(h *Handler, topics ...string) error {
+	lastEventId, err := h.SSEInt64LastEventId()
+	if err != nil {
+		return err
+	}
+
+	sub, replay, err := b.subscribe(topics, lastEventId)
+	if err != nil {
+		h.ReplyError(503, "service_unavailable", "%v", err)
+		return err
+	}
+	defer b.unsubscribe(sub)
+
+	if err := h.ReplySSE(200); err != nil {
+		return err
+	}
+
+	// Send the header right away so that the client knows the stream is
+	// established even if there is no event to send.
+	if err := h.WriteSSEComment("connected"); err != nil {
+		return err
+	}
+
+	for _, event := range replay {
+		if err := h.WriteSSE(event.Id, event.Type, event.Data); err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(b.Cfg.HeartbeatInterval)
+	defer ticker.Stop()
+
+	ctx := h.Context()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-b.stopChan:
+			return nil
+
+		case <-sub.evicted:
+			// Send events which were already queued before closing the
+			// connection.
+			for {
+				select {
+				case event := <-sub.events:
+					err := h.WriteSSE(event.Id, event.Type, event.Data)
+					if err != nil {
+						return err
+					}
+				default:
+					h.Log.Info("evicting slow SSE client")
+					return ErrSSESlowConsumer
+				}
+			}
+
+		case event := <-sub.events:
+			if err := h.WriteSSE(event.Id, event.Type, event.Data); err != nil {
+				return err
+			}
+
+		case <-ticker.C:
+			if err := h.WriteSSEComment("heartbeat"); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (b *SSEBroker) subscribe(topics []string, lastEventId int64) (*sseSubscriber, []*sseBrokerEvent, error) {
+	b.subscribeLock.Lock()
+	defer b.subscribeLock.Unlock()
+
+	if b.closed {
+		return nil, nil, ErrSSEBrokerClosed
+	}
+
+	sub := sseSubscriber{
+		topics:  topics,
+		events:  make(chan *sseBrokerEvent, b.Cfg.QueueSize),
+		evicted: make(chan struct{}),
+	}
+
+	b.subscribers[&sub] = struct{}{}
+
+	var replay []*sseBrokerEvent
+
+	if lastEventId > 0 {
+		n := len(b.replayBuffer)
+
+		for i := range n {
+			event := b.replayBuffer[(b.replayStart+i)%n]
+
+			id, _ := strconv.ParseInt(event.Id, 10, 64)
+			if id > lastEventId && slices.Contains(topics, event.Topic) {
+				replay = append(replay, event)
+			}
+		}
+	}
+
+	return &sub, replay, nil
+}
+
+func (b *SSEBroker) unsubscribe(sub *sseSubscriber) {
+	b.subscribeLock.Lock()
+	defer b.subscribeLock.Unlock()
+
+	delete(b.subscribers, sub)
+}
+
+func (s *Server) RegisterSSEBroker(b *SSEBroker) {
+	s.server.RegisterOnShutdown(b.Close)
+}
+
+// PostgreSQL bridge
+
+type ssePgNotification struct {
+	Topic string `json:"topic"`
+	Type  string `json:"type,omitempty"`
+	Data  string `json:"data"`
+}
+
+// ListenPg publishes the events sent with PublishSSEEventPg on a PostgreSQL
+// notification channel, so that all the replicas of a service can send them
+// to their clients. Events must then be published with PublishSSEEventPg
+// instead of Publish.
+//
+// Notifications are buffered (see SSEBrokerCfg.PgBufferSize); if events are
+// published faster than the broker can process them, some of them are lost.
+func (b *SSEBroker) ListenPg(client *pg.Client, channel string) error {
+	sub, err := client.ListenWithBufferSize(channel, b.Cfg.PgBufferSize)
+	if err != nil {
+		return fmt.Errorf("cannot listen to channel %q: %w", channel, err)
+	}
+
+	b.wg.Add(1)
+
+	go func() {
+		defer b.wg.Done()
+		defer sub.Cancel()
+
+		for {
+			select {
+			case <-b.stopChan:
+				return
+
+			case payload, ok := <-sub.C:
+				if !ok {
+					return
+				}
+
+				var notification ssePgNotification
+				err := json.Unmarshal([]byte(payload), &notification)
+				if err != nil {
+					b.Log.Error("cannot decode SSE notification: %v", err)
+					continue
+				}
+
+				b.Publish(notification.Topic, notification.Type,
+					notification.Data)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// PublishSSEEventPg sends an event to SSE brokers listening on a PostgreSQL
+// notification channel. Since notifications are sent when the current
+// transaction is committed, events are only published if it succeeds. Note
+// that PostgreSQL limits the size of notification payloads to 8000 bytes.
+func PublishSSEEventPg(conn pg.Conn, channel, topic, eventType, data string) error {
+	notification := ssePgNotification{
+		Topic: topic,
+		Type:  eventType,
+		Data:  data,
+	}
+
+	payload, err := json.Marshal(&notification)
+	if err != nil {
+		return fmt.Errorf("cannot encode notification: %w", err)
+	}
+
+	return pg.Notify(conn, channel, string(payload))
+}
@@ -0,0 +1,190 @@
+package shttp
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.n16f.net/log"
+)
+
+func TestSSEBroker(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	broker := NewSSEBroker(SSEBrokerCfg{
+		Log:               log.DefaultLogger("test"),
+		HeartbeatInterval: time.Hour,
+	})
+
+	s := newTestServer(t, ServerCfg{})
+	s.RegisterSSEBroker(broker)
+
+	s.RouteWithOptions("/events", "GET", func(h *Handler) {
+		broker.Serve(h, "foo")
+	}, RouteOptions{WriteTimeout: -1})
+
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	// Events published before the client connects are replayed if the
+	// client sends the id of the last event it received.
+	firstId := broker.Publish("foo", "", "1")
+	broker.Publish("bar", "", "ignored")
+	broker.Publish("foo", "", "2")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET",
+		server.URL+"/events", nil)
+	require.NoError(err)
+	req.Header.Set("Last-Event-ID", "1")
+
+	res, err := server.Client().Do(req)
+	require.NoError(err)
+	defer res.Body.Close()
+
+	r := bufio.NewReader(res.Body)
+
+	// The comment is sent once the client is subscribed, so events
+	// published after it are sent after the replay.
+	line, err := r.ReadString('\n')
+	require.NoError(err)
+	require.Equal(": connected\n", line)
+
+	broker.Publish("foo", "test", "3")
+	broker.Publish("bar", "", "ignored")
+	broker.Publish("foo", "", "4")
+
+	var events []SSEEvent
+	var event SSEEvent
+
+	for len(events) < 3 {
+		line, err := r.ReadString('\n')
+		require.NoError(err)
+
+		name, value, _ := strings.Cut(strings.TrimSuffix(line, "\n"), ": ")
+
+		switch name {
+		case "id":
+			event.Id = value
+		case "event":
+			event.Type = value
+		case "data":
+			event.Data = value
+		case "":
+			events = append(events, event)
+			event = SSEEvent{}
+		}
+	}
+
+	assert.Equal(int64(1), firstId)
+	assert.Equal([]SSEEvent{
+		{Id: "3", Data: "2"},
+		{Id: "4", Type: "test", Data: "3"},
+		{Id: "6", Data: "4"},
+	}, events)
+}
+
+func TestSSEBrokerEviction(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	broker := NewSSEBroker(SSEBrokerCfg{
+		QueueSize:        2,
+		ReplayBufferSize: 3,
+	})
+
+	sub, _, err := broker.subscribe([]string{"foo"}, 0)
+	require.NoError(err)
+
+	broker.Publish("foo", "", "1")
+	broker.Publish("foo", "", "2")
+
+	select {
+	case <-sub.evicted:
+		assert.Fail("subscriber evicted too early")
+	default:
+	}
+
+	broker.Publish("foo", "", "3")
+
+	select {
+	case <-sub.evicted:
+	default:
+		assert.Fail("subscriber not evicted")
+	}
+
+	broker.Publish("foo", "", "4")
+
+	// Only the last events are kept for replay
+	_, replay, err := broker.subscribe([]string{"foo"}, 1)
+	require.NoError(err)
+
+	var data []string
+	for _, event := range replay {
+		data = append(data, event.Data)
+	}
+
+	assert.Equal([]string{"2", "3", "4"}, data)
+
+	broker.Close()
+
+	_, _, err = broker.subscribe([]string{"foo"}, 0)
+	assert.ErrorIs(err, ErrSSEBrokerClosed)
+}
+
+func TestSSEBrokerServerStop(t *testing.T) {
+	require := require.New(t)
+
+	broker := NewSSEBroker(SSEBrokerCfg{HeartbeatInterval: time.Hour})
+
+	// Server.Start does not expose the address of the listening socket, so
+	// we need a free port.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	address := listener.Addr().String()
+	listener.Close()
+
+	s := newTestServer(t, ServerCfg{Address: address})
+	s.RegisterSSEBroker(broker)
+
+	served := make(chan struct{})
+
+	s.RouteWithOptions("/events", "GET", func(h *Handler) {
+		broker.Serve(h, "foo")
+		close(served)
+	}, RouteOptions{WriteTimeout: -1})
+
+	require.NoError(s.Start())
+
+	res, err := http.Get("http://" + address + "/events")
+	require.NoError(err)
+	defer res.Body.Close()
+
+	line, err := bufio.NewReader(res.Body).ReadString('\n')
+	require.NoError(err)
+	require.Equal(": connected\n", line)
+
+	stopped := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(stopped)
+	}()
+
+	for _, c := range []chan struct{}{served, stopped} {
+		select {
+		case <-c:
+		case <-time.After(5 * time.Second):
+			require.Fail("stream not closed on shutdown")
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package shttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"mime"
+	"net/http"
+	"time"
+
+	"go.n16f.net/ejson"
+	"go.n16f.net/log"
+)
+
+const (
+	DefaultSSERetryDelay    = 3_000  // milliseconds
+	DefaultSSEMaxRetryDelay = 60_000 // milliseconds
+)
+
+// ErrSSEStreamClosed is returned when the server replies with status 204,
+// which indicates that the client must not reconnect.
+var ErrSSEStreamClosed = errors.New("event stream closed by the server")
+
+type SSEClientCfg struct {
+	Log    *log.Logger `json:"-"`
+	Client *Client     `json:"-"`
+
+	URI    string      `json:"uri"`
+	Header http.Header `json:"-"`
+
+	// The delay before reconnecting if the server did not send any. When
+	// connection attempts fail repeatedly, the delay is doubled up to the
+	// maximum delay.
+	RetryDelay    int `json:"retry_delay"`     // milliseconds
+	MaxRetryDelay int `json:"max_retry_delay"` // milliseconds
+
+	// The id of the last event received, used when resuming a stream.
+	LastEventId string `json:"-"`
+}
+
+func (cfg *SSEClientCfg) ValidateJSON(v *ejson.Validator) {
+	v.CheckStringURI("uri", cfg.URI)
+	v.CheckIntMin("retry_delay", cfg.RetryDelay, 0)
+	v.CheckIntMin("max_retry_delay", cfg.MaxRetryDelay, 0)
+}
+
+// SSEClient reads a server-sent event stream and reconnects automatically
+// when the connection is lost.
+type SSEClient struct {
+	Cfg SSEClientCfg
+	Log *log.Logger
+
+	httpClient  *http.Client
+	lastEventId string
+	retryDelay  time.Duration
+}
+
+func NewSSEClient(cfg SSEClientCfg) (*SSEClient, error) {
+	if cfg.Client == nil {
+		return nil, fmt.Errorf("missing HTTP client")
+	}
+
+	if cfg.URI == "" {
+		return nil, fmt.Errorf("missing or empty URI")
+	}
+
+	if cfg.Log == nil {
+		cfg.Log = cfg.Client.Log
+	}
+
+	if cfg.RetryDelay == 0 {
+		cfg.RetryDelay = DefaultSSERetryDelay
+	}
+
+	if cfg.MaxRetryDelay == 0 {
+		cfg.MaxRetryDelay = DefaultSSEMaxRetryDelay
+	}
+
+	// Streams stay open indefinitely so the request timeout of the client
+	// cannot apply.
+	httpClient := *cfg.Client.Client
+	httpClient.Timeout = 0
+
+	c := SSEClient{
+		Cfg: cfg,
+		Log: cfg.Log,
+
+		httpClient:  &httpClient,
+		lastEventId: cfg.LastEventId,
+		retryDelay:  time.Duration(cfg.RetryDelay) * time.Millisecond,
+	}
+
+	return &c, nil
+}
+
+// LastEventId returns the id of the last event received.
+func (c *SSEClient) LastEventId() string {
+	return c.lastEventId
+}
+
+// Events returns an iterator on the events of the stream. Connection errors
+// are yielded and followed by a reconnection unless the caller stops
+// iterating. Iteration ends when the context is canceled, or after an error
+// which does not allow reconnection, for example if the server responds with
+// an error status.
+func (c *SSEClient) Events(ctx context.Context) iter.Seq2[*SSEEvent, error] {
+	return func(yield func(*SSEEvent, error) bool) {
+		nbFailures := 0
+
+		for {
+			connected, err := c.readStream(ctx, yield)
+			if ctx.Err() != nil {
+				return
+			}
+
+			var fatalErr *sseFatalError
+			if errors.As(err, &fatalErr) {
+				yield(nil, fatalErr.err)
+				return
+			}
+
+			if err == errSSEIterationStopped {
+				return
+			}
+
+			if connected {
+				nbFailures = 0
+			} else {
+				nbFailures++
+			}
+
+			if err != nil && !yield(nil, err) {
+				return
+			}
+
+			delay := c.reconnectionDelay(nbFailures)
+			c.Log.Debug(1, "reconnecting to %s in %v", c.Cfg.URI, delay)
+
+			timer := time.NewTimer(delay)
+
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+	}
+}
+
+// Chan delivers events over a channel, which is closed when the context is
+// canceled or when the stream cannot be resumed. Errors are logged.
+func (c *SSEClient) Chan(ctx context.Context) <-chan *SSEEvent {
+	events := make(chan *SSEEvent)
+
+	go func() {
+		defer close(events)
+
+		for event, err := range c.Events(ctx) {
+			if err != nil {
+				c.Log.Error("cannot read event stream: %v", err)
+				continue
+			}
+
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events
+}
+
+// SSEJSONEvents decodes the data of each event as JSON. Decoding errors are
+// yielded as any other error.
+func SSEJSONEvents[T any](events iter.Seq2[*SSEEvent, error]) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+
+		for event, err := range events {
+			if err != nil {
+				if !yield(zero, err) {
+					return
+				}
+
+				continue
+			}
+
+			var value T
+			if err := event.DecodeData(&value); err != nil {
+				err = fmt.Errorf("cannot decode data of event %q: %w",
+					event.Id, err)
+				if !yield(zero, err) {
+					return
+				}
+
+				continue
+			}
+
+			if !yield(value, nil) {
+				return
+			}
+		}
+	}
+}
+
+var errSSEIterationStopped = errors.New("iteration stopped")
+
+type sseFatalError struct {
+	err error
+}
+
+func (err *sseFatalError) Error() string {
+	return err.err.Error()
+}
+
+// readStream connects to the server and yields events until the connection
+// is closed. It returns true if the connection was established.
+func (c *SSEClient) readStream(ctx context.Context, yield func(*SSEEvent, error) bool) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.Cfg.URI, nil)
+	if err != nil {
+		return false, &sseFatalError{fmt.Errorf("cannot create request: %w",
+			err)}
+	}
+
+	for name, values := range c.Cfg.Header {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+
+	if c.lastEventId != "" {
+		req.Header.Set("Last-Event-ID", c.lastEventId)
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("cannot send request: %w", err)
+	}
+	defer res.Body.Close()
+
+	// See 9.2.3: only 200 responses with the right content type are event
+	// streams; the client must not reconnect after any other response.
+	if res.StatusCode == 204 {
+		return false, &sseFatalError{ErrSSEStreamClosed}
+	}
+
+	if res.StatusCode != 200 {
+		return false, &sseFatalError{fmt.Errorf("request failed with "+
+			"status %d", res.StatusCode)}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if mediaType != "text/event-stream" {
+		return false, &sseFatalError{fmt.Errorf("invalid content type %q",
+			res.Header.Get("Content-Type"))}
+	}
+
+	r := NewSSEReader(res.Body)
+	r.LastEventId = c.lastEventId
+
+	for {
+		event, err := r.ReadEvent()
+
+		// Retry delays and event ids can be sent in blocks without data,
+		// so we always update them.
+		if r.RetryDelay > 0 {
+			c.retryDelay = time.Duration(r.RetryDelay) * time.Millisecond
+		}
+
+		c.lastEventId = r.LastEventId
+
+		if err != nil {
+			return true, fmt.Errorf("cannot read event: %w", err)
+		}
+
+		if event == nil {
+			return true, nil
+		}
+
+		if !yield(event, nil) {
+			return true, errSSEIterationStopped
+		}
+	}
+}
+
+func (c *SSEClient) reconnectionDelay(nbFailures int) time.Duration {
+	delay := c.retryDelay
+	maxDelay := time.Duration(c.Cfg.MaxRetryDelay) * time.Millisecond
+
+	for i := 1; i < nbFailures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, max(maxDelay, c.retryDelay))
+}
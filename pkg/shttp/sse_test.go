@@ -0,0 +1,113 @@
+package shttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.n16f.net/log"
+)
+
+func TestSSEReader(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	stream := "\xef\xbb\xbf" +
+		": comment\n" +
+		"id: 1\nevent: foo\ndata: a\ndata:b\ndata\nunknown: x\n\n" +
+		"retry: 500\nid: 2\n\n" +
+		"data:  c\r\r" +
+		"retry: -1\r\ndata: d\r\n\r\n" +
+		"data: incomplete\n"
+
+	r := NewSSEReader(strings.NewReader(stream))
+
+	event, err := r.ReadEvent()
+	require.NoError(err)
+	require.NotNil(event)
+	assert.Equal(SSEEvent{Id: "1", Type: "foo", Data: "a\nb\n"}, *event)
+
+	// Blocks without data do not produce events but their id and retry
+	// fields are used.
+	event, err = r.ReadEvent()
+	require.NoError(err)
+	require.NotNil(event)
+	assert.Equal(SSEEvent{Id: "2", Data: " c"}, *event)
+	assert.Equal(500, r.RetryDelay)
+
+	event, err = r.ReadEvent()
+	require.NoError(err)
+	require.NotNil(event)
+	assert.Equal(SSEEvent{Id: "2", Data: "d"}, *event)
+	assert.Equal(500, r.RetryDelay)
+
+	event, err = r.ReadEvent()
+	require.NoError(err)
+	assert.Nil(event)
+}
+
+func TestSSEClient(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var lastEventIds []string
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			lastEventId := req.Header.Get("Last-Event-ID")
+			lastEventIds = append(lastEventIds, lastEventId)
+
+			if lastEventId == "4" {
+				w.WriteHeader(204)
+				return
+			}
+
+			var start int
+			fmt.Sscanf(lastEventId, "%d", &start)
+
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "retry: 10\n\n")
+
+			for i := start + 1; i <= start+2; i++ {
+				fmt.Fprintf(w, "id: %d\ndata: {\"value\": %d}\n\n", i, i*10)
+			}
+		}))
+	defer server.Close()
+
+	httpClient, err := NewClient(ClientCfg{Log: log.DefaultLogger("test")})
+	require.NoError(err)
+
+	client, err := NewSSEClient(SSEClientCfg{
+		Client:     httpClient,
+		URI:        server.URL,
+		RetryDelay: 60_000,
+	})
+	require.NoError(err)
+
+	type eventData struct {
+		Value int `json:"value"`
+	}
+
+	var values []int
+	var lastErr error
+
+	events := client.Events(context.Background())
+	for value, err := range SSEJSONEvents[eventData](events) {
+		if err != nil {
+			lastErr = err
+			break
+		}
+
+		values = append(values, value.Value)
+	}
+
+	assert.Equal([]int{10, 20, 30, 40}, values)
+	assert.Equal([]string{"", "2", "4"}, lastEventIds)
+	assert.ErrorIs(lastErr, ErrSSEStreamClosed)
+	assert.Equal("4", client.LastEventId())
+}
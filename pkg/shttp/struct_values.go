@@ -0,0 +1,260 @@
+package shttp
+
+import (
+	"encoding"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.n16f.net/ejson"
+)
+
+// structValueSource associates a struct tag, e.g. "query", with a function
+// returning the values of a name.
+type structValueSource struct {
+	tag    string
+	lookup func(name string) []string
+}
+
+func urlValuesSource(tag string, values url.Values) structValueSource {
+	return structValueSource{
+		tag:    tag,
+		lookup: func(name string) []string { return values[name] },
+	}
+}
+
+// decodeStruct decodes values into the fields of a struct and validates it if
+// it implements ejson.Validatable. Errors are sent as a 400 response with the
+// list of validation errors.
+func (h *Handler) decodeStruct(dest any, fn func(*ejson.Validator) error, errorCode, errorLabel string, sources ...structValueSource) error {
+	v := ejson.NewValidator()
+
+	if err := decodeStructValues(dest, v, sources...); err != nil {
+		h.ReplyInternalError(500, "%v", err)
+		return err
+	}
+
+	// There is no point in validating values which could not be decoded
+	if v.Error() == nil {
+		if obj, ok := dest.(ejson.Validatable); ok {
+			obj.ValidateJSON(v)
+		}
+
+		if fn != nil {
+			if err := fn(v); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := v.Error(); err != nil {
+		h.replyValidationErrors(err.(ejson.ValidationErrors), errorCode,
+			errorLabel)
+		return err
+	}
+
+	return nil
+}
+
+// decodeStructValues decodes string values into the fields of a struct
+// according to struct tags, e.g. `form:"name"`. Fields without any of the
+// tags are ignored, except for embedded structs whose fields are decoded.
+// Fields whose value is missing are left unchanged.
+//
+// Supported field types are strings (including string-based enum types),
+// booleans, integers, floats, durations, types implementing
+// encoding.TextUnmarshaler (e.g. time.Time or uuid.UUID), and pointers and
+// slices of these types. Invalid values are reported as validation errors;
+// the error returned is only set if the destination itself is invalid.
+func decodeStructValues(dest any, v *ejson.Validator, sources ...structValueSource) error {
+	value := reflect.ValueOf(dest)
+	if value.Kind() != reflect.Pointer || value.IsNil() ||
+		value.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("invalid destination of type %T: must be a "+
+			"pointer to a struct", dest)
+	}
+
+	return decodeStruct(value.Elem(), v, sources)
+}
+
+func decodeStruct(value reflect.Value, v *ejson.Validator, sources []structValueSource) error {
+	valueType := value.Type()
+
+	for i := range valueType.NumField() {
+		field := valueType.Field(i)
+		fieldValue := value.Field(i)
+
+		var name string
+		var source structValueSource
+
+		for _, source = range sources {
+			name, _, _ = strings.Cut(field.Tag.Get(source.tag), ",")
+			if name != "" {
+				break
+			}
+		}
+
+		if name == "" {
+			if field.Anonymous && field.Type.Kind() == reflect.Struct {
+				if err := decodeStruct(fieldValue, v, sources); err != nil {
+					return err
+				}
+			}
+
+			continue
+		}
+
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+
+		fieldStrings := source.lookup(name)
+		if len(fieldStrings) == 0 {
+			continue
+		}
+
+		if field.Type.Kind() == reflect.Slice && !isTextUnmarshaler(field.Type) {
+			n := len(fieldStrings)
+			slice := reflect.MakeSlice(field.Type, n, n)
+
+			var err error
+			valid := true
+
+			v.Push(name)
+			for j, s := range fieldStrings {
+				err = decodeStructValue(s, slice.Index(j))
+				if valueErr, ok := err.(*structValueError); ok {
+					v.AddError(j, "invalid_value", "%v", valueErr.err)
+					valid = false
+					err = nil
+				} else if err != nil {
+					break
+				}
+			}
+			v.Pop()
+
+			if err != nil {
+				return fmt.Errorf("cannot decode field %q: %w", field.Name, err)
+			}
+
+			if valid {
+				fieldValue.Set(slice)
+			}
+
+			continue
+		}
+
+		err := decodeStructValue(fieldStrings[0], fieldValue)
+		if valueErr, ok := err.(*structValueError); ok {
+			v.AddError(name, "invalid_value", "%v", valueErr.err)
+		} else if err != nil {
+			return fmt.Errorf("cannot decode field %q: %w", field.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// structValueError indicates that a string could not be decoded, as opposed
+// to a field type which is not supported.
+type structValueError struct {
+	err error
+}
+
+func (err *structValueError) Error() string {
+	return err.err.Error()
+}
+
+var (
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+	durationType        = reflect.TypeFor[time.Duration]()
+)
+
+func isTextUnmarshaler(t reflect.Type) bool {
+	return reflect.PointerTo(t).Implements(textUnmarshalerType)
+}
+
+func decodeStructValue(s string, value reflect.Value) error {
+	valueType := value.Type()
+
+	if isTextUnmarshaler(valueType) {
+		u := value.Addr().Interface().(encoding.TextUnmarshaler)
+		if err := u.UnmarshalText([]byte(s)); err != nil {
+			return &structValueError{err}
+		}
+
+		return nil
+	}
+
+	invalidValue := func(err error) error {
+		if numErr, ok := err.(*strconv.NumError); ok {
+			err = numErr.Err
+		}
+
+		return &structValueError{fmt.Errorf("invalid value %q: %w", s, err)}
+	}
+
+	if valueType == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return &structValueError{fmt.Errorf("invalid duration %q", s)}
+		}
+
+		value.SetInt(int64(d))
+		return nil
+	}
+
+	switch valueType.Kind() {
+	case reflect.Pointer:
+		ptr := reflect.New(valueType.Elem())
+		if err := decodeStructValue(s, ptr.Elem()); err != nil {
+			return err
+		}
+
+		value.Set(ptr)
+
+	case reflect.String:
+		value.SetString(s)
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return invalidValue(err)
+		}
+
+		value.SetBool(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, valueType.Bits())
+		if err != nil {
+			return invalidValue(err)
+		}
+
+		value.SetInt(i)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64:
+		i, err := strconv.ParseUint(s, 10, valueType.Bits())
+		if err != nil {
+			return invalidValue(err)
+		}
+
+		value.SetUint(i)
+
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, valueType.Bits())
+		if err != nil {
+			return invalidValue(err)
+		}
+
+		value.SetFloat(f)
+
+	default:
+		return fmt.Errorf("unsupported type %v", valueType)
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package shttp
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.n16f.net/ejson"
+	"go.n16f.net/uuid"
+)
+
+type testSortOrder string
+
+const (
+	testSortOrderAsc  testSortOrder = "asc"
+	testSortOrderDesc testSortOrder = "desc"
+)
+
+type testPagination struct {
+	Limit int `query:"limit"`
+}
+
+type testRequestParameters struct {
+	testPagination
+
+	Id      uuid.UUID     `path:"id"`
+	Order   testSortOrder `query:"order"`
+	Since   *time.Time    `query:"since"`
+	Timeout time.Duration `query:"timeout"`
+	Verbose bool          `query:"verbose"`
+	Fields  []string      `query:"field"`
+}
+
+func TestRequestParameters(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newTestServer(t, ServerCfg{})
+
+	var params testRequestParameters
+
+	s.Route("/items/{id}", "GET", func(h *Handler) {
+		params = testRequestParameters{Order: testSortOrderAsc}
+		if err := h.RequestParameters(&params); err != nil {
+			return
+		}
+
+		h.ReplyEmpty(204)
+	})
+
+	id := "0193e4a2-7f4b-7c2f-9d5e-2c5b4f1e8a10"
+
+	req := httptest.NewRequest("GET", "/items/"+id+"?limit=10&order=desc"+
+		"&since=2026-01-02T03:04:05Z&timeout=1m30s&verbose=1"+
+		"&field=a&field=b", nil)
+	res := sendTestRequest(s, req)
+
+	if assert.Equal(204, res.StatusCode) {
+		assert.Equal(id, params.Id.String())
+		assert.Equal(10, params.Limit)
+		assert.Equal(testSortOrderDesc, params.Order)
+		if assert.NotNil(params.Since) {
+			assert.Equal(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+				*params.Since)
+		}
+		assert.Equal(90*time.Second, params.Timeout)
+		assert.True(params.Verbose)
+		assert.Equal([]string{"a", "b"}, params.Fields)
+	}
+
+	// Default values are kept
+	req = httptest.NewRequest("GET", "/items/"+id, nil)
+	res = sendTestRequest(s, req)
+
+	if assert.Equal(204, res.StatusCode) {
+		assert.Equal(testSortOrderAsc, params.Order)
+		assert.Nil(params.Since)
+		assert.Nil(params.Fields)
+	}
+}
+
+func TestDecodeStructValues(t *testing.T) {
+	assert := assert.New(t)
+
+	var params testRequestParameters
+
+	source := urlValuesSource("query", map[string][]string{
+		"limit":   {"foo"},
+		"field":   {"a", "b"},
+		"timeout": {"10"},
+	})
+
+	v := ejson.NewValidator()
+
+	err := decodeStructValues(&params, v, source)
+	assert.NoError(err)
+	assert.Equal(0, params.Limit)
+	assert.Equal(time.Duration(0), params.Timeout)
+	assert.Equal([]string{"a", "b"}, params.Fields)
+
+	err = decodeStructValues(params, v, source)
+	assert.Error(err)
+
+	var invalidParams struct {
+		Values map[string]string `query:"limit"`
+	}
+
+	err = decodeStructValues(&invalidParams, v, source)
+	assert.Error(err)
+}
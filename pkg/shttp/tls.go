@@ -0,0 +1,49 @@
+package shttp
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+var TLSVersionValues = []string{"1.2", "1.3"}
+
+func parseTLSVersion(s string) (uint16, error) {
+	switch s {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("invalid or unsupported TLS version %q", s)
+	}
+}
+
+type TLSClientAuthentication string
+
+const (
+	// No client certificate is requested.
+	TLSClientAuthenticationNone TLSClientAuthentication = "none"
+
+	// Client certificates are optional, but they are verified if provided.
+	TLSClientAuthenticationOptional TLSClientAuthentication = "optional"
+
+	// Clients must provide a valid certificate.
+	TLSClientAuthenticationRequired TLSClientAuthentication = "required"
+)
+
+var TLSClientAuthenticationValues = []TLSClientAuthentication{
+	TLSClientAuthenticationNone,
+	TLSClientAuthenticationOptional,
+	TLSClientAuthenticationRequired,
+}
+
+func (a TLSClientAuthentication) clientAuthType() tls.ClientAuthType {
+	switch a {
+	case TLSClientAuthenticationOptional:
+		return tls.VerifyClientCertIfGiven
+	case TLSClientAuthenticationRequired:
+		return tls.RequireAndVerifyClientCert
+	default:
+		return tls.NoClientCert
+	}
+}
@@ -0,0 +1,290 @@
+package shttp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.n16f.net/log"
+)
+
+type testCertificate struct {
+	Certificate *x509.Certificate
+	PrivateKey  *ecdsa.PrivateKey
+}
+
+func generateTestCertificate(t *testing.T, name string, issuer *testCertificate) *testCertificate {
+	t.Helper()
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth,
+		},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+
+	parent := &template
+	signingKey := privateKey
+
+	if issuer == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		parent = issuer.Certificate
+		signingKey = issuer.PrivateKey
+	}
+
+	data, err := x509.CreateCertificate(rand.Reader, &template, parent,
+		&privateKey.PublicKey, signingKey)
+	require.NoError(t, err)
+
+	certificate, err := x509.ParseCertificate(data)
+	require.NoError(t, err)
+
+	return &testCertificate{
+		Certificate: certificate,
+		PrivateKey:  privateKey,
+	}
+}
+
+func (c *testCertificate) writeFiles(t *testing.T, dirPath string) (string, string) {
+	t.Helper()
+
+	certificatePath := path.Join(dirPath, "certificate.pem")
+	privateKeyPath := path.Join(dirPath, "private_key.pem")
+
+	certificateData := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: c.Certificate.Raw,
+	})
+	require.NoError(t, os.WriteFile(certificatePath, certificateData, 0600))
+
+	keyData, err := x509.MarshalECPrivateKey(c.PrivateKey)
+	require.NoError(t, err)
+
+	privateKeyData := pem.EncodeToMemory(&pem.Block{
+		Type:  "EC PRIVATE KEY",
+		Bytes: keyData,
+	})
+	require.NoError(t, os.WriteFile(privateKeyPath, privateKeyData, 0600))
+
+	return certificatePath, privateKeyPath
+}
+
+func (c *testCertificate) tlsCertificate() tls.Certificate {
+	return tls.Certificate{
+		Certificate: [][]byte{c.Certificate.Raw},
+		PrivateKey:  c.PrivateKey,
+		Leaf:        c.Certificate,
+	}
+}
+
+func TestServerClientAuthentication(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	ca := generateTestCertificate(t, "ca", nil)
+	serverCertificate := generateTestCertificate(t, "server", ca)
+	clientCertificate := generateTestCertificate(t, "client", ca)
+
+	dirPath := t.TempDir()
+
+	caPath, _ := ca.writeFiles(t, t.TempDir())
+	certificatePath, privateKeyPath :=
+		serverCertificate.writeFiles(t, dirPath)
+
+	s := newTestServer(t, ServerCfg{
+		Address: "127.0.0.1:0",
+		TLS: &TLSServerCfg{
+			Certificate:          certificatePath,
+			PrivateKey:           privateKeyPath,
+			ClientCACertificates: []string{caPath},
+			MinVersion:           "1.2",
+		},
+	})
+
+	var peerName string
+	s.Route("/", "GET", func(h *Handler) {
+		peerName = h.PeerCertificate.Subject.CommonName
+		h.ReplyEmpty(204)
+	})
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", s.server.TLSConfig)
+	require.NoError(err)
+
+	go s.server.Serve(listener)
+	defer s.server.Close()
+
+	uri := "https://" + listener.Addr().String() + "/"
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(ca.Certificate)
+
+	newClient := func(certificates ...tls.Certificate) *http.Client {
+		return &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					RootCAs:      rootCAs,
+					Certificates: certificates,
+				},
+			},
+		}
+	}
+
+	// Without client certificate
+	_, err = newClient().Get(uri)
+	assert.Error(err)
+
+	// With a valid client certificate
+	res, err := newClient(clientCertificate.tlsCertificate()).Get(uri)
+	if assert.NoError(err) {
+		res.Body.Close()
+		assert.Equal(204, res.StatusCode)
+		assert.Equal("client", peerName)
+	}
+
+	// With a certificate signed by another CA
+	otherCA := generateTestCertificate(t, "other-ca", nil)
+	otherCertificate := generateTestCertificate(t, "other", otherCA)
+
+	_, err = newClient(otherCertificate.tlsCertificate()).Get(uri)
+	assert.Error(err)
+}
+
+func TestCertificateLoader(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	ca := generateTestCertificate(t, "ca", nil)
+	certificate1 := generateTestCertificate(t, "server1", ca)
+	certificate2 := generateTestCertificate(t, "server2", ca)
+
+	dirPath := t.TempDir()
+	certificatePath, privateKeyPath := certificate1.writeFiles(t, dirPath)
+
+	loader, err := newCertificateLoader(certificatePath, privateKeyPath,
+		10*time.Millisecond, log.DefaultLogger("test"))
+	require.NoError(err)
+
+	loader.start()
+	defer loader.stop()
+
+	leafName := func() string {
+		certificate, err := loader.getCertificate(nil)
+		require.NoError(err)
+
+		leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+		require.NoError(err)
+
+		return leaf.Subject.CommonName
+	}
+
+	assert.Equal("server1", leafName())
+
+	// Make sure the modification time changes even on file systems with a
+	// low timestamp resolution.
+	certificate2.writeFiles(t, dirPath)
+	future := time.Now().Add(time.Minute)
+	require.NoError(os.Chtimes(certificatePath, future, future))
+
+	assert.Eventually(func() bool {
+		return leafName() == "server2"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestClientCertificates(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	ca := generateTestCertificate(t, "ca", nil)
+	serverCertificate := generateTestCertificate(t, "server", ca)
+	clientCertificate := generateTestCertificate(t, "client", ca)
+
+	caPath, _ := ca.writeFiles(t, t.TempDir())
+	serverCertificatePath, serverPrivateKeyPath :=
+		serverCertificate.writeFiles(t, t.TempDir())
+	clientCertificatePath, clientPrivateKeyPath :=
+		clientCertificate.writeFiles(t, t.TempDir())
+
+	s := newTestServer(t, ServerCfg{
+		Address: "127.0.0.1:0",
+		TLS: &TLSServerCfg{
+			Certificate:          serverCertificatePath,
+			PrivateKey:           serverPrivateKeyPath,
+			ClientCACertificates: []string{caPath},
+		},
+	})
+
+	s.Route("/", "GET", func(h *Handler) {
+		h.ReplyText(200, h.PeerCertificate.Subject.CommonName)
+	})
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", s.server.TLSConfig)
+	require.NoError(err)
+
+	go s.server.Serve(listener)
+	defer s.server.Close()
+
+	// The server certificate is valid for "localhost" and 127.0.0.1; we
+	// connect using the IP address and override the server name.
+	uri := "https://" + listener.Addr().String() + "/"
+
+	client, err := NewClient(ClientCfg{
+		TLS: &TLSClientCfg{
+			CACertificates: []string{caPath},
+			Certificates: []TLSClientCertificateCfg{{
+				Certificate: clientCertificatePath,
+				PrivateKey:  clientPrivateKeyPath,
+			}},
+			ServerName: "localhost",
+			MinVersion: "1.3",
+		},
+	})
+	require.NoError(err)
+
+	res, err := client.Client.Get(uri)
+	require.NoError(err)
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(err)
+
+	assert.Equal(200, res.StatusCode)
+	assert.Equal("client", string(body))
+
+	// Without client certificate
+	client, err = NewClient(ClientCfg{
+		TLS: &TLSClientCfg{
+			CACertificates: []string{caPath},
+		},
+	})
+	require.NoError(err)
+
+	_, err = client.Client.Get(uri)
+	assert.Error(err)
+}
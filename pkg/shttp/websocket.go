@@ -0,0 +1,740 @@
+package shttp
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"go.n16f.net/ejson"
+)
+
+// Reference: RFC 6455 (The WebSocket Protocol) and RFC 7692 (Compression
+// Extensions for WebSocket).
+
+const (
+	DefaultWebSocketMaxMessageSize = 1_000_000 // bytes
+	DefaultWebSocketPingInterval   = 30        // seconds
+	DefaultWebSocketPongTimeout    = 10        // seconds
+	DefaultWebSocketWriteTimeout   = 10        // seconds
+	DefaultWebSocketCloseTimeout   = 5         // seconds
+)
+
+type WebSocketMessageType int
+
+const (
+	WebSocketMessageTypeText   WebSocketMessageType = 1
+	WebSocketMessageTypeBinary WebSocketMessageType = 2
+)
+
+func (t WebSocketMessageType) String() string {
+	switch t {
+	case WebSocketMessageTypeText:
+		return "text"
+	case WebSocketMessageTypeBinary:
+		return "binary"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(t))
+	}
+}
+
+const (
+	wsOpcodeContinuation = 0x0
+	wsOpcodeText         = 0x1
+	wsOpcodeBinary       = 0x2
+	wsOpcodeClose        = 0x8
+	wsOpcodePing         = 0x9
+	wsOpcodePong         = 0xa
+)
+
+// Status codes used in close frames (RFC 6455 7.4.1).
+const (
+	WebSocketCloseNormal           = 1000
+	WebSocketCloseGoingAway        = 1001
+	WebSocketCloseProtocolError    = 1002
+	WebSocketCloseUnsupportedData  = 1003
+	WebSocketCloseNoStatus         = 1005
+	WebSocketCloseAbnormal         = 1006
+	WebSocketCloseInvalidPayload   = 1007
+	WebSocketClosePolicyViolation  = 1008
+	WebSocketCloseMessageTooBig    = 1009
+	WebSocketCloseMissingExtension = 1010
+	WebSocketCloseInternalError    = 1011
+)
+
+// WebSocketCloseError is returned when the connection was closed with a close
+// frame, either by the peer or after a protocol error.
+type WebSocketCloseError struct {
+	Code   int
+	Reason string
+}
+
+func (err *WebSocketCloseError) Error() string {
+	if err.Reason == "" {
+		return fmt.Sprintf("websocket closed with status %d", err.Code)
+	}
+
+	return fmt.Sprintf("websocket closed with status %d: %s", err.Code,
+		err.Reason)
+}
+
+var ErrWebSocketClosed = errors.New("websocket connection closed")
+
+type WebSocketCfg struct {
+	// Subprotocols supported by the server by order of preference, or
+	// requested by the client.
+	Subprotocols []string `json:"subprotocols"`
+
+	// Origins allowed to open connections on the server. If the list is
+	// empty, browsers can only connect from the same origin as the server.
+	// Requests without an Origin header field are always accepted since
+	// they are not sent by browsers.
+	AllowedOrigins []string `json:"allowed_origins"`
+
+	// Enable the permessage-deflate extension if the peer supports it.
+	Compression bool `json:"compression"`
+
+	MaxMessageSize int64 `json:"max_message_size"` // bytes
+
+	// Pings are sent periodically so that dead connections are detected
+	// and so that intermediaries do not close idle connections. A negative
+	// interval disables pings.
+	PingInterval int `json:"ping_interval"` // seconds
+	PongTimeout  int `json:"pong_timeout"`  // seconds
+
+	WriteTimeout int `json:"write_timeout"` // seconds
+	CloseTimeout int `json:"close_timeout"` // seconds
+}
+
+func (cfg *WebSocketCfg) ValidateJSON(v *ejson.Validator) {
+	v.WithChild("subprotocols", func() {
+		for i, subprotocol := range cfg.Subprotocols {
+			v.CheckStringNotEmpty(i, subprotocol)
+		}
+	})
+
+	v.WithChild("allowed_origins", func() {
+		for i, origin := range cfg.AllowedOrigins {
+			v.CheckStringNotEmpty(i, origin)
+		}
+	})
+
+	v.Check("max_message_size", cfg.MaxMessageSize >= 0, "invalid_value",
+		"maximum message size must be positive")
+	v.CheckIntMin("pong_timeout", cfg.PongTimeout, 0)
+	v.CheckIntMin("write_timeout", cfg.WriteTimeout, 0)
+	v.CheckIntMin("close_timeout", cfg.CloseTimeout, 0)
+}
+
+func (cfg *WebSocketCfg) setDefaults() {
+	if cfg.MaxMessageSize == 0 {
+		cfg.MaxMessageSize = DefaultWebSocketMaxMessageSize
+	}
+
+	if cfg.PingInterval == 0 {
+		cfg.PingInterval = DefaultWebSocketPingInterval
+	}
+
+	if cfg.PongTimeout == 0 {
+		cfg.PongTimeout = DefaultWebSocketPongTimeout
+	}
+
+	if cfg.WriteTimeout == 0 {
+		cfg.WriteTimeout = DefaultWebSocketWriteTimeout
+	}
+
+	if cfg.CloseTimeout == 0 {
+		cfg.CloseTimeout = DefaultWebSocketCloseTimeout
+	}
+}
+
+// WebSocketConn is a WebSocket connection. Messages must be read by a single
+// goroutine; messages can be written by multiple goroutines.
+type WebSocketConn struct {
+	Cfg WebSocketCfg
+
+	// The subprotocol selected during the handshake, if any.
+	Subprotocol string
+
+	conn   net.Conn
+	br     *bufio.Reader
+	client bool
+
+	compression bool
+
+	readLock sync.Mutex
+
+	// Read state, only accessed by the reader
+	messageOpcode int
+	messageData   []byte
+	compressed    bool
+	closeDeadline time.Time
+
+	writeLock sync.Mutex
+	closeSent bool
+
+	closeOnce sync.Once
+	closeChan chan struct{}
+	wg        sync.WaitGroup
+}
+
+func newWebSocketConn(conn net.Conn, br *bufio.Reader, cfg WebSocketCfg, client, compression bool) *WebSocketConn {
+	c := WebSocketConn{
+		Cfg: cfg,
+
+		conn:   conn,
+		br:     br,
+		client: client,
+
+		compression: compression,
+
+		closeChan: make(chan struct{}),
+	}
+
+	if cfg.PingInterval > 0 {
+		c.wg.Add(1)
+		go c.keepalive()
+	}
+
+	return &c
+}
+
+func (c *WebSocketConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *WebSocketConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// ReadMessage returns the next data message. Control frames are handled
+// transparently. If the peer closes the connection, the error is a
+// *WebSocketCloseError.
+func (c *WebSocketConn) ReadMessage() (WebSocketMessageType, []byte, error) {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+
+	for {
+		msgType, data, err := c.readFrame()
+		if err != nil {
+			return 0, nil, c.readError(err)
+		}
+
+		if data != nil {
+			return msgType, data, nil
+		}
+	}
+}
+
+func (c *WebSocketConn) ReadJSON(dest any) error {
+	_, data, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, dest); err != nil {
+		return fmt.Errorf("cannot decode JSON message: %w", err)
+	}
+
+	return nil
+}
+
+func (c *WebSocketConn) WriteMessage(msgType WebSocketMessageType, data []byte) error {
+	var opcode int
+
+	switch msgType {
+	case WebSocketMessageTypeText:
+		opcode = wsOpcodeText
+	case WebSocketMessageTypeBinary:
+		opcode = wsOpcodeBinary
+	default:
+		return fmt.Errorf("invalid message type %v", msgType)
+	}
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	if c.closeSent {
+		return ErrWebSocketClosed
+	}
+
+	compressed := false
+	if c.compression && len(data) > 0 {
+		compressedData, err := wsCompress(data)
+		if err != nil {
+			return fmt.Errorf("cannot compress message: %w", err)
+		}
+
+		data = compressedData
+		compressed = true
+	}
+
+	return c.writeFrame(opcode, compressed, data)
+}
+
+func (c *WebSocketConn) WriteText(s string) error {
+	return c.WriteMessage(WebSocketMessageTypeText, []byte(s))
+}
+
+func (c *WebSocketConn) WriteJSON(value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("cannot encode JSON message: %w", err)
+	}
+
+	return c.WriteMessage(WebSocketMessageTypeText, data)
+}
+
+// Ping sends a ping frame. Pong frames are processed when messages are read.
+func (c *WebSocketConn) Ping(data []byte) error {
+	if len(data) > 125 {
+		return fmt.Errorf("ping payload too large")
+	}
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	if c.closeSent {
+		return ErrWebSocketClosed
+	}
+
+	return c.writeFrame(wsOpcodePing, false, data)
+}
+
+// Close performs the close handshake: it sends a close frame and waits for
+// the peer to reply before closing the connection. If another goroutine is
+// reading messages, it will receive the reply of the peer.
+func (c *WebSocketConn) Close(code int, reason string) error {
+	if err := c.sendClose(code, reason); err != nil {
+		c.closeConn()
+		return err
+	}
+
+	timeout := time.Duration(c.Cfg.CloseTimeout) * time.Second
+
+	if c.readLock.TryLock() {
+		// The deadline is absolute so that the peer cannot delay the end of
+		// the handshake by sending frames.
+		c.closeDeadline = time.Now().Add(timeout)
+		c.conn.SetReadDeadline(c.closeDeadline)
+
+		for {
+			if _, _, err := c.readFrame(); err != nil {
+				break
+			}
+		}
+
+		c.readLock.Unlock()
+	} else {
+		select {
+		case <-c.closeChan:
+		case <-time.After(timeout):
+		}
+	}
+
+	c.closeConn()
+	return nil
+}
+
+func (c *WebSocketConn) sendClose(code int, reason string) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	if c.closeSent {
+		return nil
+	}
+
+	c.closeSent = true
+
+	var payload []byte
+	if code != WebSocketCloseNoStatus {
+		payload = binary.BigEndian.AppendUint16(nil, uint16(code))
+		payload = append(payload, reason...)
+
+		if len(payload) > 125 {
+			payload = payload[:125]
+		}
+	}
+
+	return c.writeFrame(wsOpcodeClose, false, payload)
+}
+
+func (c *WebSocketConn) closeConn() {
+	c.closeOnce.Do(func() {
+		close(c.closeChan)
+		c.conn.Close()
+	})
+
+	c.wg.Wait()
+}
+
+func (c *WebSocketConn) keepalive() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(time.Duration(c.Cfg.PingInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closeChan:
+			return
+
+		case <-ticker.C:
+			if err := c.Ping(nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readError closes the connection after a read error, sending a close frame
+// if the error is a protocol error.
+func (c *WebSocketConn) readError(err error) error {
+	var closeErr *WebSocketCloseError
+	if errors.As(err, &closeErr) {
+		// Either the peer closed the connection, in which case we already
+		// replied, or we detected an error and must report it.
+		c.sendClose(closeErr.Code, closeErr.Reason)
+	}
+
+	c.closeOnce.Do(func() {
+		close(c.closeChan)
+		c.conn.Close()
+	})
+
+	return err
+}
+
+// readFrame reads a frame and returns the data of the current message if the
+// frame completed it.
+func (c *WebSocketConn) readFrame() (WebSocketMessageType, []byte, error) {
+	if c.Cfg.PingInterval > 0 && c.closeDeadline.IsZero() {
+		interval := time.Duration(c.Cfg.PingInterval) * time.Second
+		timeout := time.Duration(c.Cfg.PongTimeout) * time.Second
+
+		c.conn.SetReadDeadline(time.Now().Add(interval + timeout))
+	}
+
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	fin := header[0]&0x80 != 0
+	rsv1 := header[0]&0x40 != 0
+	opcode := int(header[0] & 0x0f)
+	masked := header[1]&0x80 != 0
+	length := int64(header[1] & 0x7f)
+
+	protocolError := func(format string, args ...any) error {
+		return &WebSocketCloseError{
+			Code:   WebSocketCloseProtocolError,
+			Reason: fmt.Sprintf(format, args...),
+		}
+	}
+
+	if header[0]&0x30 != 0 {
+		return 0, nil, protocolError("unexpected reserved bits")
+	}
+
+	// Clients must mask all frames; servers must not mask any.
+	if masked == c.client {
+		return 0, nil, protocolError("invalid frame masking")
+	}
+
+	switch length {
+	case 126:
+		var buf [2]byte
+		if _, err := io.ReadFull(c.br, buf[:]); err != nil {
+			return 0, nil, err
+		}
+
+		length = int64(binary.BigEndian.Uint16(buf[:]))
+
+	case 127:
+		var buf [8]byte
+		if _, err := io.ReadFull(c.br, buf[:]); err != nil {
+			return 0, nil, err
+		}
+
+		length = int64(binary.BigEndian.Uint64(buf[:]))
+		if length < 0 {
+			return 0, nil, protocolError("invalid frame length")
+		}
+	}
+
+	isControl := opcode&0x8 != 0
+
+	if isControl {
+		if !fin || length > 125 {
+			return 0, nil, protocolError("invalid control frame")
+		}
+
+		if rsv1 {
+			return 0, nil, protocolError("compressed control frame")
+		}
+	} else {
+		// Frame lengths are controlled by the peer and can be close to the
+		// maximum int64 value, so we must not add them.
+		if length > c.Cfg.MaxMessageSize-int64(len(c.messageData)) {
+			return 0, nil, &WebSocketCloseError{
+				Code:   WebSocketCloseMessageTooBig,
+				Reason: "message too large",
+			}
+		}
+	}
+
+	var maskKey [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, maskKey[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return 0, nil, err
+	}
+
+	if masked {
+		wsMask(payload, maskKey)
+	}
+
+	switch opcode {
+	case wsOpcodePing:
+		c.writeLock.Lock()
+		defer c.writeLock.Unlock()
+
+		if !c.closeSent {
+			if err := c.writeFrame(wsOpcodePong, false, payload); err != nil {
+				return 0, nil, err
+			}
+		}
+
+		return 0, nil, nil
+
+	case wsOpcodePong:
+		return 0, nil, nil
+
+	case wsOpcodeClose:
+		return 0, nil, c.parseClose(payload)
+
+	case wsOpcodeText, wsOpcodeBinary:
+		if c.messageOpcode != 0 {
+			return 0, nil, protocolError("unexpected data frame")
+		}
+
+		if rsv1 && !c.compression {
+			return 0, nil, protocolError("unexpected compressed frame")
+		}
+
+		c.messageOpcode = opcode
+		c.compressed = rsv1
+
+	case wsOpcodeContinuation:
+		if c.messageOpcode == 0 {
+			return 0, nil, protocolError("unexpected continuation frame")
+		}
+
+		if rsv1 {
+			return 0, nil, protocolError("invalid continuation frame")
+		}
+
+	default:
+		return 0, nil, protocolError("unknown opcode %d", opcode)
+	}
+
+	c.messageData = append(c.messageData, payload...)
+
+	if !fin {
+		return 0, nil, nil
+	}
+
+	msgType := WebSocketMessageType(c.messageOpcode)
+	data := c.messageData
+	compressed := c.compressed
+
+	c.messageOpcode = 0
+	c.messageData = nil
+	c.compressed = false
+
+	if compressed {
+		var err error
+		data, err = wsDecompress(data, c.Cfg.MaxMessageSize)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	if msgType == WebSocketMessageTypeText && !utf8.Valid(data) {
+		return 0, nil, &WebSocketCloseError{
+			Code:   WebSocketCloseInvalidPayload,
+			Reason: "invalid UTF-8 text",
+		}
+	}
+
+	if data == nil {
+		data = []byte{}
+	}
+
+	return msgType, data, nil
+}
+
+func (c *WebSocketConn) parseClose(payload []byte) error {
+	closeErr := WebSocketCloseError{Code: WebSocketCloseNoStatus}
+
+	if len(payload) == 1 {
+		closeErr.Code = WebSocketCloseProtocolError
+		closeErr.Reason = "invalid close frame"
+		return &closeErr
+	}
+
+	if len(payload) >= 2 {
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+
+		if !validWebSocketCloseCode(closeErr.Code) {
+			closeErr.Code = WebSocketCloseProtocolError
+			closeErr.Reason = "invalid close status code"
+			return &closeErr
+		}
+
+		if !utf8.ValidString(closeErr.Reason) {
+			closeErr.Code = WebSocketCloseInvalidPayload
+			closeErr.Reason = "invalid UTF-8 close reason"
+			return &closeErr
+		}
+	}
+
+	// Echo the status code as mandated by RFC 6455 5.5.1
+	c.sendClose(closeErr.Code, "")
+
+	return &closeErr
+}
+
+// validWebSocketCloseCode reports whether a status code can be sent in a
+// close frame (RFC 6455 7.4). Codes 1004, 1005, 1006 and 1015 are reserved
+// and must not be sent; codes 1016 to 2999 are not assigned yet; codes 3000
+// to 4999 are available to libraries, frameworks and applications.
+func validWebSocketCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003:
+		return true
+	case code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *WebSocketConn) writeFrame(opcode int, compressed bool, payload []byte) error {
+	var buf bytes.Buffer
+
+	b0 := byte(0x80 | opcode)
+	if compressed {
+		b0 |= 0x40
+	}
+
+	buf.WriteByte(b0)
+
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+
+	switch length := len(payload); {
+	case length <= 125:
+		buf.WriteByte(maskBit | byte(length))
+	case length <= 0xffff:
+		buf.WriteByte(maskBit | 126)
+		binary.Write(&buf, binary.BigEndian, uint16(length))
+	default:
+		buf.WriteByte(maskBit | 127)
+		binary.Write(&buf, binary.BigEndian, uint64(length))
+	}
+
+	if c.client {
+		var maskKey [4]byte
+		rand.Read(maskKey[:])
+
+		buf.Write(maskKey[:])
+
+		start := buf.Len()
+		buf.Write(payload)
+		wsMask(buf.Bytes()[start:], maskKey)
+	} else {
+		buf.Write(payload)
+	}
+
+	timeout := time.Duration(c.Cfg.WriteTimeout) * time.Second
+	c.conn.SetWriteDeadline(time.Now().Add(timeout))
+
+	_, err := c.conn.Write(buf.Bytes())
+	return err
+}
+
+func wsMask(data []byte, key [4]byte) {
+	for i := range data {
+		data[i] ^= key[i%4]
+	}
+}
+
+// With permessage-deflate, each message is compressed independently since we
+// negotiate the absence of context takeover in both directions. The final
+// empty block marker is removed from compressed data and added back before
+// decompression (RFC 7692 7.2).
+
+var wsDeflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+func wsCompress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), wsDeflateTail), nil
+}
+
+func wsDecompress(data []byte, maxSize int64) ([]byte, error) {
+	r := flate.NewReader(io.MultiReader(bytes.NewReader(data),
+		bytes.NewReader(wsDeflateTail)))
+	defer r.Close()
+
+	decompressedData, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, &WebSocketCloseError{
+			Code:   WebSocketCloseInvalidPayload,
+			Reason: "invalid compressed data",
+		}
+	}
+
+	if int64(len(decompressedData)) > maxSize {
+		return nil, &WebSocketCloseError{
+			Code:   WebSocketCloseMessageTooBig,
+			Reason: "message too large",
+		}
+	}
+
+	return decompressedData, nil
+}
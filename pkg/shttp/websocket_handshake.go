@@ -0,0 +1,422 @@
+package shttp
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const wsDeflateExtension = "permessage-deflate; server_no_context_takeover; " +
+	"client_no_context_takeover"
+
+// UpgradeWebSocket performs the opening handshake of a WebSocket connection
+// using the WebSocket configuration of the server. If the handshake fails, an
+// error response is sent and the error is returned.
+//
+// The connection is hijacked so the write timeout of the server does not
+// apply to it. The handler must close the connection when it is done.
+func (h *Handler) UpgradeWebSocket() (*WebSocketConn, error) {
+	var cfg WebSocketCfg
+	if h.Server.Cfg.WebSocket != nil {
+		cfg = *h.Server.Cfg.WebSocket
+	}
+
+	return h.UpgradeWebSocketWithCfg(cfg)
+}
+
+func (h *Handler) UpgradeWebSocketWithCfg(cfg WebSocketCfg) (*WebSocketConn, error) {
+	cfg.setDefaults()
+
+	req := h.Request
+	header := req.Header
+
+	if req.Method != "GET" {
+		h.ReplyError(405, "invalid_websocket_handshake",
+			"websocket connections must use the GET method")
+		return nil, fmt.Errorf("invalid method %q", req.Method)
+	}
+
+	if !headerContainsToken(header, "Connection", "upgrade") ||
+		!headerContainsToken(header, "Upgrade", "websocket") {
+		h.ResponseWriter.Header().Set("Upgrade", "websocket")
+		h.ReplyError(426, "invalid_websocket_handshake",
+			"request is not a websocket upgrade request")
+		return nil, fmt.Errorf("missing upgrade header fields")
+	}
+
+	if version := header.Get("Sec-WebSocket-Version"); version != "13" {
+		h.ResponseWriter.Header().Set("Sec-WebSocket-Version", "13")
+		h.ReplyError(426, "unsupported_websocket_version",
+			"unsupported websocket version %q", version)
+		return nil, fmt.Errorf("unsupported websocket version %q", version)
+	}
+
+	key := header.Get("Sec-WebSocket-Key")
+	if decodedKey, err := base64.StdEncoding.DecodeString(key); err != nil ||
+		len(decodedKey) != 16 {
+		h.ReplyError(400, "invalid_websocket_handshake",
+			"invalid Sec-WebSocket-Key header field")
+		return nil, fmt.Errorf("invalid websocket key %q", key)
+	}
+
+	if origin := header.Get("Origin"); origin != "" {
+		if !webSocketOriginAllowed(origin, req.Host, cfg.AllowedOrigins) {
+			h.ReplyError(403, "forbidden_websocket_origin",
+				"origin %q is not allowed", origin)
+			return nil, fmt.Errorf("forbidden origin %q", origin)
+		}
+	}
+
+	var subprotocol string
+	clientSubprotocols := headerTokens(header, "Sec-WebSocket-Protocol")
+	for _, s := range cfg.Subprotocols {
+		if slices.Contains(clientSubprotocols, s) {
+			subprotocol = s
+			break
+		}
+	}
+
+	compression := cfg.Compression && webSocketDeflateOffered(header)
+
+	rw, ok := h.ResponseWriter.(*ResponseWriter)
+	if !ok {
+		h.ReplyInternalError(500, "response writer does not support "+
+			"connection hijacking")
+		return nil, fmt.Errorf("invalid response writer %T", h.ResponseWriter)
+	}
+
+	// Hooks can add header fields, e.g. session cookies, which must be part
+	// of the handshake response.
+	rw.runBeforeWriteHeader()
+
+	conn, brw, err := rw.Hijack()
+	if err != nil {
+		h.ReplyInternalError(500, "cannot hijack connection: %v", err)
+		return nil, fmt.Errorf("cannot hijack connection: %w", err)
+	}
+
+	// The deadlines set by the HTTP server must not apply to the WebSocket
+	// connection.
+	conn.SetDeadline(time.Time{})
+
+	rw.Status = 101
+
+	resHeader := rw.Header()
+	resHeader.Del("Content-Type")
+	resHeader.Set("Upgrade", "websocket")
+	resHeader.Set("Connection", "Upgrade")
+	resHeader.Set("Sec-WebSocket-Accept", webSocketAcceptKey(key))
+	if subprotocol != "" {
+		resHeader.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+	if compression {
+		resHeader.Set("Sec-WebSocket-Extensions", wsDeflateExtension)
+	}
+
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	resHeader.Write(brw)
+	brw.WriteString("\r\n")
+
+	conn.SetWriteDeadline(time.Now().Add(
+		time.Duration(cfg.WriteTimeout) * time.Second))
+
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("cannot send handshake response: %w", err)
+	}
+
+	wsConn := newWebSocketConn(conn, brw.Reader, cfg, false, compression)
+	wsConn.Subprotocol = subprotocol
+
+	return wsConn, nil
+}
+
+// DialWebSocket opens a WebSocket connection using the dialer and TLS
+// configuration of the client. The URI can use either the ws/wss or the
+// http/https schemes. The header, if not nil, is sent with the handshake
+// request.
+func (c *Client) DialWebSocket(ctx context.Context, uri string, header http.Header, cfg WebSocketCfg) (*WebSocketConn, error) {
+	cfg.setDefaults()
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse URI: %w", err)
+	}
+
+	var useTLS bool
+
+	switch u.Scheme {
+	case "ws", "http":
+		u.Scheme = "http"
+	case "wss", "https":
+		u.Scheme = "https"
+		useTLS = true
+	default:
+		return nil, fmt.Errorf("invalid URI scheme %q", u.Scheme)
+	}
+
+	address := u.Host
+	if u.Port() == "" {
+		if useTLS {
+			address = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			address = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+
+	var conn net.Conn
+
+	if useTLS {
+		tlsCfg := c.tlsCfg.Clone()
+		tlsCfg.NextProtos = []string{"http/1.1"}
+		if tlsCfg.ServerName == "" {
+			tlsCfg.ServerName = u.Hostname()
+		}
+
+		dialer := tls.Dialer{
+			NetDialer: c.dialer,
+			Config:    tlsCfg,
+		}
+
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	} else {
+		conn, err = c.dialer.DialContext(ctx, "tcp", address)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to %s: %w", address, err)
+	}
+
+	wsConn, err := c.webSocketHandshake(ctx, conn, u, header, cfg)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return wsConn, nil
+}
+
+func (c *Client) webSocketHandshake(ctx context.Context, conn net.Conn, u *url.URL, header http.Header, cfg WebSocketCfg) (*WebSocketConn, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else if c.Client.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.Client.Timeout))
+	}
+
+	// Interrupt the handshake if the context is canceled
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	var keyData [16]byte
+	rand.Read(keyData[:])
+	key := base64.StdEncoding.EncodeToString(keyData[:])
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create request: %w", err)
+	}
+
+	for name, values := range header {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+
+	if len(cfg.Subprotocols) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol",
+			strings.Join(cfg.Subprotocols, ", "))
+	}
+
+	if cfg.Compression {
+		req.Header.Set("Sec-WebSocket-Extensions", wsDeflateExtension)
+	}
+
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("cannot send handshake request: %w", err)
+	}
+
+	br := bufio.NewReader(conn)
+
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		return nil, fmt.Errorf("cannot read handshake response: %w", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != 101 {
+		return nil, fmt.Errorf("handshake failed with status %d",
+			res.StatusCode)
+	}
+
+	if !headerContainsToken(res.Header, "Connection", "upgrade") ||
+		!headerContainsToken(res.Header, "Upgrade", "websocket") {
+		return nil, fmt.Errorf("missing upgrade header fields in handshake " +
+			"response")
+	}
+
+	if res.Header.Get("Sec-WebSocket-Accept") != webSocketAcceptKey(key) {
+		return nil, fmt.Errorf("invalid Sec-WebSocket-Accept header field")
+	}
+
+	subprotocol := res.Header.Get("Sec-WebSocket-Protocol")
+	if subprotocol != "" && !slices.Contains(cfg.Subprotocols, subprotocol) {
+		return nil, fmt.Errorf("unexpected subprotocol %q", subprotocol)
+	}
+
+	var compression bool
+
+	if extensions := res.Header.Get("Sec-WebSocket-Extensions"); extensions != "" {
+		name, params, err := parseWebSocketExtension(extensions)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Sec-WebSocket-Extensions header "+
+				"field: %w", err)
+		}
+
+		if !cfg.Compression || name != "permessage-deflate" {
+			return nil, fmt.Errorf("unexpected extension %q", name)
+		}
+
+		// We only support decompressing messages independently of each
+		// other.
+		if _, found := params["server_no_context_takeover"]; !found {
+			return nil, fmt.Errorf("missing server_no_context_takeover " +
+				"extension parameter")
+		}
+
+		compression = true
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	wsConn := newWebSocketConn(conn, br, cfg, true, compression)
+	wsConn.Subprotocol = subprotocol
+
+	return wsConn, nil
+}
+
+func webSocketAcceptKey(key string) string {
+	hash := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func webSocketOriginAllowed(origin, host string, allowedOrigins []string) bool {
+	if len(allowedOrigins) > 0 {
+		for _, allowedOrigin := range allowedOrigins {
+			if allowedOrigin == "*" || strings.EqualFold(allowedOrigin, origin) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(u.Host, host)
+}
+
+// webSocketDeflateOffered checks whether the client offered the
+// permessage-deflate extension with parameters compatible with our
+// implementation, i.e. without any context takeover and with the default
+// window size.
+func webSocketDeflateOffered(header http.Header) bool {
+	for _, value := range header.Values("Sec-WebSocket-Extensions") {
+		for offer := range strings.SplitSeq(value, ",") {
+			name, params, err := parseWebSocketExtension(offer)
+			if err != nil || name != "permessage-deflate" {
+				continue
+			}
+
+			compatible := true
+
+			for name, value := range params {
+				switch name {
+				case "server_no_context_takeover",
+					"client_no_context_takeover",
+					"client_max_window_bits":
+				case "server_max_window_bits":
+					compatible = compatible && value == "15"
+				default:
+					compatible = false
+				}
+			}
+
+			if compatible {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func parseWebSocketExtension(s string) (string, map[string]string, error) {
+	parts := strings.Split(s, ";")
+
+	name := strings.TrimSpace(parts[0])
+	if name == "" {
+		return "", nil, fmt.Errorf("empty extension name")
+	}
+
+	params := make(map[string]string)
+
+	for _, part := range parts[1:] {
+		key, value, _ := strings.Cut(part, "=")
+
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return "", nil, fmt.Errorf("empty extension parameter name")
+		}
+
+		params[key] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+
+	return name, params, nil
+}
+
+// headerTokens returns the elements of comma-separated header fields.
+func headerTokens(header http.Header, name string) []string {
+	var tokens []string
+
+	for _, value := range header.Values(name) {
+		for token := range strings.SplitSeq(value, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+
+	return tokens
+}
+
+func headerContainsToken(header http.Header, name, token string) bool {
+	return slices.ContainsFunc(headerTokens(header, name), func(s string) bool {
+		return strings.EqualFold(s, token)
+	})
+}
@@ -0,0 +1,265 @@
+package shttp
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestWebSocketServer(t *testing.T, cfg WebSocketCfg) string {
+	t.Helper()
+
+	s := newTestServer(t, ServerCfg{WebSocket: &cfg})
+
+	s.Route("/echo", "GET", func(h *Handler) {
+		conn, err := h.UpgradeWebSocket()
+		if err != nil {
+			return
+		}
+
+		for {
+			msgType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			if string(data) == "close" {
+				conn.Close(WebSocketCloseNormal, "bye")
+				return
+			}
+
+			if err := conn.WriteMessage(msgType, data); err != nil {
+				return
+			}
+		}
+	})
+
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "/echo"
+}
+
+func newTestWebSocketClient(t *testing.T) *Client {
+	t.Helper()
+
+	client, err := NewClient(ClientCfg{})
+	require.NoError(t, err)
+
+	return client
+}
+
+func TestWebSocket(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	uri := newTestWebSocketServer(t, WebSocketCfg{
+		Subprotocols:   []string{"test"},
+		MaxMessageSize: 1000,
+	})
+
+	client := newTestWebSocketClient(t)
+	ctx := context.Background()
+
+	conn, err := client.DialWebSocket(ctx, uri, nil, WebSocketCfg{
+		Subprotocols: []string{"other", "test"},
+	})
+	require.NoError(err)
+
+	assert.Equal("test", conn.Subprotocol)
+
+	// Text, binary and JSON messages
+	require.NoError(conn.WriteText("hello"))
+	msgType, data, err := conn.ReadMessage()
+	require.NoError(err)
+	assert.Equal(WebSocketMessageTypeText, msgType)
+	assert.Equal("hello", string(data))
+
+	require.NoError(conn.WriteMessage(WebSocketMessageTypeBinary,
+		[]byte{0, 1, 2}))
+	msgType, data, err = conn.ReadMessage()
+	require.NoError(err)
+	assert.Equal(WebSocketMessageTypeBinary, msgType)
+	assert.Equal([]byte{0, 1, 2}, data)
+
+	require.NoError(conn.WriteJSON(map[string]int{"a": 1}))
+	var value map[string]int
+	require.NoError(conn.ReadJSON(&value))
+	assert.Equal(map[string]int{"a": 1}, value)
+
+	require.NoError(conn.Ping([]byte("ping")))
+
+	// Close handshake initiated by the server
+	require.NoError(conn.WriteText("close"))
+	_, _, err = conn.ReadMessage()
+
+	var closeErr *WebSocketCloseError
+	if assert.ErrorAs(err, &closeErr) {
+		assert.Equal(WebSocketCloseNormal, closeErr.Code)
+		assert.Equal("bye", closeErr.Reason)
+	}
+
+	// Message size limit
+	conn, err = client.DialWebSocket(ctx, uri, nil, WebSocketCfg{})
+	require.NoError(err)
+
+	require.NoError(conn.WriteText(strings.Repeat("a", 2000)))
+	_, _, err = conn.ReadMessage()
+	if assert.ErrorAs(err, &closeErr) {
+		assert.Equal(WebSocketCloseMessageTooBig, closeErr.Code)
+	}
+
+	// Fragmented message whose last frame announces a length close to the
+	// maximum int64 value
+	conn, err = client.DialWebSocket(ctx, uri, nil, WebSocketCfg{})
+	require.NoError(err)
+
+	var maskKey [4]byte
+
+	var frames []byte
+	frames = append(frames, 0x01, 0x80|5)
+	frames = append(frames, maskKey[:]...)
+	frames = append(frames, "hello"...)
+	frames = append(frames, 0x80, 0x80|127)
+	frames = binary.BigEndian.AppendUint64(frames, math.MaxInt64)
+	frames = append(frames, maskKey[:]...)
+
+	conn.writeLock.Lock()
+	_, err = conn.conn.Write(frames)
+	conn.writeLock.Unlock()
+	require.NoError(err)
+
+	_, _, err = conn.ReadMessage()
+	if assert.ErrorAs(err, &closeErr) {
+		assert.Equal(WebSocketCloseMessageTooBig, closeErr.Code)
+	}
+
+	// Invalid close status codes
+	for _, code := range []int{999, 1004, 1005, 1006, 1015, 1016, 2999, 5000} {
+		conn, err = client.DialWebSocket(ctx, uri, nil, WebSocketCfg{})
+		require.NoError(err)
+
+		frame := []byte{0x88, 0x80 | 2}
+		frame = append(frame, maskKey[:]...)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(code))
+
+		conn.writeLock.Lock()
+		_, err = conn.conn.Write(frame)
+		conn.writeLock.Unlock()
+		require.NoError(err)
+
+		_, _, err = conn.ReadMessage()
+		if assert.ErrorAs(err, &closeErr, "code %d", code) {
+			assert.Equal(WebSocketCloseProtocolError, closeErr.Code,
+				"code %d", code)
+		}
+	}
+
+	// Close handshake initiated by the client
+	conn, err = client.DialWebSocket(ctx, uri, nil, WebSocketCfg{})
+	require.NoError(err)
+	assert.NoError(conn.Close(WebSocketCloseNormal, ""))
+}
+
+func TestWebSocketCloseTimeout(t *testing.T) {
+	cfg := WebSocketCfg{CloseTimeout: 1}
+	cfg.setDefaults()
+
+	closeConn := func(sendFrames bool) time.Duration {
+		conn, peerConn := net.Pipe()
+		defer peerConn.Close()
+
+		c := newWebSocketConn(conn, bufio.NewReader(conn), cfg, false, false)
+
+		// The peer never answers the close frame
+		go io.Copy(io.Discard, peerConn)
+
+		if sendFrames {
+			go func() {
+				var maskKey [4]byte
+				frame := append([]byte{0x89, 0x80}, maskKey[:]...)
+
+				for {
+					if _, err := peerConn.Write(frame); err != nil {
+						return
+					}
+
+					time.Sleep(100 * time.Millisecond)
+				}
+			}()
+		}
+
+		start := time.Now()
+		assert.NoError(t, c.Close(WebSocketCloseNormal, ""))
+		return time.Since(start)
+	}
+
+	assert.Less(t, closeConn(false), 3*time.Second)
+	assert.Less(t, closeConn(true), 3*time.Second)
+}
+
+func TestWebSocketCompression(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	uri := newTestWebSocketServer(t, WebSocketCfg{Compression: true})
+
+	client := newTestWebSocketClient(t)
+
+	conn, err := client.DialWebSocket(context.Background(), uri, nil,
+		WebSocketCfg{Compression: true})
+	require.NoError(err)
+	defer conn.Close(WebSocketCloseNormal, "")
+
+	assert.True(conn.compression)
+
+	for _, s := range []string{"", "hello", strings.Repeat("abc", 10_000)} {
+		require.NoError(conn.WriteText(s))
+
+		_, data, err := conn.ReadMessage()
+		require.NoError(err)
+		assert.Equal(s, string(data))
+	}
+}
+
+func TestWebSocketHandshake(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	uri := newTestWebSocketServer(t, WebSocketCfg{})
+	httpURI := "http" + strings.TrimPrefix(uri, "ws")
+
+	client := newTestWebSocketClient(t)
+	ctx := context.Background()
+
+	// Not an upgrade request
+	res, err := client.Client.Get(httpURI)
+	require.NoError(err)
+	res.Body.Close()
+	assert.Equal(426, res.StatusCode)
+
+	// Cross-origin requests are rejected by default
+	header := make(http.Header)
+	header.Set("Origin", "https://example.com")
+
+	_, err = client.DialWebSocket(ctx, uri, header, WebSocketCfg{})
+	assert.ErrorContains(err, "status 403")
+
+	// Same-origin requests are accepted
+	header.Set("Origin", httpURI)
+
+	conn, err := client.DialWebSocket(ctx, uri, header, WebSocketCfg{})
+	require.NoError(err)
+	conn.Close(WebSocketCloseNormal, "")
+}
@@ -0,0 +1,121 @@
+package tracing
+
+import (
+	"sync"
+	"time"
+)
+
+type SpanKind int
+
+// Values are the ones used by OTLP.
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+	SpanKindProducer SpanKind = 4
+	SpanKindConsumer SpanKind = 5
+)
+
+type SpanStatus int
+
+// Values are the ones used by OTLP.
+const (
+	SpanStatusUnset SpanStatus = 0
+	SpanStatusOk    SpanStatus = 1
+	SpanStatusError SpanStatus = 2
+)
+
+// Span methods can be called on a nil span so that code creating spans does
+// not have to check whether tracing is enabled or not.
+type Span struct {
+	Context      SpanContext
+	ParentSpanId SpanId // zero for root spans
+
+	Name      string
+	Kind      SpanKind
+	StartTime time.Time
+	EndTime   time.Time
+
+	Attributes map[string]any
+
+	Status        SpanStatus
+	StatusMessage string
+
+	tracer *Tracer
+	mutex  sync.Mutex
+	ended  bool
+}
+
+func (s *Span) TraceId() string {
+	if s == nil {
+		return ""
+	}
+
+	return s.Context.TraceId.String()
+}
+
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	s.Name = name
+	s.mutex.Unlock()
+}
+
+// SetAttribute sets the value of an attribute. Values must be strings,
+// booleans, integers or floating point numbers.
+func (s *Span) SetAttribute(name string, value any) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]any)
+	}
+	s.Attributes[name] = value
+	s.mutex.Unlock()
+}
+
+func (s *Span) SetStatus(status SpanStatus, message string) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	s.Status = status
+	s.StatusMessage = message
+	s.mutex.Unlock()
+}
+
+// SetError marks the span as failed if err is not nil.
+func (s *Span) SetError(err error) {
+	if err == nil {
+		return
+	}
+
+	s.SetStatus(SpanStatusError, err.Error())
+}
+
+// End marks the end of the span and enqueues it for export. Calling End more
+// than once has no effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.EndTime = time.Now()
+	s.mutex.Unlock()
+
+	if s.Context.Sampled() {
+		s.tracer.enqueueSpan(s)
+	}
+}
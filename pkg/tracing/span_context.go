@@ -0,0 +1,160 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Reference: https://www.w3.org/TR/trace-context/
+
+type TraceId [16]byte
+type SpanId [8]byte
+
+var (
+	ZeroTraceId TraceId
+	ZeroSpanId  SpanId
+)
+
+func GenerateTraceId() (id TraceId) {
+	for id == ZeroTraceId {
+		rand.Read(id[:])
+	}
+
+	return
+}
+
+func GenerateSpanId() (id SpanId) {
+	for id == ZeroSpanId {
+		rand.Read(id[:])
+	}
+
+	return
+}
+
+func (id TraceId) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanId) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+type TraceFlags byte
+
+const (
+	TraceFlagSampled TraceFlags = 0x01
+)
+
+type SpanContext struct {
+	TraceId    TraceId
+	SpanId     SpanId
+	TraceFlags TraceFlags
+	TraceState string // opaque, forwarded as is
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceId != ZeroTraceId && sc.SpanId != ZeroSpanId
+}
+
+func (sc SpanContext) Sampled() bool {
+	return sc.TraceFlags&TraceFlagSampled != 0
+}
+
+// Traceparent returns the value of the traceparent header field
+// representing the span context.
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceId, sc.SpanId, sc.TraceFlags)
+}
+
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 {
+		return sc, errors.New("invalid format")
+	}
+
+	version, err := parseHex(parts[0], 1)
+	if err != nil {
+		return sc, fmt.Errorf("invalid version: %w", err)
+	}
+
+	// Version 255 is forbidden. Future versions may contain additional
+	// fields, but the first ones must be the same as in version 0.
+	if version[0] == 0xff {
+		return sc, fmt.Errorf("invalid version %q", parts[0])
+	} else if version[0] == 0x00 && len(parts) != 4 {
+		return sc, errors.New("invalid format")
+	}
+
+	traceId, err := parseHex(parts[1], len(sc.TraceId))
+	if err != nil {
+		return sc, fmt.Errorf("invalid trace id: %w", err)
+	}
+	copy(sc.TraceId[:], traceId)
+
+	spanId, err := parseHex(parts[2], len(sc.SpanId))
+	if err != nil {
+		return sc, fmt.Errorf("invalid span id: %w", err)
+	}
+	copy(sc.SpanId[:], spanId)
+
+	flags, err := parseHex(parts[3], 1)
+	if err != nil {
+		return sc, fmt.Errorf("invalid flags: %w", err)
+	}
+	sc.TraceFlags = TraceFlags(flags[0])
+
+	if !sc.IsValid() {
+		return sc, errors.New("invalid null trace or span id")
+	}
+
+	return sc, nil
+}
+
+func parseHex(s string, size int) ([]byte, error) {
+	if len(s) != size*2 {
+		return nil, fmt.Errorf("invalid length")
+	}
+
+	// The specification only allows lower case hexadecimal digits
+	if strings.ToLower(s) != s {
+		return nil, fmt.Errorf("invalid upper case digits")
+	}
+
+	return hex.DecodeString(s)
+}
+
+type spanContextKey struct{}
+type remoteSpanContextKey struct{}
+
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, span)
+}
+
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemoteSpanContext returns a context containing the span
+// context of a remote parent, usually obtained from the traceparent header
+// field of an incoming request.
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteSpanContextKey{}, sc)
+}
+
+// SpanContextFromContext returns the span context of the current span, or
+// the remote span context if there is no current span.
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.Context, true
+	}
+
+	sc, ok := ctx.Value(remoteSpanContextKey{}).(SpanContext)
+	return sc, ok
+}
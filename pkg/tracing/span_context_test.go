@@ -0,0 +1,50 @@
+package tracing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	assert := assert.New(t)
+
+	s := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	sc, err := ParseTraceparent(s)
+	if assert.NoError(err) {
+		assert.Equal("4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceId.String())
+		assert.Equal("00f067aa0ba902b7", sc.SpanId.String())
+		assert.True(sc.Sampled())
+		assert.Equal(s, sc.Traceparent())
+	}
+
+	sc, err = ParseTraceparent(
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	if assert.NoError(err) {
+		assert.False(sc.Sampled())
+	}
+
+	// Future versions can have additional fields
+	_, err = ParseTraceparent(
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-foo")
+	assert.NoError(err)
+
+	invalidValues := []string{
+		"",
+		"foo",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-foo",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902bz-01",
+	}
+
+	for _, s := range invalidValues {
+		_, err := ParseTraceparent(s)
+		assert.Error(err, s)
+	}
+}
@@ -0,0 +1,408 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"go.n16f.net/ejson"
+	"go.n16f.net/log"
+)
+
+const (
+	DefaultURI            = "http://localhost:4318/v1/traces"
+	DefaultBatchSize      = 1_000
+	DefaultMaxQueueLength = 10_000
+)
+
+type TracerCfg struct {
+	Log         *log.Logger  `json:"-"`
+	HTTPClient  *http.Client `json:"-"`
+	ServiceName string       `json:"-"`
+	Hostname    string       `json:"-"`
+
+	// The URI of the OTLP/HTTP traces endpoint of the collector.
+	URI            string            `json:"uri,omitempty"`
+	Header         map[string]string `json:"header,omitempty"`
+	BatchSize      int               `json:"batch_size,omitempty"`
+	MaxQueueLength int               `json:"max_queue_length,omitempty"`
+	Attributes     map[string]string `json:"attributes,omitempty"`
+	LogRequests    bool              `json:"log_requests,omitempty"`
+}
+
+func (cfg *TracerCfg) ValidateJSON(v *ejson.Validator) {
+	if cfg.URI != "" {
+		v.CheckStringURI("uri", cfg.URI)
+	}
+
+	if cfg.BatchSize != 0 {
+		v.CheckIntMin("batch_size", cfg.BatchSize, 1)
+	}
+
+	if cfg.MaxQueueLength != 0 {
+		v.CheckIntMin("max_queue_length", cfg.MaxQueueLength, 1)
+	}
+}
+
+// Tracer creates spans and exports them to an OpenTelemetry collector using
+// OTLP/HTTP with the JSON encoding. Tracer methods can be called on a nil
+// tracer, in which case they do nothing and return nil spans.
+type Tracer struct {
+	Cfg        TracerCfg
+	Log        *log.Logger
+	HTTPClient *http.Client
+
+	resourceAttributes map[string]any
+
+	spans     []*Span
+	spanMutex sync.Mutex
+
+	// Signals the main goroutine that a full batch of spans is available
+	flushChan chan struct{}
+
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+func NewTracer(cfg TracerCfg) (*Tracer, error) {
+	if cfg.Log == nil {
+		cfg.Log = log.DefaultLogger("tracing")
+	}
+
+	if cfg.HTTPClient == nil {
+		return nil, fmt.Errorf("missing HTTP client")
+	}
+
+	if cfg.URI == "" {
+		cfg.URI = DefaultURI
+	}
+	if _, err := url.Parse(cfg.URI); err != nil {
+		return nil, fmt.Errorf("invalid URI: %w", err)
+	}
+
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+
+	if cfg.MaxQueueLength == 0 {
+		cfg.MaxQueueLength = DefaultMaxQueueLength
+	}
+
+	attributes := make(map[string]any)
+	if cfg.ServiceName != "" {
+		attributes["service.name"] = cfg.ServiceName
+	}
+	if cfg.Hostname != "" {
+		attributes["host.name"] = cfg.Hostname
+	}
+	for name, value := range cfg.Attributes {
+		attributes[name] = value
+	}
+
+	t := Tracer{
+		Cfg:        cfg,
+		Log:        cfg.Log,
+		HTTPClient: cfg.HTTPClient,
+
+		resourceAttributes: attributes,
+
+		flushChan: make(chan struct{}, 1),
+
+		stopChan: make(chan struct{}),
+	}
+
+	return &t, nil
+}
+
+func (t *Tracer) Start() {
+	t.wg.Add(1)
+	go t.main()
+}
+
+func (t *Tracer) Stop() {
+	close(t.stopChan)
+	t.wg.Wait()
+
+	t.HTTPClient.CloseIdleConnections()
+}
+
+func (t *Tracer) main() {
+	defer t.wg.Done()
+
+	timer := time.NewTicker(time.Second)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-t.stopChan:
+			t.flush()
+			return
+
+		case <-timer.C:
+			t.flush()
+
+		case <-t.flushChan:
+			t.flush()
+		}
+	}
+}
+
+// StartSpan creates a new span and returns it with a context containing it.
+// The span is a child of the current span of the context if there is one,
+// or of the remote span context of the context if there is one. If not, the
+// span starts a new trace.
+func (t *Tracer) StartSpan(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	span := Span{
+		Name:      name,
+		Kind:      kind,
+		StartTime: time.Now(),
+
+		tracer: t,
+	}
+
+	if parent, ok := SpanContextFromContext(ctx); ok && parent.IsValid() {
+		span.Context = SpanContext{
+			TraceId:    parent.TraceId,
+			SpanId:     GenerateSpanId(),
+			TraceFlags: parent.TraceFlags,
+			TraceState: parent.TraceState,
+		}
+
+		span.ParentSpanId = parent.SpanId
+	} else {
+		span.Context = SpanContext{
+			TraceId:    GenerateTraceId(),
+			SpanId:     GenerateSpanId(),
+			TraceFlags: TraceFlagSampled,
+		}
+	}
+
+	return ContextWithSpan(ctx, &span), &span
+}
+
+func (t *Tracer) enqueueSpan(span *Span) {
+	t.spanMutex.Lock()
+	if len(t.spans) >= t.Cfg.MaxQueueLength {
+		t.spanMutex.Unlock()
+		return
+	}
+	t.spans = append(t.spans, span)
+	flush := len(t.spans) >= t.Cfg.BatchSize
+	t.spanMutex.Unlock()
+
+	// A flush may already be pending, in which case it will include the
+	// span.
+	if flush {
+		select {
+		case t.flushChan <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (t *Tracer) flush() {
+	t.spanMutex.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.spanMutex.Unlock()
+
+	for len(spans) > 0 {
+		n := min(len(spans), t.Cfg.BatchSize)
+
+		// Unlike metrics, spans are not worth retrying: losing some of them
+		// is better than accumulating them when the collector is down.
+		if err := t.sendSpans(spans[:n]); err != nil {
+			t.Log.Error("cannot send %d spans: %v", n, err)
+		}
+
+		spans = spans[n:]
+	}
+}
+
+func (t *Tracer) sendSpans(spans []*Span) error {
+	body, err := encodeOTLPSpans(spans, t.resourceAttributes)
+	if err != nil {
+		return fmt.Errorf("cannot encode spans: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", t.Cfg.URI, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("cannot create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range t.Cfg.Header {
+		req.Header.Set(name, value)
+	}
+
+	res, err := t.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot send request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if !(res.StatusCode >= 200 && res.StatusCode < 300) {
+		bodyData, _ := io.ReadAll(io.LimitReader(res.Body, 200))
+
+		bodyString := ""
+		if len(bodyData) > 0 {
+			bodyString = " (" + string(bodyData) + ")"
+		}
+
+		return fmt.Errorf("request failed with status %d%s",
+			res.StatusCode, bodyString)
+	}
+
+	return nil
+}
+
+// Reference: https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+//
+// Note that trace and span ids are encoded as hexadecimal strings and not as
+// base64 strings as for other bytes fields, and that 64 bit integers are
+// encoded as strings.
+
+type otlpTraceData struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceId           string          `json:"traceId"`
+	SpanId            string          `json:"spanId"`
+	ParentSpanId      string          `json:"parentSpanId,omitempty"`
+	TraceState        string          `json:"traceState,omitempty"`
+	Flags             uint32          `json:"flags"`
+	Name              string          `json:"name"`
+	Kind              SpanKind        `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    SpanStatus `json:"code"`
+	Message string     `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func encodeOTLPSpans(spans []*Span, resourceAttributes map[string]any) ([]byte, error) {
+	otlpSpans := make([]otlpSpan, len(spans))
+
+	for i, span := range spans {
+		span.mutex.Lock()
+
+		otlpSpans[i] = otlpSpan{
+			TraceId:           span.Context.TraceId.String(),
+			SpanId:            span.Context.SpanId.String(),
+			TraceState:        span.Context.TraceState,
+			Flags:             uint32(span.Context.TraceFlags),
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: unixNanoString(span.StartTime),
+			EndTimeUnixNano:   unixNanoString(span.EndTime),
+			Attributes:        otlpAttributes(span.Attributes),
+			Status: otlpStatus{
+				Code:    span.Status,
+				Message: span.StatusMessage,
+			},
+		}
+
+		if span.ParentSpanId != ZeroSpanId {
+			otlpSpans[i].ParentSpanId = span.ParentSpanId.String()
+		}
+
+		span.mutex.Unlock()
+	}
+
+	data := otlpTraceData{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: otlpAttributes(resourceAttributes),
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "go.n16f.net/service"},
+				Spans: otlpSpans,
+			}},
+		}},
+	}
+
+	return json.Marshal(data)
+}
+
+func unixNanoString(t time.Time) string {
+	return fmt.Sprintf("%d", t.UnixNano())
+}
+
+func otlpAttributes(attributes map[string]any) []otlpAttribute {
+	if len(attributes) == 0 {
+		return nil
+	}
+
+	otlpAttrs := make([]otlpAttribute, 0, len(attributes))
+
+	for name, value := range attributes {
+		var v otlpAnyValue
+
+		switch value := value.(type) {
+		case string:
+			v.StringValue = &value
+		case bool:
+			v.BoolValue = &value
+		case int:
+			s := fmt.Sprintf("%d", value)
+			v.IntValue = &s
+		case int64:
+			s := fmt.Sprintf("%d", value)
+			v.IntValue = &s
+		case float64:
+			v.DoubleValue = &value
+		default:
+			s := fmt.Sprintf("%v", value)
+			v.StringValue = &s
+		}
+
+		otlpAttrs = append(otlpAttrs, otlpAttribute{Key: name, Value: v})
+	}
+
+	return otlpAttrs
+}
@@ -0,0 +1,158 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracer(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var data []otlpTraceData
+	var dataMutex sync.Mutex
+
+	collector := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			body, err := io.ReadAll(req.Body)
+			require.NoError(err)
+
+			var traceData otlpTraceData
+			require.NoError(json.Unmarshal(body, &traceData))
+
+			dataMutex.Lock()
+			data = append(data, traceData)
+			dataMutex.Unlock()
+		}))
+	defer collector.Close()
+
+	tracer, err := NewTracer(TracerCfg{
+		HTTPClient:  collector.Client(),
+		ServiceName: "test",
+		URI:         collector.URL + "/v1/traces",
+	})
+	require.NoError(err)
+
+	tracer.Start()
+
+	remoteSC, err := ParseTraceparent(
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(err)
+
+	ctx := ContextWithRemoteSpanContext(context.Background(), remoteSC)
+
+	ctx, span1 := tracer.StartSpan(ctx, "span1", SpanKindServer)
+	span1.SetAttribute("foo", 42)
+
+	_, span2 := tracer.StartSpan(ctx, "span2", SpanKindClient)
+	span2.SetError(io.EOF)
+	span2.End()
+
+	span1.End()
+	span1.End()
+
+	// Spans which are not sampled are not exported
+	notSampledSC := remoteSC
+	notSampledSC.TraceFlags = 0
+	ctx = ContextWithRemoteSpanContext(context.Background(), notSampledSC)
+	_, span3 := tracer.StartSpan(ctx, "span3", SpanKindServer)
+	span3.End()
+
+	tracer.Stop()
+
+	require.Len(data, 1)
+	require.Len(data[0].ResourceSpans, 1)
+
+	resourceSpans := data[0].ResourceSpans[0]
+	if assert.Len(resourceSpans.Resource.Attributes, 1) {
+		attr := resourceSpans.Resource.Attributes[0]
+		assert.Equal("service.name", attr.Key)
+		assert.Equal("test", *attr.Value.StringValue)
+	}
+
+	require.Len(resourceSpans.ScopeSpans, 1)
+	spans := resourceSpans.ScopeSpans[0].Spans
+	require.Len(spans, 2)
+
+	assert.Equal("span2", spans[0].Name)
+	assert.Equal(SpanKindClient, spans[0].Kind)
+	assert.Equal(remoteSC.TraceId.String(), spans[0].TraceId)
+	assert.Equal(span1.Context.SpanId.String(), spans[0].ParentSpanId)
+	assert.Equal(SpanStatusError, spans[0].Status.Code)
+	assert.Equal("EOF", spans[0].Status.Message)
+
+	assert.Equal("span1", spans[1].Name)
+	assert.Equal(SpanKindServer, spans[1].Kind)
+	assert.Equal(remoteSC.TraceId.String(), spans[1].TraceId)
+	assert.Equal(remoteSC.SpanId.String(), spans[1].ParentSpanId)
+	if assert.Len(spans[1].Attributes, 1) {
+		attr := spans[1].Attributes[0]
+		assert.Equal("foo", attr.Key)
+		assert.Equal("42", *attr.Value.IntValue)
+	}
+}
+
+func TestTracerBatchFlush(t *testing.T) {
+	require := require.New(t)
+
+	batchChan := make(chan int, 10)
+
+	collector := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			var traceData otlpTraceData
+			if err := json.NewDecoder(req.Body).Decode(&traceData); err != nil {
+				w.WriteHeader(400)
+				return
+			}
+
+			batchChan <- len(traceData.ResourceSpans[0].ScopeSpans[0].Spans)
+		}))
+	defer collector.Close()
+
+	tracer, err := NewTracer(TracerCfg{
+		HTTPClient: collector.Client(),
+		URI:        collector.URL + "/v1/traces",
+		BatchSize:  2,
+	})
+	require.NoError(err)
+
+	tracer.Start()
+	defer tracer.Stop()
+
+	// A full batch is sent without waiting for the periodic flush
+	for range 2 {
+		_, span := tracer.StartSpan(context.Background(), "span",
+			SpanKindInternal)
+		span.End()
+	}
+
+	select {
+	case n := <-batchChan:
+		require.Equal(2, n)
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("batch not sent")
+	}
+}
+
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+
+	ctx := context.Background()
+
+	ctx2, span := tracer.StartSpan(ctx, "foo", SpanKindInternal)
+	assert.Equal(t, ctx, ctx2)
+	assert.Nil(t, span)
+
+	span.SetAttribute("foo", "bar")
+	span.SetError(io.EOF)
+	span.End()
+}
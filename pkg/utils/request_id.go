@@ -0,0 +1,18 @@
+package utils
+
+import "context"
+
+type requestIdContextKey struct{}
+
+// Request ids identify the processing of an incoming request across all
+// subsystems and services. They are stored in contexts so that they can be
+// propagated to outgoing HTTP requests and included in logs.
+
+func ContextWithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdContextKey{}, id)
+}
+
+func RequestIdFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdContextKey{}).(string)
+	return id
+}